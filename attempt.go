@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+)
+
+// attempt_Key returns the key attempt writes for item idx. Attempt 0 is the
+// original stage_Key; a retry n writes next to it as e.g. "enhance/1.retry2.wav",
+// so earlier attempts are kept.
+func attempt_Key(sessionId string, folder string, idx int, attempt int, suffix string) string {
+	if attempt == 0 {
+		return stage_Key(sessionId, folder, idx, suffix)
+	}
+	return stage_Key(sessionId, folder, idx, ".retry"+strconv.Itoa(attempt)+suffix)
+}
+
+// latest_Attempt returns the highest attempt of item idx whose output is in
+// storage. found is false, and attempt 0, when no attempt wrote it yet.
+func (app *App) latest_Attempt(sessionId string, folder string, idx int, suffix string) (attempt int, found bool, err error) {
+
+	base := stage_Key(sessionId, folder, idx, "")
+	objects, err := app.Storage.ListObjects(context.TODO(), base)
+	if err != nil {
+		return 0, false, err
+	}
+
+	for _, object := range objects {
+		// base 가 "enhance/1" 이면 "enhance/10.wav" 도 걸리므로 정확히 비교한다
+		rest := strings.TrimPrefix(object.Key, base)
+		if rest == suffix {
+			found = true
+			continue
+		}
+		number, ok := strings.CutPrefix(rest, ".retry")
+		if !ok || !strings.HasSuffix(number, suffix) {
+			continue
+		}
+		n, convErr := strconv.Atoi(strings.TrimSuffix(number, suffix))
+		if convErr != nil || n <= 0 {
+			continue
+		}
+		found = true
+		if n > attempt {
+			attempt = n
+		}
+	}
+	return attempt, found, nil
+}
+
+// current_Key returns the key of the latest attempt of item idx in folder,
+// or the attempt 0 key when nothing has been written yet.
+func (app *App) current_Key(sessionId string, folder string, idx int, suffix string) (string, error) {
+	attempt, _, err := app.latest_Attempt(sessionId, folder, idx, suffix)
+	if err != nil {
+		return "", err
+	}
+	return attempt_Key(sessionId, folder, idx, attempt, suffix), nil
+}
+
+// failed_Indices returns the items of indices that are missing an output
+// with any of suffixes in every attempt.
+func (app *App) failed_Indices(sessionId string, indices []int, folder string, suffixes ...string) []int {
+
+	failed := []int{}
+	for _, idx := range indices {
+		for _, suffix := range suffixes {
+			_, found, err := app.latest_Attempt(sessionId, folder, idx, suffix)
+			if err != nil {
+				panic(err)
+			}
+			if !found {
+				failed = append(failed, idx)
+				break
+			}
+		}
+	}
+	return failed
+}
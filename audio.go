@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// audioStages are the stages that store a WAV file per item, in order.
+var audioStages = []string{"original", "enhance", "equalize"}
+
+// AudioMetadata is what the header of one WAV object says about its audio.
+type AudioMetadata struct {
+	Key           string  `json:"key"`
+	Attempt       int     `json:"attempt"`
+	SampleRate    uint32  `json:"sampleRate"`
+	BitsPerSample uint16  `json:"bitsPerSample"`
+	Channels      uint16  `json:"channels"`
+	DurationSecs  float64 `json:"durationSeconds"`
+}
+
+// duration_Secs is how long the samples play. It counts frames rather than
+// trusting ByteRate, which some writers get wrong.
+func (format WavFormat) duration_Secs() float64 {
+	frames := format.DataSize / uint32(format.BlockAlign)
+	return float64(frames) / float64(format.SampleRate)
+}
+
+// inspect_Audio reads the header of the current attempt of item idx in stage.
+// Like verify_Wav, it returns an *InputError when that isn't a complete WAV file.
+func (app *App) inspect_Audio(ctx context.Context, sessionId string, stage string, idx int) (AudioMetadata, error) {
+
+	attempt := 0
+	if stage != "original" {
+		latest, _, err := app.latest_Attempt(sessionId, stage, idx, ".wav")
+		if err != nil {
+			return AudioMetadata{}, err
+		}
+		attempt = latest
+	}
+	objectKey := attempt_Key(sessionId, stage, idx, attempt, ".wav")
+
+	format, err := app.verify_Wav(ctx, objectKey)
+	if err != nil {
+		return AudioMetadata{}, err
+	}
+	return AudioMetadata{
+		Key:           objectKey,
+		Attempt:       attempt,
+		SampleRate:    format.SampleRate,
+		BitsPerSample: format.BitsPerSample,
+		Channels:      format.Channels,
+		DurationSecs:  format.duration_Secs(),
+	}, nil
+}
+
+// open_Audio opens the current attempt of item idx in stage, positioned at
+// the start of its samples. The caller closes body.
+func (app *App) open_Audio(ctx context.Context, sessionId string, stage string, idx int) (AudioMetadata, WavFormat, io.ReadCloser, error) {
+
+	metadata, err := app.inspect_Audio(ctx, sessionId, stage, idx)
+	if err != nil {
+		return AudioMetadata{}, WavFormat{}, nil, err
+	}
+
+	body, err := app.Storage.GetObject(ctx, metadata.Key)
+	if err != nil {
+		return AudioMetadata{}, WavFormat{}, nil, err
+	}
+	format, err := parse_WavHeader(body)
+	if err != nil {
+		body.Close()
+		return AudioMetadata{}, WavFormat{}, nil, &InputError{Key: metadata.Key, Problem: InputInvalid, Reason: err.Error()}
+	}
+	return metadata, format, body, nil
+}
+
+// audio_Changes lists where a stage's output doesn't keep the sample rate or
+// channel layout of the original. Enhancers are not supposed to change either.
+func audio_Changes(audio map[string]AudioMetadata) []string {
+
+	original, ok := audio["original"]
+	if !ok {
+		return nil
+	}
+
+	var changes []string
+	for _, stage := range audioStages[1:] {
+		metadata, ok := audio[stage]
+		if !ok {
+			continue
+		}
+		if metadata.SampleRate != original.SampleRate {
+			changes = append(changes, fmt.Sprintf("%v resampled %v Hz to %v Hz", stage, original.SampleRate, metadata.SampleRate))
+		}
+		if metadata.Channels != original.Channels {
+			changes = append(changes, fmt.Sprintf("%v changed %v channels to %v", stage, original.Channels, metadata.Channels))
+		}
+	}
+	return changes
+}
+
+// create_AudioInfo inspects every stage of item idx. Stages without a usable
+// WAV file yet are reported in Errors.
+func (app *App) create_AudioInfo(sessionId string, idx int) AudioInfo {
+
+	info := AudioInfo{Index: idx, Stages: map[string]AudioMetadata{}, Errors: map[string]*InputError{}}
+
+	for _, stage := range audioStages {
+		metadata, err := app.inspect_Audio(context.TODO(), sessionId, stage, idx)
+		var inputErr *InputError
+		if errors.As(err, &inputErr) {
+			info.Errors[stage] = inputErr
+			continue
+		}
+		if err != nil {
+			panic(err)
+		}
+		info.Stages[stage] = metadata
+	}
+	info.Changes = audio_Changes(info.Stages)
+
+	return info
+}
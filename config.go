@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/transcribe"
+	"github.com/aws/aws-sdk-go-v2/service/translate"
+)
+
+// AppConfig holds the settings read once at startup from the environment
+// (and from .env, when one exists in the working directory).
+type AppConfig struct {
+	Region     string
+	AccessKey  string
+	PrivateKey string
+	BucketName string
+
+	// STORAGE_BACKEND is s3 (default), local or memory.
+	StorageBackend     string
+	LocalStorageDir    string
+	LocalStorageSecret string
+	PublicBaseUrl      string
+
+	// TRANSCRIBE_PROVIDER is aws (default) or fake.
+	TranscribeProvider string
+	// TRANSLATE_PROVIDER is aws (default) or fake.
+	TranslateProvider string
+
+	// PIPELINE_POLL_SECONDS is how often pipeline runs check for stage outputs.
+	PipelinePollSeconds int
+
+	// TRANSCRIBE_POLL_SECONDS is the first backoff of the transcription job
+	// poller; TRANSCRIBE_POLL_CONCURRENCY bounds how many jobs it polls at once.
+	TranscribePollSeconds     int
+	TranscribePollConcurrency int
+
+	// STT_BATCH_CONCURRENCY is how many jobs /startSttBatch starts at once.
+	SttBatchConcurrency int
+
+	// WEBHOOK_SECRET signs webhook callbacks; callbacks are refused without one.
+	// WEBHOOK_MAX_ATTEMPTS is how often a callback is tried before giving up.
+	// WEBHOOK_ALLOWED_HOSTS lists, comma separated, the hosts callbacks may
+	// reach although they resolve to loopback or private addresses.
+	WebhookSecret       string
+	WebhookMaxAttempts  int
+	WebhookAllowedHosts []string
+
+	// JOB_STORE_PATH is the file job records and pipeline runs are kept in.
+	JobStorePath string
+
+	// PresignPolicies holds the URL lifetime, Content-Type and upload size
+	// limit of each stage (PRESIGN_<STAGE>_*). PRESIGN_MAX_LIFETIME_SECONDS
+	// caps the lifetime a request or a stage setting may ask for.
+	PresignPolicies        map[string]PresignPolicy
+	PresignMaxLifetimeSecs int64
+
+	// TUS_UPLOAD_DIR stages the chunks of resumable /uploads until they are
+	// complete; TUS_MAX_BYTES is the largest upload accepted.
+	TusUploadDir string
+	TusMaxBytes  int64
+	// ExcelMapping is where /uploadExcel finds the reference transcripts
+	// (EXCEL_SHEET, EXCEL_INDEX_COLUMN, EXCEL_REFERENCE_COLUMN and
+	// EXCEL_HEADER_ROWS). A request may override each of them.
+	ExcelMapping ExcelMapping
+}
+
+// App holds the storage backend and AWS clients shared by every handler.
+// They are built once in main and injected into setRouter.
+type App struct {
+	Config      AppConfig
+	Storage     Storage
+	Transcriber Transcriber
+	Translator  Translator
+	Pipelines   *Pipelines
+	Poller      *JobPoller
+	Store       *JobStore
+	Events      *EventHub
+	Webhooks    *Webhooks
+	Uploads     *TusUploads
+}
+
+// load_config reads every setting the server needs and reports all of the
+// missing ones together, so a bad deployment fails on the first start.
+func load_config() (AppConfig, error) {
+
+	// .env 는 선택 사항. 없으면 프로세스 환경변수만 사용
+	err := godotenv.Load()
+	if err != nil && !os.IsNotExist(err) {
+		return AppConfig{}, fmt.Errorf("couldn't read .env file: %w", err)
+	}
+
+	appConfig := AppConfig{
+		Region:     os.Getenv("S3_REGION"),
+		AccessKey:  os.Getenv("S3_ACCESSKEY"),
+		PrivateKey: os.Getenv("S3_PRIVATEDID"),
+		BucketName: os.Getenv("S3_BUCKET_NAME"),
+
+		StorageBackend:     getenv_Default("STORAGE_BACKEND", "s3"),
+		LocalStorageDir:    getenv_Default("LOCAL_STORAGE_DIR", "./storage"),
+		LocalStorageSecret: os.Getenv("LOCAL_STORAGE_SECRET"),
+		PublicBaseUrl:      getenv_Default("PUBLIC_BASE_URL", "http://localhost:8080"),
+
+		TranscribeProvider: getenv_Default("TRANSCRIBE_PROVIDER", "aws"),
+		TranslateProvider:  getenv_Default("TRANSLATE_PROVIDER", "aws"),
+
+		JobStorePath: getenv_Default("JOB_STORE_PATH", "./jobs.jsonl"),
+		TusUploadDir: getenv_Default("TUS_UPLOAD_DIR", "./uploads"),
+
+		WebhookSecret: os.Getenv("WEBHOOK_SECRET"),
+	}
+
+	appConfig.PipelinePollSeconds, err = strconv.Atoi(getenv_Default("PIPELINE_POLL_SECONDS", "10"))
+	if err != nil || appConfig.PipelinePollSeconds <= 0 {
+		return appConfig, fmt.Errorf("PIPELINE_POLL_SECONDS must be a positive number of seconds")
+	}
+	appConfig.TranscribePollSeconds, err = strconv.Atoi(getenv_Default("TRANSCRIBE_POLL_SECONDS", "5"))
+	if err != nil || appConfig.TranscribePollSeconds <= 0 {
+		return appConfig, fmt.Errorf("TRANSCRIBE_POLL_SECONDS must be a positive number of seconds")
+	}
+	appConfig.TranscribePollConcurrency, err = strconv.Atoi(getenv_Default("TRANSCRIBE_POLL_CONCURRENCY", "4"))
+	if err != nil || appConfig.TranscribePollConcurrency <= 0 {
+		return appConfig, fmt.Errorf("TRANSCRIBE_POLL_CONCURRENCY must be a positive number")
+	}
+	appConfig.SttBatchConcurrency, err = strconv.Atoi(getenv_Default("STT_BATCH_CONCURRENCY", "8"))
+	if err != nil || appConfig.SttBatchConcurrency <= 0 {
+		return appConfig, fmt.Errorf("STT_BATCH_CONCURRENCY must be a positive number")
+	}
+	appConfig.WebhookMaxAttempts, err = strconv.Atoi(getenv_Default("WEBHOOK_MAX_ATTEMPTS", "5"))
+	if err != nil || appConfig.WebhookMaxAttempts <= 0 {
+		return appConfig, fmt.Errorf("WEBHOOK_MAX_ATTEMPTS must be a positive number")
+	}
+	for _, host := range strings.Split(os.Getenv("WEBHOOK_ALLOWED_HOSTS"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			appConfig.WebhookAllowedHosts = append(appConfig.WebhookAllowedHosts, host)
+		}
+	}
+
+	appConfig.TusMaxBytes, err = strconv.ParseInt(getenv_Default("TUS_MAX_BYTES", strconv.Itoa(8<<30)), 10, 64)
+	if err != nil || appConfig.TusMaxBytes <= 0 {
+		return appConfig, fmt.Errorf("TUS_MAX_BYTES must be a positive number of bytes")
+	}
+	appConfig.PresignMaxLifetimeSecs, err = strconv.ParseInt(getenv_Default("PRESIGN_MAX_LIFETIME_SECONDS", strconv.Itoa(defaultPresignMaxLifetimeSecs)), 10, 64)
+	if err != nil || appConfig.PresignMaxLifetimeSecs <= 0 || appConfig.PresignMaxLifetimeSecs > maxPresignLifetimeSecs {
+		return appConfig, fmt.Errorf("PRESIGN_MAX_LIFETIME_SECONDS must be a number of seconds between 1 and %v", maxPresignLifetimeSecs)
+	}
+	appConfig.PresignPolicies, err = load_PresignPolicies(appConfig.PresignMaxLifetimeSecs)
+	if err != nil {
+		return appConfig, err
+	}
+
+	appConfig.ExcelMapping, err = load_ExcelMapping()
+	if err != nil {
+		return appConfig, fmt.Errorf("EXCEL_*: %w", err)
+	}
+
+	usesAws := appConfig.StorageBackend == "s3" || appConfig.TranscribeProvider == "aws" || appConfig.TranslateProvider == "aws"
+
+	var required []struct{ name, value string }
+	if usesAws {
+		required = append(required, []struct{ name, value string }{
+			{"S3_REGION", appConfig.Region},
+			{"S3_ACCESSKEY", appConfig.AccessKey},
+			{"S3_PRIVATEDID", appConfig.PrivateKey},
+		}...)
+	}
+	if appConfig.StorageBackend == "s3" {
+		required = append(required, struct{ name, value string }{"S3_BUCKET_NAME", appConfig.BucketName})
+	}
+
+	var missing []string
+	for _, setting := range required {
+		if setting.value == "" {
+			missing = append(missing, setting.name)
+		}
+	}
+	if len(missing) > 0 {
+		return appConfig, fmt.Errorf("missing settings: %s", strings.Join(missing, ", "))
+	}
+	// Amazon Transcribe 는 S3 버킷의 파일만 읽을 수 있다
+	if appConfig.TranscribeProvider == "aws" && appConfig.StorageBackend != "s3" {
+		return appConfig, fmt.Errorf("TRANSCRIBE_PROVIDER=aws needs STORAGE_BACKEND=s3, got %q", appConfig.StorageBackend)
+	}
+
+	return appConfig, nil
+}
+
+// new_App builds the AWS configuration and every service client from appConfig.
+func new_App(appConfig AppConfig) (*App, error) {
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(),
+		config.WithRegion(appConfig.Region),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(appConfig.AccessKey, appConfig.PrivateKey, "")),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load AWS config: %w", err)
+	}
+
+	s3Client := s3.NewFromConfig(cfg)
+	s3Storage := &S3Storage{
+		Client:      s3Client,
+		Presigner:   Presigner{PresignClient: s3.NewPresignClient(s3Client)},
+		BucketName:  appConfig.BucketName,
+		Region:      appConfig.Region,
+		Credentials: cfg.Credentials,
+	}
+
+	storage, err := new_Storage(appConfig, s3Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	awsTranscriber := &AwsTranscriber{
+		Client:     transcribe.NewFromConfig(cfg),
+		BucketName: appConfig.BucketName,
+	}
+
+	transcriber, err := new_Transcriber(appConfig, awsTranscriber, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	translator, err := new_Translator(appConfig, &AwsTranslator{Client: translate.NewFromConfig(cfg)})
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := new_JobStore(appConfig.JobStorePath)
+	if err != nil {
+		return nil, err
+	}
+
+	app := &App{
+		Config:      appConfig,
+		Storage:     storage,
+		Transcriber: transcriber,
+		Translator:  translator,
+		Store:       store,
+		Events:      new_EventHub(),
+	}
+	app.Pipelines = new_Pipelines(app)
+	app.Webhooks = new_Webhooks(app, appConfig.WebhookSecret, appConfig.WebhookMaxAttempts, appConfig.WebhookAllowedHosts)
+	app.Poller = new_JobPoller(app, appConfig.TranscribePollConcurrency, time.Duration(appConfig.TranscribePollSeconds)*time.Second)
+	app.Uploads, err = new_TusUploads(app, appConfig.TusUploadDir, appConfig.TusMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	return app, nil
+}
+
+func getenv_Default(key string, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
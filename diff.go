@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"io"
+)
+
+// ErrDiffTooLong is returned when two transcripts have too many words to
+// align in memory.
+var ErrDiffTooLong = errors.New("transcripts too long to diff")
+
+// Diff comparisons: the original transcript against the equalized one, or
+// each of them against the reference imported by /uploadExcel.
+const (
+	DiffEqualized = "equalized"
+	DiffReference = "reference"
+)
+
+// DiffWord is one step of a word-level diff. From is empty for an
+// insertion and To for a deletion.
+type DiffWord struct {
+	Kind string `json:"kind"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// WordDiff turns the words of the From transcript into those of To.
+type WordDiff struct {
+	From    string     `json:"from"`
+	FromKey string     `json:"fromKey"`
+	To      string     `json:"to"`
+	ToKey   string     `json:"toKey"`
+	Wer     float64    `json:"wer"`
+	Counts  EditCounts `json:"counts"`
+	Words   []DiffWord `json:"words"`
+}
+
+// diff_Words aligns to against from word by word. Words are normalized like
+// score_Transcript does, so the diff shows exactly the errors WER counts.
+func diff_Words(from string, to string, normalization TextNormalization) ([]DiffWord, EditCounts, error) {
+
+	fromWords := normalize_Words(from, normalization)
+	toWords := normalize_Words(to, normalization)
+	if normalization.IgnoreSpacing {
+		toWords = respace_Words(fromWords, toWords)
+	}
+	if (len(fromWords)+1)*(len(toWords)+1) > maxAlignCells {
+		return nil, EditCounts{}, ErrDiffTooLong
+	}
+
+	words := []DiffWord{}
+	counts := EditCounts{Reference: len(fromWords)}
+	for _, op := range align_Path(fromWords, toWords) {
+		word := DiffWord{Kind: op.Kind}
+		if op.Ref >= 0 {
+			word.From = fromWords[op.Ref]
+		}
+		if op.Hyp >= 0 {
+			word.To = toWords[op.Hyp]
+		}
+		switch op.Kind {
+		case EditHit:
+			counts.Hits++
+		case EditSubstitution:
+			counts.Substitutions++
+		case EditInsertion:
+			counts.Insertions++
+		case EditDeletion:
+			counts.Deletions++
+		}
+		words = append(words, word)
+	}
+	return words, counts, nil
+}
+
+// create_TranscriptDiffs diffs the transcripts of item idx: the original
+// against the equalized one, or both against the reference. Transcripts that
+// don't exist yet are reported in Errors and leave their diffs out.
+func (app *App) create_TranscriptDiffs(sessionId string, idx int, against string, normalization TextNormalization) (TranscriptDiffs, error) {
+
+	ctx := context.TODO()
+	result := TranscriptDiffs{Index: idx, Against: against, Normalization: normalization, Diffs: []WordDiff{}, Errors: map[string]*InputError{}}
+
+	keys := map[string]string{
+		stt_Label(true):  stt_ObjectKey(sessionId, idx, true) + ".txt",
+		stt_Label(false): stt_ObjectKey(sessionId, idx, false) + ".txt",
+		DiffReference:    reference_Key(sessionId, idx),
+	}
+	pairs := [][2]string{{stt_Label(true), stt_Label(false)}}
+	if against == DiffReference {
+		pairs = [][2]string{{DiffReference, stt_Label(true)}, {DiffReference, stt_Label(false)}}
+	}
+
+	texts := map[string]string{}
+	read := func(label string) (string, bool, error) {
+		if text, ok := texts[label]; ok {
+			return text, true, nil
+		}
+		if result.Errors[label] != nil {
+			return "", false, nil
+		}
+		text, err := app.read_SttText(ctx, keys[label])
+		var inputErr *InputError
+		if errors.As(err, &inputErr) {
+			if label == DiffReference {
+				inputErr.Reason = "no reference imported"
+			}
+			result.Errors[label] = inputErr
+			return "", false, nil
+		}
+		if err != nil {
+			return "", false, err
+		}
+		texts[label] = text
+		return text, true, nil
+	}
+
+	for _, pair := range pairs {
+		from, fromOk, err := read(pair[0])
+		if err != nil {
+			return TranscriptDiffs{}, err
+		}
+		to, toOk, err := read(pair[1])
+		if err != nil {
+			return TranscriptDiffs{}, err
+		}
+		if !fromOk || !toOk {
+			continue
+		}
+
+		words, counts, err := diff_Words(from, to, normalization)
+		if err != nil {
+			return TranscriptDiffs{}, err
+		}
+		result.Diffs = append(result.Diffs, WordDiff{
+			From:    pair[0],
+			FromKey: keys[pair[0]],
+			To:      pair[1],
+			ToKey:   keys[pair[1]],
+			Wer:     counts.rate(),
+			Counts:  counts,
+			Words:   words,
+		})
+	}
+	return result, nil
+}
+
+// diffPage is the standalone HTML view of TranscriptDiffs. It needs no
+// other file, so it can be saved and sent around as is.
+var diffPage = template.Must(template.New("diff").Parse(`<!DOCTYPE html>
+<html lang="ko">
+<head>
+<meta charset="utf-8">
+<title>Transcript diff {{.Index}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; line-height: 2; }
+h2 { font-size: 1.1em; margin-top: 2em; }
+.summary, .legend, .errors { color: #555; font-size: 0.9em; line-height: 1.5; }
+.words span { padding: 0.1em 0.2em; border-radius: 3px; }
+.substitution { background: #fff3c4; }
+.insertion { background: #d7f5dd; }
+.deletion { background: #fbd9d9; }
+del { color: #a33; }
+ins { color: #276b36; text-decoration: none; }
+</style>
+</head>
+<body>
+<h1>Item {{.Index}}</h1>
+<p class="legend"><span class="substitution">substitution</span> <span class="insertion">insertion</span> <span class="deletion">deletion</span></p>
+{{range .Diffs}}
+<h2>{{.From}} → {{.To}}</h2>
+<p class="summary">{{.FromKey}} → {{.ToKey}}: WER {{printf "%.3f" .Wer}},
+{{.Counts.Substitutions}} substitutions, {{.Counts.Insertions}} insertions, {{.Counts.Deletions}} deletions in {{.Counts.Reference}} words</p>
+<p class="words">{{range .Words}}{{if eq .Kind "hit"}}<span>{{.From}}</span>{{else if eq .Kind "substitution"}}<span class="substitution" title="{{.From}} → {{.To}}"><del>{{.From}}</del> <ins>{{.To}}</ins></span>{{else if eq .Kind "insertion"}}<span class="insertion"><ins>{{.To}}</ins></span>{{else}}<span class="deletion"><del>{{.From}}</del></span>{{end}} {{end}}</p>
+{{end}}
+{{if .Errors}}<ul class="errors">{{range $label, $err := .Errors}}<li>{{$label}}: {{$err.Error}}</li>{{end}}</ul>{{end}}
+</body>
+</html>
+`))
+
+func write_DiffPage(w io.Writer, diffs TranscriptDiffs) error {
+	return diffPage.Execute(w, diffs)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// Event types sent on /events. Presign, transcription, cleanup, translation,
+// upload and export events mirror the job records; a failed one is sent as
+// EventError with Kind set to what failed.
+const (
+	EventPresign       = RecordPresign
+	EventTranscription = RecordTranscription
+	EventCleanup       = RecordCleanup
+	EventTranslation   = RecordTranslation
+	EventUpload        = RecordUpload
+	EventExport        = RecordExport
+	EventStatus        = "status"
+	EventStage         = "stage"
+	EventError         = "error"
+)
+
+// Event is one progress update of a session's batch.
+type Event struct {
+	EventId   int64     `json:"eventId"`
+	Type      string    `json:"type"`
+	Kind      string    `json:"kind,omitempty"`
+	SessionId string    `json:"sessionId,omitempty"`
+	RunId     string    `json:"runId,omitempty"`
+	Name      string    `json:"name,omitempty"`
+	Indices   []int     `json:"indices,omitempty"`
+	Status    string    `json:"status,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// EventFilter selects the events one subscriber receives.
+// An empty RunId matches every event of the session.
+type EventFilter struct {
+	SessionId string
+	RunId     string
+}
+
+func (filter EventFilter) matches(event Event) bool {
+	if event.SessionId != filter.SessionId {
+		return false
+	}
+	return filter.RunId == "" || event.RunId == filter.RunId
+}
+
+// EventHub fans events out to the /events subscribers. It keeps the last
+// events so a reconnecting EventSource can resume from Last-Event-ID.
+type EventHub struct {
+	mutex       sync.Mutex
+	lastId      int64
+	recent      []Event
+	subscribers map[chan Event]EventFilter
+}
+
+const (
+	eventHistory    = 512
+	eventBufferSize = 64
+)
+
+func new_EventHub() *EventHub {
+	return &EventHub{subscribers: map[chan Event]EventFilter{}}
+}
+
+// publish numbers event and sends it to every matching subscriber. A
+// subscriber that can't keep up is dropped; its client reconnects and
+// resumes from the history.
+func (hub *EventHub) publish(event Event) {
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	hub.lastId++
+	event.EventId = hub.lastId
+	event.CreatedAt = time.Now().UTC()
+
+	hub.recent = append(hub.recent, event)
+	if len(hub.recent) > eventHistory {
+		hub.recent = hub.recent[len(hub.recent)-eventHistory:]
+	}
+
+	for events, filter := range hub.subscribers {
+		if !filter.matches(event) {
+			continue
+		}
+		select {
+		case events <- event:
+		default:
+			delete(hub.subscribers, events)
+			close(events)
+		}
+	}
+}
+
+// subscribe returns the events after lastEventId that are still in the
+// history, and a channel for every later one.
+func (hub *EventHub) subscribe(filter EventFilter, lastEventId int64) ([]Event, chan Event) {
+
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	var missed []Event
+	for _, event := range hub.recent {
+		if event.EventId > lastEventId && filter.matches(event) {
+			missed = append(missed, event)
+		}
+	}
+
+	events := make(chan Event, eventBufferSize)
+	hub.subscribers[events] = filter
+	return missed, events
+}
+
+func (hub *EventHub) unsubscribe(events chan Event) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	if _, ok := hub.subscribers[events]; ok {
+		delete(hub.subscribers, events)
+		close(events)
+	}
+}
+
+func (hub *EventHub) subscriber_Count() int {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+	return len(hub.subscribers)
+}
+
+// record_Event turns a job record into the event sent for it.
+func record_Event(record JobRecord) Event {
+
+	event := Event{
+		Type:      record.Kind,
+		SessionId: record.SessionId,
+		Name:      record.Name,
+		Indices:   record.Indices,
+		Status:    record.Detail["status"],
+		Error:     record.Error,
+	}
+	if record.Outcome == OutcomeError {
+		event.Type = EventError
+		event.Kind = record.Kind
+	}
+	return event
+}
+
+// stream_Events writes the events matching filter as Server-Sent Events
+// until the client goes away, with a comment line every keepAlive so
+// proxies don't close an idle stream.
+func stream_Events(c *gin.Context, hub *EventHub, filter EventFilter, keepAlive time.Duration) {
+
+	lastEventId, _ := strconv.ParseInt(c.GetHeader("Last-Event-ID"), 10, 64)
+	missed, events := hub.subscribe(filter, lastEventId)
+	defer hub.unsubscribe(events)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	send := func(event Event) {
+		c.Render(-1, sse.Event{Id: strconv.FormatInt(event.EventId, 10), Event: event.Type, Data: event})
+	}
+	for _, event := range missed {
+		send(event)
+	}
+	c.Writer.Flush()
+
+	ticker := time.NewTicker(keepAlive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			send(event)
+			c.Writer.Flush()
+		case <-ticker.C:
+			c.Writer.WriteString(": keep-alive\n\n")
+			c.Writer.Flush()
+		}
+	}
+}
@@ -0,0 +1,530 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidWorkbook is returned when an uploaded file isn't an .xlsx
+// workbook with the sheet the mapping names.
+var ErrInvalidWorkbook = errors.New("invalid workbook")
+
+// ExcelMapping says where the reference transcripts are in an uploaded
+// workbook: the item number (N of original/N.wav) in IndexColumn and what
+// was said in ReferenceColumn, below HeaderRows rows of headings. An empty
+// Sheet is the first one.
+type ExcelMapping struct {
+	Sheet           string `json:"sheet"`
+	IndexColumn     string `json:"indexColumn"`
+	ReferenceColumn string `json:"referenceColumn"`
+	HeaderRows      int    `json:"headerRows"`
+}
+
+func default_ExcelMapping() ExcelMapping {
+	return ExcelMapping{IndexColumn: "A", ReferenceColumn: "B", HeaderRows: 1}
+}
+
+const (
+	// maxExcelUploadBytes bounds the request body of /uploadExcel.
+	maxExcelUploadBytes = 32 << 20
+	// maxXlsxPartBytes bounds each unpacked part of an uploaded workbook.
+	maxXlsxPartBytes = 64 << 20
+)
+
+var excelColumnPattern = regexp.MustCompile(`^[A-Z]{1,3}$`)
+
+// with_Overrides returns mapping with every non-empty override applied, as
+// sent in the /uploadExcel form, and checks the result.
+func (mapping ExcelMapping) with_Overrides(sheet string, indexColumn string, referenceColumn string, headerRows string) (ExcelMapping, error) {
+
+	if sheet != "" {
+		mapping.Sheet = sheet
+	}
+	if indexColumn != "" {
+		mapping.IndexColumn = strings.ToUpper(indexColumn)
+	}
+	if referenceColumn != "" {
+		mapping.ReferenceColumn = strings.ToUpper(referenceColumn)
+	}
+	if headerRows != "" {
+		rows, err := strconv.Atoi(headerRows)
+		if err != nil {
+			return mapping, fmt.Errorf("headerRows must be a number, got %q", headerRows)
+		}
+		mapping.HeaderRows = rows
+	}
+
+	if !excelColumnPattern.MatchString(mapping.IndexColumn) || !excelColumnPattern.MatchString(mapping.ReferenceColumn) {
+		return mapping, fmt.Errorf("columns must be letters like A or AB, got %q and %q", mapping.IndexColumn, mapping.ReferenceColumn)
+	}
+	if mapping.IndexColumn == mapping.ReferenceColumn {
+		return mapping, fmt.Errorf("index and reference can't both be column %v", mapping.IndexColumn)
+	}
+	if mapping.HeaderRows < 0 {
+		return mapping, fmt.Errorf("headerRows must not be negative")
+	}
+	return mapping, nil
+}
+
+// load_ExcelMapping reads EXCEL_SHEET, EXCEL_INDEX_COLUMN,
+// EXCEL_REFERENCE_COLUMN and EXCEL_HEADER_ROWS over the defaults.
+func load_ExcelMapping() (ExcelMapping, error) {
+	return default_ExcelMapping().with_Overrides(
+		os.Getenv("EXCEL_SHEET"),
+		os.Getenv("EXCEL_INDEX_COLUMN"),
+		os.Getenv("EXCEL_REFERENCE_COLUMN"),
+		os.Getenv("EXCEL_HEADER_ROWS"))
+}
+
+// The parts of SpreadsheetML the importer reads. Element names are matched
+// without their namespaces.
+type xlsxWorkbook struct {
+	Sheets []xlsxSheet `xml:"sheets>sheet"`
+}
+
+// xlsxSheet keeps every attribute because the namespace of r:id differs
+// between transitional and strict workbooks.
+type xlsxSheet struct {
+	Name  string     `xml:"name,attr"`
+	Attrs []xml.Attr `xml:",any,attr"`
+}
+
+func (sheet xlsxSheet) relationship_Id() string {
+	for _, attr := range sheet.Attrs {
+		if attr.Name.Local == "id" && attr.Name.Space != "" {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		Id     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxText is a shared string or an inline string: plain, or rich text
+// runs. Phonetic guides (rPh) are not part of the text.
+type xlsxText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (text xlsxText) String() string {
+	value := text.Text
+	for _, run := range text.Runs {
+		value += run.Text
+	}
+	return value
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Number int `xml:"r,attr"`
+		Cells  []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// ExcelRow is one worksheet row: its 1-based number and the text of each
+// cell by column letter.
+type ExcelRow struct {
+	Number int
+	Cells  map[string]string
+}
+
+// read_XlsxPart decodes one part of the workbook. Parts that unpack to more
+// than maxXlsxPartBytes are refused, so a small zip of repetitive XML can't
+// fill memory with cells.
+func read_XlsxPart(files map[string]*zip.File, name string, part interface{}) error {
+
+	file, ok := files[name]
+	if !ok {
+		return fmt.Errorf("%w: no %v", ErrInvalidWorkbook, name)
+	}
+	if file.UncompressedSize64 > maxXlsxPartBytes {
+		return fmt.Errorf("%w: %v unpacks to %v bytes, more than %v", ErrInvalidWorkbook, name, file.UncompressedSize64, maxXlsxPartBytes)
+	}
+	body, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidWorkbook, err)
+	}
+	defer body.Close()
+
+	// 헤더의 크기를 속인 파일도 있으니 읽는 양도 제한한다
+	limited := &io.LimitedReader{R: body, N: maxXlsxPartBytes + 1}
+	if err := xml.NewDecoder(limited).Decode(part); err != nil {
+		if limited.N == 0 {
+			return fmt.Errorf("%w: %v unpacks to more than %v bytes", ErrInvalidWorkbook, name, maxXlsxPartBytes)
+		}
+		return fmt.Errorf("%w: %v: %v", ErrInvalidWorkbook, name, err)
+	}
+	return nil
+}
+
+// column_Letters returns the column of a cell reference such as "AB12".
+func column_Letters(ref string) string {
+	return strings.TrimRight(ref, "0123456789")
+}
+
+// column_Name is the letters of the 0-based column number.
+func column_Name(column int) string {
+	name := ""
+	for column++; column > 0; column = (column - 1) / 26 {
+		name = string(rune('A'+(column-1)%26)) + name
+	}
+	return name
+}
+
+// read_XlsxRows reads the rows of sheet, or of the first sheet when sheet
+// is empty, and returns the sheet's name with them.
+func read_XlsxRows(r io.ReaderAt, size int64, sheet string) (string, []ExcelRow, error) {
+
+	archive, err := zip.NewReader(r, size)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrInvalidWorkbook, err)
+	}
+	files := map[string]*zip.File{}
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	var workbook xlsxWorkbook
+	var relationships xlsxRelationships
+	if err := read_XlsxPart(files, "xl/workbook.xml", &workbook); err != nil {
+		return "", nil, err
+	}
+	if err := read_XlsxPart(files, "xl/_rels/workbook.xml.rels", &relationships); err != nil {
+		return "", nil, err
+	}
+	if len(workbook.Sheets) == 0 {
+		return "", nil, fmt.Errorf("%w: no sheets", ErrInvalidWorkbook)
+	}
+
+	chosen := -1
+	for i, candidate := range workbook.Sheets {
+		if sheet == "" || candidate.Name == sheet {
+			chosen = i
+			break
+		}
+	}
+	if chosen < 0 {
+		return "", nil, fmt.Errorf("%w: no sheet named %q", ErrInvalidWorkbook, sheet)
+	}
+	sheet = workbook.Sheets[chosen].Name
+
+	sheetPart := ""
+	for _, relationship := range relationships.Relationships {
+		if relationship.Id == workbook.Sheets[chosen].relationship_Id() {
+			// 대상은 xl/ 기준 상대 경로이거나 패키지 루트 기준 절대 경로다
+			if strings.HasPrefix(relationship.Target, "/") {
+				sheetPart = strings.TrimPrefix(relationship.Target, "/")
+			} else {
+				sheetPart = path.Join("xl", relationship.Target)
+			}
+		}
+	}
+
+	if sheetPart == "" {
+		return "", nil, fmt.Errorf("%w: sheet %q has no worksheet part", ErrInvalidWorkbook, sheet)
+	}
+
+	var sharedStrings xlsxSharedStrings
+	if _, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := read_XlsxPart(files, "xl/sharedStrings.xml", &sharedStrings); err != nil {
+			return "", nil, err
+		}
+	}
+	var worksheet xlsxWorksheet
+	if err := read_XlsxPart(files, sheetPart, &worksheet); err != nil {
+		return "", nil, err
+	}
+
+	rows := make([]ExcelRow, 0, len(worksheet.Rows))
+	number := 0
+	for _, sheetRow := range worksheet.Rows {
+		// r 속성은 생략될 수 있으니 그때는 앞 행/셀 다음으로 친다
+		number++
+		if sheetRow.Number > 0 {
+			number = sheetRow.Number
+		}
+		row := ExcelRow{Number: number, Cells: map[string]string{}}
+
+		column := -1
+		for _, cell := range sheetRow.Cells {
+			column++
+			letters := column_Letters(cell.Ref)
+			if letters == "" {
+				letters = column_Name(column)
+			} else {
+				column = column_Number(letters)
+			}
+
+			value := cell.Value
+			switch cell.Type {
+			case "s":
+				i, err := strconv.Atoi(value)
+				if err != nil || i < 0 || i >= len(sharedStrings.Items) {
+					return "", nil, fmt.Errorf("%w: cell %v%v refers to shared string %q", ErrInvalidWorkbook, letters, number, value)
+				}
+				value = sharedStrings.Items[i].String()
+			case "inlineStr":
+				value = cell.Inline.String()
+			}
+			row.Cells[letters] = value
+		}
+		rows = append(rows, row)
+	}
+	return sheet, rows, nil
+}
+
+// column_Number is the 0-based number of column letters; column_Name undoes it.
+func column_Number(letters string) int {
+	number := 0
+	for _, letter := range letters {
+		number = number*26 + int(letter-'A'+1)
+	}
+	return number - 1
+}
+
+// parse_ItemNumber reads an item number cell. Numbers typed into Excel
+// are stored as decimals, so "3" and "3.0" are both item 3.
+func parse_ItemNumber(value string) (int, bool) {
+	number, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || number < 1 || number != math.Trunc(number) || number > math.MaxInt32 {
+		return 0, false
+	}
+	return int(number), true
+}
+
+// ExcelRowIssue says why a row wasn't imported.
+type ExcelRowIssue struct {
+	Row    int    `json:"row"`
+	Reason string `json:"reason"`
+}
+
+// ExcelImport reports an /uploadExcel import. Indices are the 0-based items
+// that got a reference; Skipped rows had nothing to import or name an item
+// without original audio, and Malformed rows couldn't be read.
+type ExcelImport struct {
+	ObjectKey string          `json:"objectKey"`
+	Sheet     string          `json:"sheet"`
+	Mapping   ExcelMapping    `json:"mapping"`
+	Parsed    int             `json:"parsed"`
+	Indices   []int           `json:"indices"`
+	Skipped   []ExcelRowIssue `json:"skipped"`
+	Malformed []ExcelRowIssue `json:"malformed"`
+}
+
+// excel_Filename is the name an uploaded workbook is stored under: the last
+// element of the name the client sent, so it can't leave the session.
+func excel_Filename(name string) (string, error) {
+	filename := path.Base(strings.ReplaceAll(name, `\`, "/"))
+	if filename == "" || filename == "." || filename == "/" || filename == ".." {
+		return "", fmt.Errorf("excel uploads need a filename")
+	}
+	return filename, nil
+}
+
+// reference_Key is where the reference transcript of item idx is stored.
+func reference_Key(sessionId string, idx int) string {
+	return stage_Key(sessionId, "reference", idx, ".txt")
+}
+
+// import_Excel stores the reference transcript of every row of the
+// workbook in r that names an item of the session.
+func (app *App) import_Excel(sessionId string, objectKey string, r io.ReaderAt, size int64, mapping ExcelMapping) (ExcelImport, error) {
+
+	report := ExcelImport{ObjectKey: objectKey, Mapping: mapping, Indices: []int{}, Skipped: []ExcelRowIssue{}, Malformed: []ExcelRowIssue{}}
+
+	sheet, rows, err := read_XlsxRows(r, size, mapping.Sheet)
+	if err != nil {
+		return report, err
+	}
+	report.Sheet = sheet
+
+	// 원본 오디오가 있는 항목만 받는다
+	originals, err := app.Storage.ListObjects(context.TODO(), session_Key(sessionId, "original/"))
+	if err != nil {
+		return report, err
+	}
+	hasOriginal := map[string]bool{}
+	for _, object := range originals {
+		hasOriginal[object.Key] = true
+	}
+
+	seen := map[int]int{}
+	for _, row := range rows {
+		if row.Number <= mapping.HeaderRows {
+			continue
+		}
+		number := strings.TrimSpace(row.Cells[mapping.IndexColumn])
+		reference := strings.TrimSpace(row.Cells[mapping.ReferenceColumn])
+
+		item, ok := parse_ItemNumber(number)
+		switch {
+		case number == "" && reference == "":
+			report.Skipped = append(report.Skipped, ExcelRowIssue{row.Number, "empty row"})
+		case !ok:
+			report.Malformed = append(report.Malformed, ExcelRowIssue{row.Number, fmt.Sprintf("item number %q is not a whole number from 1", number)})
+		case seen[item] != 0:
+			report.Malformed = append(report.Malformed, ExcelRowIssue{row.Number, fmt.Sprintf("item %v is already on row %v", item, seen[item])})
+		case reference == "":
+			report.Skipped = append(report.Skipped, ExcelRowIssue{row.Number, fmt.Sprintf("item %v has no reference text", item)})
+		case !hasOriginal[stage_Key(sessionId, "original", item-1, ".wav")]:
+			report.Skipped = append(report.Skipped, ExcelRowIssue{row.Number, fmt.Sprintf("item %v has no original audio", item)})
+		default:
+			err := app.put_Text(reference_Key(sessionId, item-1), reference)
+			if err != nil {
+				app.record(RecordUpload, sessionId, "importExcel", report.Indices, err, map[string]string{"objectKey": objectKey})
+				return report, err
+			}
+			report.Indices = append(report.Indices, item-1)
+			report.Parsed++
+		}
+		if ok && seen[item] == 0 {
+			seen[item] = row.Number
+		}
+	}
+
+	app.record(RecordUpload, sessionId, "importExcel", report.Indices, nil, map[string]string{"objectKey": objectKey, "sheet": sheet})
+	return report, nil
+}
+
+// load_References reads the stored reference transcripts of indices and
+// leaves out the items that have none.
+func (app *App) load_References(sessionId string, indices []int) (map[int]string, error) {
+
+	references := map[int]string{}
+	for _, idx := range indices {
+		reference, err := app.read_SttText(context.TODO(), reference_Key(sessionId, idx))
+		var inputErr *InputError
+		if errors.As(err, &inputErr) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		references[idx] = reference
+	}
+	return references, nil
+}
+
+// xlsxMaxCellChars is the most characters a cell of an .xlsx workbook holds.
+const xlsxMaxCellChars = 32767
+
+// xlsxParts are the parts of a workbook with the single sheet write_Xlsx
+// writes, other than the sheet itself.
+var xlsxParts = []struct{ Name, Content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+// cell_Text is how a report cell reads in a CSV file or as an .xlsx string.
+// Cells are strings, ints, float64s or nil for an empty cell.
+func cell_Text(value interface{}) string {
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case int:
+		return strconv.Itoa(value)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// write_Xlsx writes rows as the only sheet of an .xlsx workbook. Numbers
+// are stored as numbers so they can be sorted and averaged; text is stored
+// inline, cut to xlsxMaxCellChars.
+func write_Xlsx(w io.Writer, sheet string, rows [][]interface{}) error {
+
+	archive := zip.NewWriter(w)
+	for _, part := range xlsxParts {
+		file, err := archive.Create(part.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(file, part.Content); err != nil {
+			return err
+		}
+	}
+
+	var sheetName strings.Builder
+	if err := xml.EscapeText(&sheetName, []byte(sheet)); err != nil {
+		return err
+	}
+	file, err := archive.Create("xl/workbook.xml")
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(file, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="`+sheetName.String()+`" sheetId="1" r:id="rId1"/></sheets></workbook>`)
+	if err != nil {
+		return err
+	}
+
+	file, err = archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return err
+	}
+	sheetData := bufio.NewWriter(file)
+	sheetData.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	for i, row := range rows {
+		fmt.Fprintf(sheetData, `<row r="%d">`, i+1)
+		for column, value := range row {
+			ref := column_Name(column) + strconv.Itoa(i+1)
+			switch value := value.(type) {
+			case nil:
+			case int, float64:
+				fmt.Fprintf(sheetData, `<c r="%v"><v>%v</v></c>`, ref, cell_Text(value))
+			default:
+				text := []rune(cell_Text(value))
+				if len(text) > xlsxMaxCellChars {
+					text = text[:xlsxMaxCellChars]
+				}
+				fmt.Fprintf(sheetData, `<c r="%v" t="inlineStr"><is><t xml:space="preserve">`, ref)
+				xml.EscapeText(sheetData, []byte(string(text)))
+				sheetData.WriteString(`</t></is></c>`)
+			}
+		}
+		sheetData.WriteString(`</row>`)
+	}
+	sheetData.WriteString(`</sheetData></worksheet>`)
+	if err := sheetData.Flush(); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
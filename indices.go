@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// IndexRange selects the items Start, Start+1, ..., End-1, so a large batch
+// can be split into ranges that don't overlap.
+type IndexRange struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// maxPresignIndices bounds how many items one presign request covers,
+// like maxSttBatch does for /startSttBatch.
+const maxPresignIndices = 1000
+
+// select_Indices returns the items a request names: indices when given,
+// otherwise indexRange, otherwise 0..count-1. Negative or repeated indices,
+// empty ranges and more than limit items are rejected; the limit is
+// checked before a range or count is expanded.
+func select_Indices(indices []int, indexRange *IndexRange, count int, limit int) ([]int, error) {
+
+	switch {
+	case len(indices) > 0 && indexRange != nil:
+		return nil, errors.New("give either indices or range, not both")
+	case len(indices) > 0:
+	case indexRange != nil:
+		if indexRange.Start < 0 || indexRange.End <= indexRange.Start {
+			return nil, fmt.Errorf("range %v..%v is empty or negative", indexRange.Start, indexRange.End)
+		}
+		if indexRange.End-indexRange.Start > limit {
+			return nil, fmt.Errorf("at most %v items can be requested at once, got %v", limit, indexRange.End-indexRange.Start)
+		}
+		for idx := indexRange.Start; idx < indexRange.End; idx++ {
+			indices = append(indices, idx)
+		}
+	case count > 0:
+		if count > limit {
+			return nil, fmt.Errorf("at most %v items can be requested at once, got %v", limit, count)
+		}
+		indices = count_Indices(count)
+	default:
+		return nil, errors.New("one of indices, range or a positive count is required")
+	}
+
+	if len(indices) > limit {
+		return nil, fmt.Errorf("at most %v items can be requested at once, got %v", limit, len(indices))
+	}
+
+	seen := map[int]bool{}
+	for _, idx := range indices {
+		if idx < 0 {
+			return nil, fmt.Errorf("index %v is negative", idx)
+		}
+		if seen[idx] {
+			return nil, fmt.Errorf("index %v is listed twice", idx)
+		}
+		seen[idx] = true
+	}
+	return indices, nil
+}
+
+// count_Indices returns 0..count-1, the indices a count based request covers.
+func count_Indices(count int) []int {
+	indices := make([]int, 0, count)
+	for i := 0; i < count; i++ {
+		indices = append(indices, i)
+	}
+	return indices
+}
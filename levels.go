@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sort"
+)
+
+// maxLevelIndices bounds /getAudioLevels, which reads every sample of up to
+// three files per item.
+const maxLevelIndices = 50
+
+const (
+	// minLevelDb stands in for the level of digital silence, which JSON
+	// can't hold as -Inf.
+	minLevelDb = -120.0
+	// clipLevel is how close to full scale a sample must be to count as clipped.
+	clipLevel = 0.999
+	// levelSegmentSecs is the length of the segments the noise floor and
+	// loudness are measured over.
+	levelSegmentSecs = 0.1
+	// BS.1770 gating: 400ms blocks overlapping by 75%, an absolute gate at
+	// -70 LUFS and a relative gate 10 LU below the absolute-gated loudness.
+	loudnessBlockSegments = 4
+	loudnessAbsoluteGate  = -70.0
+	loudnessRelativeGate  = -10.0
+	// The noise floor is a quiet segment and the signal a loud one.
+	noisePercentile  = 0.1
+	signalPercentile = 0.95
+	// maxFloatSample bounds float samples, which may go past full scale,
+	// so their squares and sums stay finite.
+	maxFloatSample = 1 << 16
+)
+
+// AudioLevels are statistics over every sample of one WAV object. Levels are
+// in dB relative to full scale; the loudness is a BS.1770 estimate that
+// weights every channel alike.
+type AudioLevels struct {
+	Key           string  `json:"key"`
+	Attempt       int     `json:"attempt"`
+	RmsDb         float64 `json:"rmsDb"`
+	PeakDb        float64 `json:"peakDb"`
+	LoudnessLufs  float64 `json:"loudnessLufs"`
+	ClippingRatio float64 `json:"clippingRatio"`
+	NoiseFloorDb  float64 `json:"noiseFloorDb"`
+	SnrDb         float64 `json:"snrDb"`
+}
+
+// LevelChange is what a stage did to the levels of the original.
+type LevelChange struct {
+	RmsDb         float64 `json:"rmsDb"`
+	PeakDb        float64 `json:"peakDb"`
+	LoudnessLu    float64 `json:"loudnessLu"`
+	ClippingRatio float64 `json:"clippingRatio"`
+	NoiseFloorDb  float64 `json:"noiseFloorDb"`
+	SnrDb         float64 `json:"snrDb"`
+}
+
+func level_Change(original AudioLevels, levels AudioLevels) LevelChange {
+	return LevelChange{
+		RmsDb:         levels.RmsDb - original.RmsDb,
+		PeakDb:        levels.PeakDb - original.PeakDb,
+		LoudnessLu:    levels.LoudnessLufs - original.LoudnessLufs,
+		ClippingRatio: levels.ClippingRatio - original.ClippingRatio,
+		NoiseFloorDb:  levels.NoiseFloorDb - original.NoiseFloorDb,
+		SnrDb:         levels.SnrDb - original.SnrDb,
+	}
+}
+
+// level_Db converts a mean square to dB, with silence (or NaN) at minLevelDb.
+func level_Db(meanSquare float64) float64 {
+	if !(meanSquare > 0) {
+		return minLevelDb
+	}
+	return math.Max(10*math.Log10(meanSquare), minLevelDb)
+}
+
+func loudness_Lufs(meanSquare float64) float64 {
+	if !(meanSquare > 0) {
+		return minLevelDb
+	}
+	return math.Max(-0.691+10*math.Log10(meanSquare), minLevelDb)
+}
+
+// biquad is one second-order IIR section in transposed direct form II.
+type biquad struct {
+	b0, b1, b2, a1, a2 float64
+	z1, z2             float64
+}
+
+func (f *biquad) filter(x float64) float64 {
+	y := f.b0*x + f.z1
+	f.z1 = f.b1*x - f.a1*y + f.z2
+	f.z2 = f.b2*x - f.a2*y
+	return y
+}
+
+// k_Weighting returns the BS.1770 pre-filter (a high shelf, then a high pass)
+// designed for sampleRate rather than only the 48kHz coefficients.
+func k_Weighting(sampleRate float64) [2]biquad {
+
+	// 고역 쉘프: +4dB, 1500Hz
+	gain := math.Pow(10, 4.0/40)
+	w0 := 2 * math.Pi * 1500 / sampleRate
+	alpha := math.Sin(w0) / (2 / math.Sqrt2)
+	cos := math.Cos(w0)
+	root := 2 * math.Sqrt(gain) * alpha
+	a0 := (gain + 1) - (gain-1)*cos + root
+	shelf := biquad{
+		b0: gain * ((gain + 1) + (gain-1)*cos + root) / a0,
+		b1: -2 * gain * ((gain - 1) + (gain+1)*cos) / a0,
+		b2: gain * ((gain + 1) + (gain-1)*cos - root) / a0,
+		a1: 2 * ((gain - 1) - (gain+1)*cos) / a0,
+		a2: ((gain + 1) - (gain-1)*cos - root) / a0,
+	}
+
+	// 고역 통과: 38Hz
+	w0 = 2 * math.Pi * 38 / sampleRate
+	alpha = math.Sin(w0) / (2 * 0.5)
+	cos = math.Cos(w0)
+	a0 = 1 + alpha
+	highPass := biquad{
+		b0: (1 + cos) / 2 / a0,
+		b1: -(1 + cos) / a0,
+		b2: (1 + cos) / 2 / a0,
+		a1: -2 * cos / a0,
+		a2: (1 - alpha) / a0,
+	}
+
+	return [2]biquad{shelf, highPass}
+}
+
+// levelMeter accumulates AudioLevels one frame at a time, so a file never
+// has to be held in memory.
+type levelMeter struct {
+	filters       [][2]biquad
+	segmentFrames int
+
+	samples    int64
+	sumSquares float64
+	peak       float64
+	clipped    int64
+
+	frames          int
+	segmentSquares  float64
+	segmentWeighted float64
+	// segments has the mean square of each whole segment, and weighted the
+	// K-weighted mean square summed over channels.
+	segments []float64
+	weighted []float64
+}
+
+func new_LevelMeter(format WavFormat) *levelMeter {
+
+	meter := &levelMeter{
+		filters:       make([][2]biquad, format.Channels),
+		segmentFrames: int(math.Max(1, math.Round(float64(format.SampleRate)*levelSegmentSecs))),
+	}
+	for channel := range meter.filters {
+		meter.filters[channel] = k_Weighting(float64(format.SampleRate))
+	}
+	return meter
+}
+
+func (meter *levelMeter) add(frame []float64) {
+
+	for channel, sample := range frame {
+		square := sample * sample
+		meter.sumSquares += square
+		meter.segmentSquares += square
+		magnitude := math.Abs(sample)
+		if magnitude > meter.peak {
+			meter.peak = magnitude
+		}
+		if magnitude >= clipLevel {
+			meter.clipped++
+		}
+
+		filters := &meter.filters[channel]
+		weighted := filters[1].filter(filters[0].filter(sample))
+		meter.segmentWeighted += weighted * weighted
+	}
+	meter.samples += int64(len(frame))
+
+	meter.frames++
+	if meter.frames == meter.segmentFrames {
+		meter.end_Segment()
+	}
+}
+
+func (meter *levelMeter) end_Segment() {
+	channels := float64(len(meter.filters))
+	meter.segments = append(meter.segments, meter.segmentSquares/(float64(meter.frames)*channels))
+	meter.weighted = append(meter.weighted, meter.segmentWeighted/float64(meter.frames))
+	meter.frames, meter.segmentSquares, meter.segmentWeighted = 0, 0, 0
+}
+
+func (meter *levelMeter) levels() AudioLevels {
+
+	// 한 구간도 안 되는 짧은 파일은 남은 조각을 구간으로 친다
+	if len(meter.segments) == 0 && meter.frames > 0 {
+		meter.end_Segment()
+	}
+
+	// 샘플이 없으면 0/0 이 NaN 이 되어 JSON 으로 보낼 수 없다
+	levels := AudioLevels{
+		RmsDb:        minLevelDb,
+		PeakDb:       level_Db(meter.peak * meter.peak),
+		LoudnessLufs: gated_Loudness(meter.weighted),
+	}
+	if meter.samples > 0 {
+		levels.RmsDb = level_Db(meter.sumSquares / float64(meter.samples))
+		levels.ClippingRatio = float64(meter.clipped) / float64(meter.samples)
+	}
+
+	sorted := append([]float64(nil), meter.segments...)
+	sort.Float64s(sorted)
+	levels.NoiseFloorDb = level_Db(percentile(sorted, noisePercentile))
+	levels.SnrDb = level_Db(percentile(sorted, signalPercentile)) - levels.NoiseFloorDb
+
+	return levels
+}
+
+// percentile returns the value at fraction p of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+// gated_Loudness is the BS.1770 integrated loudness of the K-weighted
+// segment energies.
+func gated_Loudness(segments []float64) float64 {
+
+	var blocks []float64
+	for start := 0; start+loudnessBlockSegments <= len(segments); start++ {
+		blocks = append(blocks, mean(segments[start:start+loudnessBlockSegments]))
+	}
+	if len(blocks) == 0 && len(segments) > 0 {
+		blocks = []float64{mean(segments)}
+	}
+
+	gated := func(threshold float64) []float64 {
+		var kept []float64
+		for _, block := range blocks {
+			if loudness_Lufs(block) > threshold {
+				kept = append(kept, block)
+			}
+		}
+		return kept
+	}
+
+	absolute := gated(loudnessAbsoluteGate)
+	if len(absolute) == 0 {
+		return minLevelDb
+	}
+	relative := gated(math.Max(loudnessAbsoluteGate, loudness_Lufs(mean(absolute))+loudnessRelativeGate))
+	return loudness_Lufs(mean(relative))
+}
+
+func mean(values []float64) float64 {
+	sum := 0.0
+	for _, value := range values {
+		sum += value
+	}
+	return sum / float64(len(values))
+}
+
+// float_Sample keeps a float sample finite: NaN and ±Inf become full-scale
+// samples, which count as clipped, and nothing goes past maxFloatSample.
+func float_Sample(x float64) float64 {
+	switch {
+	case math.IsNaN(x):
+		return 1
+	case math.IsInf(x, 0):
+		return math.Copysign(1, x)
+	}
+	return math.Max(-maxFloatSample, math.Min(x, maxFloatSample))
+}
+
+// sample_Decoder returns how to read one sample of format as a value in
+// [-1, 1], or nil when check_WavFormat would reject format. Float samples
+// may lie outside [-1, 1] but are always finite.
+func sample_Decoder(format WavFormat) func([]byte) float64 {
+
+	if format.sample_Format() == wavFormatFloat {
+		switch format.BitsPerSample {
+		case 32:
+			return func(b []byte) float64 {
+				return float_Sample(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))))
+			}
+		case 64:
+			return func(b []byte) float64 { return float_Sample(math.Float64frombits(binary.LittleEndian.Uint64(b))) }
+		}
+		return nil
+	}
+
+	switch format.BitsPerSample {
+	case 8:
+		// 8 비트만 부호 없는 값이다
+		return func(b []byte) float64 { return (float64(b[0]) - 128) / 128 }
+	case 16:
+		return func(b []byte) float64 { return float64(int16(binary.LittleEndian.Uint16(b))) / (1 << 15) }
+	case 24:
+		return func(b []byte) float64 {
+			return float64(int32(uint32(b[0])<<8|uint32(b[1])<<16|uint32(b[2])<<24)>>8) / (1 << 23)
+		}
+	case 32:
+		return func(b []byte) float64 { return float64(int32(binary.LittleEndian.Uint32(b))) / (1 << 31) }
+	case 64:
+		return func(b []byte) float64 { return float64(int64(binary.LittleEndian.Uint64(b))) / (1 << 63) }
+	}
+	return nil
+}
+
+// read_Frames calls add with each frame of format read from r, which is
+// positioned at the start of the data chunk. A frame has a sample in [-1, 1]
+// per channel and is reused between calls.
+func read_Frames(r io.Reader, format WavFormat, add func(frame []float64)) error {
+
+	decode := sample_Decoder(format)
+	if decode == nil {
+		return check_WavFormat(format)
+	}
+	width := int(format.BitsPerSample / 8)
+
+	reader := bufio.NewReaderSize(io.LimitReader(r, int64(format.DataSize)), 1<<16)
+	block := make([]byte, format.BlockAlign)
+	frame := make([]float64, format.Channels)
+	for {
+		_, err := io.ReadFull(reader, block)
+		// 마지막에 남는 불완전한 프레임은 버린다
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for channel := range frame {
+			frame[channel] = decode(block[channel*width:])
+		}
+		add(frame)
+	}
+}
+
+// measure_Levels reads the samples of format from r, which is positioned at
+// the start of the data chunk.
+func measure_Levels(r io.Reader, format WavFormat) (AudioLevels, error) {
+
+	meter := new_LevelMeter(format)
+	if err := read_Frames(r, format, meter.add); err != nil {
+		return AudioLevels{}, err
+	}
+	return meter.levels(), nil
+}
+
+// measure_Audio computes the levels of the current attempt of item idx in
+// stage. Like verify_Wav, it returns an *InputError when that isn't a
+// complete WAV file.
+func (app *App) measure_Audio(ctx context.Context, sessionId string, stage string, idx int) (AudioLevels, error) {
+
+	metadata, format, body, err := app.open_Audio(ctx, sessionId, stage, idx)
+	if err != nil {
+		return AudioLevels{}, err
+	}
+	defer body.Close()
+
+	levels, err := measure_Levels(body, format)
+	if err != nil {
+		return AudioLevels{}, err
+	}
+	levels.Key = metadata.Key
+	levels.Attempt = metadata.Attempt
+	return levels, nil
+}
+
+// create_AudioLevels measures every stage of each item and compares the
+// enhanced and equalized audio with the original.
+func (app *App) create_AudioLevels(sessionId string, indices []int) AudioLevelsResult {
+
+	result := AudioLevelsResult{Count: len(indices), Items: map[int]AudioLevelsItem{}}
+
+	for _, idx := range indices {
+		item := AudioLevelsItem{Stages: map[string]AudioLevels{}, Errors: map[string]*InputError{}, Changes: map[string]LevelChange{}}
+
+		for _, stage := range audioStages {
+			levels, err := app.measure_Audio(context.TODO(), sessionId, stage, idx)
+			var inputErr *InputError
+			if errors.As(err, &inputErr) {
+				item.Errors[stage] = inputErr
+				continue
+			}
+			if err != nil {
+				panic(err)
+			}
+			item.Stages[stage] = levels
+		}
+
+		if original, ok := item.Stages["original"]; ok {
+			for _, stage := range audioStages[1:] {
+				if levels, ok := item.Stages[stage]; ok {
+					item.Changes[stage] = level_Change(original, levels)
+				}
+			}
+		}
+		result.Items[idx] = item
+	}
+
+	return result
+}
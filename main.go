@@ -1,84 +1,531 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 )
 
 // //////////////////////////
+// Session scopes one user's batch. Every object key and Transcribe job name
+// used on behalf of a session is prefixed with its SessionId, so concurrent
+// batches never overwrite each other. Requests without a session use the
+// original global keys.
+type Session struct {
+	SessionId string    `json:"sessionId"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type NeedSession struct {
+	Name string `json:"name"`
+}
+
+// //////////////////////////
+// NeedEnhance names the items by Indices, by Range or as 0..Count-1 (0 based,
+// item idx is stored as idx+1). Retry > 0 re-issues URLs for that attempt:
+// for every listed index, or for the items in Range/Count without output yet.
+// LifetimeSeconds and MaxBytes narrow the stage's presign policy.
 type NeedEnhance struct {
-	Count int `json:"count" binding:"required"`
-	Retry int `json:"retry"`
+	Count           int         `json:"count"`
+	Indices         []int       `json:"indices"`
+	Range           *IndexRange `json:"range"`
+	Retry           int         `json:"retry"`
+	LifetimeSeconds int64       `json:"lifetimeSeconds"`
+	MaxBytes        int64       `json:"maxBytes"`
+	SessionId       string      `json:"sessionId"`
 }
 
+// Errors holds, per index, why an item got no URLs; see InputError.
 type PreSignEnhance struct {
-	Count int           `json:"count"`
-	Urls  []EnhanceUrls `json:"urls"`
+	Count   int                 `json:"count"`
+	Attempt int                 `json:"attempt"`
+	Policy  PresignPolicy       `json:"policy"`
+	Urls    []EnhanceUrls       `json:"urls"`
+	Items   map[int]EnhanceUrls `json:"items"`
+	Errors  map[int]*InputError `json:"errors,omitempty"`
 }
+
+// Output is the upload URL; OutputUpload says how to use it. With a size
+// limit on S3 it is a POST policy and a plain PUT to Output is refused.
 type EnhanceUrls struct {
-	Input  string `json:"input"`
-	Output string `json:"output"`
+	Input        string          `json:"input"`
+	Output       string          `json:"output"`
+	OutputUpload PresignedUpload `json:"outputUpload"`
+	Index        int             `json:"index"`
+	Attempt      int             `json:"attempt"`
 }
 
 // //////////////////////////
 type NeedAnalyze struct {
-	Count int `json:"count" binding:"required"`
-	Retry int `json:"retry"`
+	Count           int         `json:"count"`
+	Indices         []int       `json:"indices"`
+	Range           *IndexRange `json:"range"`
+	Retry           int         `json:"retry"`
+	LifetimeSeconds int64       `json:"lifetimeSeconds"`
+	MaxBytes        int64       `json:"maxBytes"`
+	SessionId       string      `json:"sessionId"`
 }
 
 type PreSignAnalyze struct {
-	Count    int           `json:"count"`
-	UrlJsons []AnalyzeUrls `json:"urljsons"`
+	Count    int                 `json:"count"`
+	Attempt  int                 `json:"attempt"`
+	Policy   PresignPolicy       `json:"policy"`
+	UrlJsons []AnalyzeUrls       `json:"urljsons"`
+	Items    map[int]AnalyzeUrls `json:"items"`
+	Errors   map[int]*InputError `json:"errors,omitempty"`
 }
 type AnalyzeUrls struct {
-	OriginalUrl        string `json:"originalurl"`
-	OriginalOutputJson string `json:"originalouputjson"`
-	InputUrl           string `json:"inputurl"`
-	OutputJson         string `json:"outputjson"`
+	OriginalUrl          string          `json:"originalurl"`
+	OriginalOutputJson   string          `json:"originalouputjson"`
+	OriginalOutputUpload PresignedUpload `json:"originalOutputUpload"`
+	InputUrl             string          `json:"inputurl"`
+	OutputJson           string          `json:"outputjson"`
+	OutputUpload         PresignedUpload `json:"outputUpload"`
+	Index                int             `json:"index"`
+	Attempt              int             `json:"attempt"`
 }
 
 // ///////////////////////////
+// Retry selects the attempt to read; 0 reads the current (latest) one.
 type NeedAnalyzeJson struct {
-	Index int `json:"index"`
-	Retry int `json:"retry"`
+	Index     int    `json:"index"`
+	Retry     int    `json:"retry"`
+	SessionId string `json:"sessionId"`
 }
 type AnalyzeJson struct {
 	OriginalAnalyzeJsonData string `json:"originalAnalyzejson"`
 	AnalyzeJsonData         string `json:"analyzejson"`
+	Attempt                 int    `json:"attempt"`
+}
+
+// ///////////////////////////
+// Index is 0-based like every other endpoint.
+type NeedAudioMetadata struct {
+	Index     int    `json:"index"`
+	SessionId string `json:"sessionId"`
+}
+
+// AudioInfo has the WAV header of the current attempt of each stage and what
+// the enhancers changed compared to the original.
+type AudioInfo struct {
+	Index   int                      `json:"index"`
+	Stages  map[string]AudioMetadata `json:"stages"`
+	Errors  map[string]*InputError   `json:"errors,omitempty"`
+	Changes []string                 `json:"changes,omitempty"`
+}
+
+// ///////////////////////////
+type NeedAudioLevels struct {
+	Count     int         `json:"count"`
+	Indices   []int       `json:"indices"`
+	Range     *IndexRange `json:"range"`
+	SessionId string      `json:"sessionId"`
+}
+
+// AudioLevelsItem has the levels of each stage of one item and, for enhance
+// and equalize, the change from the original.
+type AudioLevelsItem struct {
+	Stages  map[string]AudioLevels `json:"stages"`
+	Errors  map[string]*InputError `json:"errors,omitempty"`
+	Changes map[string]LevelChange `json:"changes,omitempty"`
+}
+type AudioLevelsResult struct {
+	Count int                     `json:"count"`
+	Items map[int]AudioLevelsItem `json:"items"`
+}
+
+// ///////////////////////////
+// Stage is one of audioStages; the current attempt of it is drawn.
+type NeedWaveform struct {
+	Index     int    `json:"index"`
+	Stage     string `json:"stage"`
+	Points    int    `json:"points"`
+	SessionId string `json:"sessionId"`
+}
+type NeedSpectrogram struct {
+	Index     int    `json:"index"`
+	Stage     string `json:"stage"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	SessionId string `json:"sessionId"`
+}
+
+// ///////////////////////////
+// References maps each 0-based index to what was actually said. Without
+// them the references imported by /uploadExcel are used for the items named
+// by Indices, Range or Count. Normalization replaces
+// default_TextNormalization as a whole.
+type NeedScore struct {
+	References    map[int]string     `json:"references"`
+	Count         int                `json:"count"`
+	Indices       []int              `json:"indices"`
+	Range         *IndexRange        `json:"range"`
+	Normalization *TextNormalization `json:"normalization"`
+	SessionId     string             `json:"sessionId"`
+}
+
+// ItemScore has the scores of an item's original and equalized transcripts.
+type ItemScore struct {
+	Original  *TranscriptScore       `json:"original,omitempty"`
+	Equalized *TranscriptScore       `json:"equalized,omitempty"`
+	Delta     *ScoreDelta            `json:"delta,omitempty"`
+	Errors    map[string]*InputError `json:"errors,omitempty"`
+}
+
+// TranscriptScores averages the scores per transcript kind and the delta
+// over the items that have both transcripts.
+type TranscriptScores struct {
+	Count         int                    `json:"count"`
+	Normalization TextNormalization      `json:"normalization"`
+	Items         map[int]ItemScore      `json:"items"`
+	Averages      map[string]*BatchScore `json:"averages"`
+	Delta         *ScoreDelta            `json:"delta,omitempty"`
+}
+
+// NeedDiff asks for the word-level diff of item Index: its original
+// transcript against the equalized one, or with Against "reference" both
+// against the imported reference. Format "html" answers with the page
+// GET /diffTranscripts shows instead of JSON.
+type NeedDiff struct {
+	Index         int                `json:"index"`
+	Against       string             `json:"against"`
+	Normalization *TextNormalization `json:"normalization"`
+	Format        string             `json:"format"`
+	SessionId     string             `json:"sessionId"`
+}
+
+// TranscriptDiffs has one diff per pair of transcripts that both exist.
+type TranscriptDiffs struct {
+	Index         int                    `json:"index"`
+	Against       string                 `json:"against"`
+	Normalization TextNormalization      `json:"normalization"`
+	Diffs         []WordDiff             `json:"diffs"`
+	Errors        map[string]*InputError `json:"errors,omitempty"`
+}
+
+// NeedReport asks for a report of the items named by Indices, Range or
+// Count in each of Formats, xlsx and csv when empty. Its links stay valid
+// for LifetimeSeconds, defaultPresignLifetimeSecs when 0.
+type NeedReport struct {
+	Count           int                `json:"count"`
+	Indices         []int              `json:"indices"`
+	Range           *IndexRange        `json:"range"`
+	Formats         []string           `json:"formats"`
+	Normalization   *TextNormalization `json:"normalization"`
+	LifetimeSeconds int64              `json:"lifetimeSeconds"`
+	SessionId       string             `json:"sessionId"`
+}
+
+// ReportFile is a stored report and a presigned URL to download it from.
+type ReportFile struct {
+	Key string `json:"key"`
+	Url string `json:"url"`
+}
+
+// ExportedReport has one file per requested format, all with the same rows.
+type ExportedReport struct {
+	ReportId string                `json:"reportId"`
+	Count    int                   `json:"count"`
+	Columns  []string              `json:"columns"`
+	Files    map[string]ReportFile `json:"files"`
 }
 
 // //////////////////////////
 type NeedEqualize struct {
-	Count int `json:"count" binding:"required"`
-	Retry int `json:"retry"`
+	Count           int         `json:"count"`
+	Indices         []int       `json:"indices"`
+	Range           *IndexRange `json:"range"`
+	Retry           int         `json:"retry"`
+	LifetimeSeconds int64       `json:"lifetimeSeconds"`
+	MaxBytes        int64       `json:"maxBytes"`
+	SessionId       string      `json:"sessionId"`
 }
 
 type PreSignEqualize struct {
-	Count int            `json:"count"`
-	Urls  []EqualizeUrls `json:"urls"`
+	Count   int                  `json:"count"`
+	Attempt int                  `json:"attempt"`
+	Policy  PresignPolicy        `json:"policy"`
+	Urls    []EqualizeUrls       `json:"urls"`
+	Items   map[int]EqualizeUrls `json:"items"`
+	Errors  map[int]*InputError  `json:"errors,omitempty"`
 }
 type EqualizeUrls struct {
-	Input  string `json:"input"`
-	Output string `json:"output"`
+	Input        string          `json:"input"`
+	Output       string          `json:"output"`
+	OutputUpload PresignedUpload `json:"outputUpload"`
+	Index        int             `json:"index"`
+	Attempt      int             `json:"attempt"`
+}
+
+// //////////////////////////
+// NeedVideoUpload drives the multipart upload of video Index (0 based):
+// /startVideoUpload, /presignVideoParts for PartNumbers (or 1..PartCount),
+// /listVideoParts to resume, then /completeVideoUpload with Parts or
+// /abortVideoUpload.
+type NeedVideoUpload struct {
+	Index           int          `json:"index"`
+	UploadId        string       `json:"uploadId"`
+	PartCount       int          `json:"partCount"`
+	PartNumbers     []int32      `json:"partNumbers"`
+	Parts           []UploadPart `json:"parts"`
+	LifetimeSeconds int64        `json:"lifetimeSeconds"`
+	SessionId       string       `json:"sessionId"`
+}
+type VideoUpload struct {
+	Index    int          `json:"index"`
+	Key      string       `json:"key"`
+	UploadId string       `json:"uploadId"`
+	Urls     []PartUrl    `json:"urls,omitempty"`
+	Parts    []UploadPart `json:"parts,omitempty"`
 }
 
 // //////////////////////////
 type NeedSTT struct {
-	Index      int  `json:"index"`
-	IsOriginal bool `json:"isOriginal"`
+	Index       int    `json:"index"`
+	IsOriginal  bool   `json:"isOriginal"`
+	SessionId   string `json:"sessionId"`
+	CallbackUrl string `json:"callbackUrl"`
 }
 type STTStatus struct {
 	Result string `json:"result"`
 }
 
+// NeedSTTBatch starts the jobs of Indices, of Range or of 0..Count-1.
+type NeedSTTBatch struct {
+	Count       int         `json:"count"`
+	Indices     []int       `json:"indices"`
+	Range       *IndexRange `json:"range"`
+	IsOriginal  bool        `json:"isOriginal"`
+	IsVideo     bool        `json:"isVideo"`
+	SessionId   string      `json:"sessionId"`
+	CallbackUrl string      `json:"callbackUrl"`
+}
+type STTBatchStatus struct {
+	Count   int              `json:"count"`
+	Started int              `json:"started"`
+	Queued  int              `json:"queued"`
+	Failed  int              `json:"failed"`
+	Results []STTBatchResult `json:"results"`
+}
+type STTBatchResult struct {
+	Index   int    `json:"index"`
+	JobName string `json:"jobName"`
+	Result  string `json:"result"`
+	Error   string `json:"error,omitempty"`
+}
+
+// //////////////////////////
+// NeedPipeline starts a run of items 0..Count-1, at most maxPipelineItems.
+type NeedPipeline struct {
+	Count     int    `json:"count" binding:"required,min=1,max=1000"`
+	SessionId string `json:"sessionId"`
+}
+
 // //////////////////////////
 type UploadExcel struct {
 	FileName string `json:"fileName"`
 	FileData string `json:"fileData"`
 }
 
-func setRouter(router *gin.Engine) {
+// bind_Body answers 400 and returns false unless the request body is JSON
+// that fits body.
+func bind_Body(c *gin.Context, body interface{}) bool {
+	if err := c.ShouldBindJSON(body); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// require_Session answers 404 and returns false when sessionId names no session.
+func require_Session(c *gin.Context, app *App, sessionId string) bool {
+
+	err := app.check_Session(sessionId)
+	if errors.Is(err, ErrSessionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return false
+	}
+	if err != nil {
+		log.Printf("Couldn't load session %v. Here's why: %v\n", sessionId, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// require_Indices answers 400 and returns false when the request names no
+// valid set of items; see select_Indices.
+func require_Indices(c *gin.Context, indices []int, indexRange *IndexRange, count int, limit int) ([]int, bool) {
+
+	selected, err := select_Indices(indices, indexRange, count, limit)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return selected, true
+}
+
+// require_VideoIndex answers 400 and returns false for a negative video index.
+func require_VideoIndex(c *gin.Context, idx int) bool {
+	if idx < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "index must not be negative"})
+		return false
+	}
+	return true
+}
+
+// require_AudioItem answers 400 and returns false unless idx and stage name
+// a WAV file of some item.
+func require_AudioItem(c *gin.Context, idx int, stage string) bool {
+
+	if idx < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "index must not be negative"})
+		return false
+	}
+	for _, audioStage := range audioStages {
+		if stage == audioStage {
+			return true
+		}
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "stage must be one of " + strings.Join(audioStages, ", ")})
+	return false
+}
+
+// transcript_DiffResponse answers with the diffs of item idx as JSON or,
+// when format is "html", as a standalone page.
+func transcript_DiffResponse(c *gin.Context, app *App, sessionId string, idx int, against string, normalization TextNormalization, format string) {
+
+	if idx < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "index must not be negative"})
+		return
+	}
+	if against == "" {
+		against = DiffEqualized
+	}
+	if against != DiffEqualized && against != DiffReference {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "against must be " + DiffEqualized + " or " + DiffReference})
+		return
+	}
+	if format != "" && format != "json" && format != "html" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or html"})
+		return
+	}
+
+	diffs, err := app.create_TranscriptDiffs(sessionId, idx, against, normalization)
+	if errors.Is(err, ErrDiffTooLong) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		log.Printf("Couldn't diff the transcripts of %v. Here's why: %v\n", idx, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if format != "html" {
+		c.JSON(http.StatusOK, diffs)
+		return
+	}
+	var page strings.Builder
+	if err := write_DiffPage(&page, diffs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(page.String()))
+}
+
+// require_PresignPolicy answers 400 and returns false when the request asks
+// for more than the stage's presign policy allows.
+func require_PresignPolicy(c *gin.Context, app *App, stage string, lifetimeSecs int64, maxBytes int64) (PresignPolicy, bool) {
+
+	policy, err := app.presign_Policy(stage, lifetimeSecs, maxBytes)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return PresignPolicy{}, false
+	}
+	return policy, true
+}
+
+// require_CallbackUrl answers 400 and returns false when callbackUrl is set
+// but can't be used.
+func require_CallbackUrl(c *gin.Context, app *App, callbackUrl string) bool {
+
+	if callbackUrl == "" {
+		return true
+	}
+	if err := app.Webhooks.check_CallbackUrl(callbackUrl); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// tracked_JobResponse answers with the tracked job jobName, or 404 when the
+// server never started it.
+func tracked_JobResponse(c *gin.Context, app *App, jobName string) {
+
+	job, ok := app.Poller.get_Job(jobName)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "transcription job not tracked"})
+		return
+	}
+
+	job, err := app.tracked_JobResult(job)
+	if err != nil {
+		log.Printf("Couldn't presign transcript of %v. Here's why: %v\n", jobName, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func setRouter(router *gin.Engine, app *App) {
+
+	if localStorage, ok := app.Storage.(*LocalStorage); ok {
+		localStorage.setRouter(router)
+	}
+	app.Uploads.setRouter(router)
+
+	router.POST("/createSession", func(c *gin.Context) {
+
+		var requestBody NeedSession
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+
+		session, err := app.create_Session(requestBody.Name)
+		if err != nil {
+			log.Printf("Couldn't create session. Here's why: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, session)
+	})
+
+	router.GET("/session/:sessionId", func(c *gin.Context) {
+
+		session, err := app.get_Session(c.Param("sessionId"))
+		if errors.Is(err, ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, session)
+	})
 
 	router.POST("/presignEnhance", func(c *gin.Context) {
 
@@ -86,9 +533,28 @@ func setRouter(router *gin.Engine) {
 		//print(c.Request.Body)
 
 		var requestBody NeedEnhance
-		c.Bind(&requestBody)
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
+		indices, ok := require_Indices(c, requestBody.Indices, requestBody.Range, requestBody.Count, maxPresignIndices)
+		if !ok {
+			return
+		}
+		policy, ok := require_PresignPolicy(c, app, "enhance", requestBody.LifetimeSeconds, requestBody.MaxBytes)
+		if !ok {
+			return
+		}
 
-		res := create_PreSignEnhance(requestBody.Count)
+		var res PreSignEnhance
+
+		if requestBody.Retry == 0 {
+			res = app.create_PreSignEnhance(requestBody.SessionId, indices, policy)
+		} else {
+			res = app.create_PreSignEnhanceRetry(requestBody.SessionId, indices, requestBody.Retry, len(requestBody.Indices) == 0, policy)
+		}
 
 		c.JSON(http.StatusOK, res)
 	})
@@ -99,14 +565,27 @@ func setRouter(router *gin.Engine) {
 		//print(c.Request.Body)
 
 		var requestBody NeedAnalyze
-		c.Bind(&requestBody)
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
+		indices, ok := require_Indices(c, requestBody.Indices, requestBody.Range, requestBody.Count, maxPresignIndices)
+		if !ok {
+			return
+		}
+		policy, ok := require_PresignPolicy(c, app, "analyze", requestBody.LifetimeSeconds, requestBody.MaxBytes)
+		if !ok {
+			return
+		}
 
 		var res PreSignAnalyze
 
 		if requestBody.Retry == 0 {
-			res = create_PreSignAnalyze(requestBody.Count)
+			res = app.create_PreSignAnalyze(requestBody.SessionId, indices, policy)
 		} else {
-			res = create_PreSignAnalyzeRetry(requestBody.Count, requestBody.Retry)
+			res = app.create_PreSignAnalyzeRetry(requestBody.SessionId, indices, requestBody.Retry, len(requestBody.Indices) == 0, policy)
 		}
 
 		c.JSON(http.StatusOK, res)
@@ -118,14 +597,253 @@ func setRouter(router *gin.Engine) {
 		//print(c.Request.Body)
 
 		var requestBody NeedAnalyzeJson
-		c.Bind(&requestBody)
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
 
-		var res AnalyzeJson
+		res := app.create_AnalyzeJson(requestBody.SessionId, requestBody.Index, requestBody.Retry)
 
-		if requestBody.Retry == 0 {
-			res = create_AnalyzeJson(requestBody.Index)
+		c.JSON(http.StatusOK, res)
+	})
+
+	router.POST("/getAudioMetadata", func(c *gin.Context) {
+
+		var requestBody NeedAudioMetadata
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
+		if !require_AudioItem(c, requestBody.Index, "original") {
+			return
+		}
+
+		res := app.create_AudioInfo(requestBody.SessionId, requestBody.Index)
+
+		c.JSON(http.StatusOK, res)
+	})
+
+	router.POST("/getAudioLevels", func(c *gin.Context) {
+
+		var requestBody NeedAudioLevels
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
+		indices, ok := require_Indices(c, requestBody.Indices, requestBody.Range, requestBody.Count, maxLevelIndices)
+		if !ok {
+			return
+		}
+
+		res := app.create_AudioLevels(requestBody.SessionId, indices)
+
+		c.JSON(http.StatusOK, res)
+	})
+
+	router.POST("/getWaveform", func(c *gin.Context) {
+
+		var requestBody NeedWaveform
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) || !require_AudioItem(c, requestBody.Index, requestBody.Stage) {
+			return
+		}
+		if requestBody.Points == 0 {
+			requestBody.Points = defaultWaveformPoints
+		}
+		if requestBody.Points < 1 || requestBody.Points > maxWaveformPoints {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "points must be between 1 and " + strconv.Itoa(maxWaveformPoints)})
+			return
+		}
+
+		res, err := app.create_Waveform(requestBody.SessionId, requestBody.Stage, requestBody.Index, requestBody.Points)
+		if err != nil {
+			log.Printf("Couldn't draw the waveform of %v %v. Here's why: %v\n", requestBody.Stage, requestBody.Index, err)
+			c.JSON(input_Status(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, res)
+	})
+
+	router.POST("/getSpectrogram", func(c *gin.Context) {
+
+		var requestBody NeedSpectrogram
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) || !require_AudioItem(c, requestBody.Index, requestBody.Stage) {
+			return
+		}
+		if requestBody.Width == 0 {
+			requestBody.Width = defaultSpectrogramWidth
+		}
+		if requestBody.Height == 0 {
+			requestBody.Height = defaultSpectrogramHeight
+		}
+		if err := check_SpectrogramSize(requestBody.Width, requestBody.Height); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		res, err := app.create_Spectrogram(requestBody.SessionId, requestBody.Stage, requestBody.Index, requestBody.Width, requestBody.Height)
+		if err != nil {
+			log.Printf("Couldn't draw the spectrogram of %v %v. Here's why: %v\n", requestBody.Stage, requestBody.Index, err)
+			c.JSON(input_Status(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, res)
+	})
+
+	router.POST("/scoreTranscripts", func(c *gin.Context) {
+
+		var requestBody NeedScore
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
+
+		references := requestBody.References
+		var indices []int
+		if len(references) == 0 {
+			selected, ok := require_Indices(c, requestBody.Indices, requestBody.Range, requestBody.Count, maxScoreIndices)
+			if !ok {
+				return
+			}
+			stored, err := app.load_References(requestBody.SessionId, selected)
+			if err != nil {
+				log.Printf("Couldn't load the references. Here's why: %v\n", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			indices, references = selected, stored
 		} else {
-			//res = create_PreSignAnalyzeRetry(requestBody.Count, requestBody.Retry)
+			if len(references) > maxScoreIndices {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "references must have at most " + strconv.Itoa(maxScoreIndices) + " items"})
+				return
+			}
+			for idx, reference := range references {
+				if idx < 0 {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "index must not be negative"})
+					return
+				}
+				if utf8.RuneCountInString(reference) > maxReferenceChars {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "references must have at most " + strconv.Itoa(maxReferenceChars) + " characters"})
+					return
+				}
+				indices = append(indices, idx)
+			}
+		}
+
+		normalization := default_TextNormalization()
+		if requestBody.Normalization != nil {
+			normalization = *requestBody.Normalization
+		}
+
+		res, err := app.create_TranscriptScores(c.Request.Context(), requestBody.SessionId, indices, references, normalization)
+		if err != nil {
+			log.Printf("Couldn't score the transcripts. Here's why: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, res)
+	})
+
+	router.POST("/diffTranscripts", func(c *gin.Context) {
+
+		var requestBody NeedDiff
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
+
+		normalization := default_TextNormalization()
+		if requestBody.Normalization != nil {
+			normalization = *requestBody.Normalization
+		}
+
+		transcript_DiffResponse(c, app, requestBody.SessionId, requestBody.Index, requestBody.Against, normalization, requestBody.Format)
+	})
+
+	// 브라우저에서 바로 열어 볼 수 있도록 GET 은 HTML 로 답한다
+	router.GET("/diffTranscripts", func(c *gin.Context) {
+
+		sessionId := c.Query("sessionId")
+		if !require_Session(c, app, sessionId) {
+			return
+		}
+		idx, err := strconv.Atoi(c.Query("index"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "index must be a number"})
+			return
+		}
+
+		transcript_DiffResponse(c, app, sessionId, idx, c.Query("against"), default_TextNormalization(), c.DefaultQuery("format", "html"))
+	})
+
+	router.POST("/exportReport", func(c *gin.Context) {
+
+		var requestBody NeedReport
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
+		indices, ok := require_Indices(c, requestBody.Indices, requestBody.Range, requestBody.Count, maxReportIndices)
+		if !ok {
+			return
+		}
+
+		formats := requestBody.Formats
+		if len(formats) == 0 {
+			formats = reportFormats
+		}
+		seen := map[string]bool{}
+		for _, format := range formats {
+			if format != ReportXlsx && format != ReportCsv {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "formats must be " + strings.Join(reportFormats, " or ") + ", got " + format})
+				return
+			}
+			if seen[format] {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "format " + format + " requested twice"})
+				return
+			}
+			seen[format] = true
+		}
+
+		lifetimeSecs := requestBody.LifetimeSeconds
+		if lifetimeSecs == 0 {
+			lifetimeSecs = defaultPresignLifetimeSecs
+		}
+		if lifetimeSecs < 0 || lifetimeSecs > app.Config.PresignMaxLifetimeSecs {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "lifetimeSeconds must be between 1 and " + strconv.FormatInt(app.Config.PresignMaxLifetimeSecs, 10)})
+			return
+		}
+
+		normalization := default_TextNormalization()
+		if requestBody.Normalization != nil {
+			normalization = *requestBody.Normalization
+		}
+
+		res, err := app.create_Report(requestBody.SessionId, indices, formats, normalization, lifetimeSecs)
+		if err != nil {
+			log.Printf("Couldn't export the report. Here's why: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
 
 		c.JSON(http.StatusOK, res)
@@ -137,14 +855,27 @@ func setRouter(router *gin.Engine) {
 		//print(c.Request.Body)
 
 		var requestBody NeedEqualize
-		c.Bind(&requestBody)
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
+		indices, ok := require_Indices(c, requestBody.Indices, requestBody.Range, requestBody.Count, maxPresignIndices)
+		if !ok {
+			return
+		}
+		policy, ok := require_PresignPolicy(c, app, "equalize", requestBody.LifetimeSeconds, requestBody.MaxBytes)
+		if !ok {
+			return
+		}
 
 		var res PreSignEqualize
 
 		if requestBody.Retry == 0 {
-			res = create_PreSignEqualize(requestBody.Count)
+			res = app.create_PreSignEqualize(requestBody.SessionId, indices, policy)
 		} else {
-			//res = create_PreSignAnalyzeRetry(requestBody.Count, requestBody.Retry)
+			res = app.create_PreSignEqualizeRetry(requestBody.SessionId, indices, requestBody.Retry, len(requestBody.Indices) == 0, policy)
 		}
 
 		c.JSON(http.StatusOK, res)
@@ -156,9 +887,41 @@ func setRouter(router *gin.Engine) {
 		//print(c.Request.Body)
 
 		var requestBody NeedSTT
-		c.Bind(&requestBody)
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
+
+		if !require_CallbackUrl(c, app, requestBody.CallbackUrl) {
+			return
+		}
 
-		var jsondata = create_transcribe(requestBody.Index, requestBody.IsOriginal)
+		var jsondata = app.create_transcribe(requestBody.SessionId, requestBody.Index, requestBody.IsOriginal, requestBody.CallbackUrl)
+
+		c.JSON(http.StatusOK, jsondata)
+	})
+
+	router.POST("/startSttBatch", func(c *gin.Context) {
+
+		var requestBody NeedSTTBatch
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
+		if !require_CallbackUrl(c, app, requestBody.CallbackUrl) {
+			return
+		}
+
+		indices, ok := require_Indices(c, requestBody.Indices, requestBody.Range, requestBody.Count, maxSttBatch)
+		if !ok {
+			return
+		}
+
+		var jsondata = app.start_SttBatch(requestBody.SessionId, indices, requestBody.IsOriginal, requestBody.IsVideo, requestBody.CallbackUrl)
 
 		c.JSON(http.StatusOK, jsondata)
 	})
@@ -169,9 +932,14 @@ func setRouter(router *gin.Engine) {
 		//print(c.Request.Body)
 
 		var requestBody NeedSTT
-		c.Bind(&requestBody)
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
 
-		var jsondata = get_transcribe(requestBody.Index, requestBody.IsOriginal)
+		var jsondata = app.get_transcribe(requestBody.SessionId, requestBody.Index, requestBody.IsOriginal)
 
 		c.JSON(http.StatusOK, jsondata)
 	})
@@ -182,9 +950,14 @@ func setRouter(router *gin.Engine) {
 		//print(c.Request.Body)
 
 		var requestBody NeedSTT
-		c.Bind(&requestBody)
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
 
-		var jsondata = delete_transcribe(requestBody.Index, requestBody.IsOriginal)
+		var jsondata = app.delete_transcribe(requestBody.SessionId, requestBody.Index, requestBody.IsOriginal)
 
 		c.JSON(http.StatusOK, jsondata)
 	})
@@ -195,30 +968,92 @@ func setRouter(router *gin.Engine) {
 		//print(c.Request.Body)
 
 		var requestBody NeedSTT
-		c.Bind(&requestBody)
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
 
-		test_delete_all(requestBody.Index,requestBody.IsOriginal)
+		app.test_delete_all(requestBody.SessionId, requestBody.Index, requestBody.IsOriginal)
 
 		c.Done()
 	})
 
 	router.POST("/uploadExcel", func(c *gin.Context) {
 
-		print(c.Request)
-		form, _ := c.MultipartForm()
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxExcelUploadBytes)
+		form, err := c.MultipartForm()
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "uploads must be at most " + strconv.Itoa(maxExcelUploadBytes) + " bytes"})
+			return
+		}
+		if err != nil || len(form.File["excelfile"]) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "excelfile is required"})
+			return
+		}
 		files := form.File["excelfile"]
 
-		upload_excel(files)
+		sessionId := c.PostForm("sessionId")
+		if !require_Session(c, app, sessionId) {
+			return
+		}
+
+		mapping, err := app.Config.ExcelMapping.with_Overrides(c.PostForm("sheet"), c.PostForm("indexColumn"), c.PostForm("referenceColumn"), c.PostForm("headerRows"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		filename, err := excel_Filename(files[0].Filename)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		objectKey := session_Key(sessionId, filename)
+
+		if err := app.upload_excel(objectKey, files); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// 올린 파일은 그대로 두고, 정답 문장은 항목별로 따로 저장한다
+		workbook, err := files[0].Open()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		defer workbook.Close()
 
-		c.JSON(http.StatusOK, "Excel upload ok")
+		res, err := app.import_Excel(sessionId, objectKey, workbook, files[0].Size, mapping)
+		if errors.Is(err, ErrInvalidWorkbook) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			log.Printf("Couldn't import %v. Here's why: %v\n", files[0].Filename, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, res)
 	})
 
 	router.POST("/startVideoStt", func(c *gin.Context) {
 
 		var requestBody NeedSTT
-		c.Bind(&requestBody)
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
+
+		if !require_CallbackUrl(c, app, requestBody.CallbackUrl) {
+			return
+		}
 
-		var jsondata = create_videotranscribe(requestBody.Index)
+		var jsondata = app.create_videotranscribe(requestBody.SessionId, requestBody.Index, requestBody.CallbackUrl)
 
 		c.JSON(http.StatusOK, jsondata)
 	})
@@ -226,9 +1061,14 @@ func setRouter(router *gin.Engine) {
 	router.POST("/getVideoStt", func(c *gin.Context) {
 
 		var requestBody NeedSTT
-		c.Bind(&requestBody)
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
 
-		var jsondata = get_videotranscribe(requestBody.Index)
+		var jsondata = app.get_videotranscribe(requestBody.SessionId, requestBody.Index)
 
 		c.JSON(http.StatusOK, jsondata)
 	})
@@ -236,13 +1076,279 @@ func setRouter(router *gin.Engine) {
 	router.POST("/cleanUpVideoSTT", func(c *gin.Context) {
 
 		var requestBody NeedSTT
-		c.Bind(&requestBody)
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
 
-		var jsondata = delete_videotranscribe(requestBody.Index)
+		var jsondata = app.delete_videotranscribe(requestBody.SessionId, requestBody.Index)
 
 		c.JSON(http.StatusOK, jsondata)
 	})
 
+	router.POST("/startVideoUpload", func(c *gin.Context) {
+
+		var requestBody NeedVideoUpload
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) || !require_VideoIndex(c, requestBody.Index) {
+			return
+		}
+		policy, ok := require_PresignPolicy(c, app, "video", requestBody.LifetimeSeconds, 0)
+		if !ok {
+			return
+		}
+
+		upload, err := app.start_VideoUpload(requestBody.SessionId, requestBody.Index, policy)
+		if err != nil {
+			c.JSON(upload_Status(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, upload)
+	})
+
+	router.POST("/presignVideoParts", func(c *gin.Context) {
+
+		var requestBody NeedVideoUpload
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) || !require_VideoIndex(c, requestBody.Index) {
+			return
+		}
+		partNumbers, err := part_Numbers(requestBody.PartNumbers, requestBody.PartCount)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		policy, ok := require_PresignPolicy(c, app, "video", requestBody.LifetimeSeconds, 0)
+		if !ok {
+			return
+		}
+
+		upload, err := app.presign_VideoParts(requestBody.SessionId, requestBody.Index, requestBody.UploadId, partNumbers, policy)
+		if err != nil {
+			c.JSON(upload_Status(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, upload)
+	})
+
+	router.POST("/listVideoParts", func(c *gin.Context) {
+
+		var requestBody NeedVideoUpload
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) || !require_VideoIndex(c, requestBody.Index) {
+			return
+		}
+
+		upload, err := app.list_VideoParts(requestBody.SessionId, requestBody.Index, requestBody.UploadId)
+		if err != nil {
+			c.JSON(upload_Status(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, upload)
+	})
+
+	router.POST("/completeVideoUpload", func(c *gin.Context) {
+
+		var requestBody NeedVideoUpload
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) || !require_VideoIndex(c, requestBody.Index) {
+			return
+		}
+
+		upload, err := app.complete_VideoUpload(requestBody.SessionId, requestBody.Index, requestBody.UploadId, requestBody.Parts)
+		if err != nil {
+			c.JSON(upload_Status(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, upload)
+	})
+
+	router.POST("/abortVideoUpload", func(c *gin.Context) {
+
+		var requestBody NeedVideoUpload
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) || !require_VideoIndex(c, requestBody.Index) {
+			return
+		}
+
+		upload, err := app.abort_VideoUpload(requestBody.SessionId, requestBody.Index, requestBody.UploadId)
+		if err != nil {
+			c.JSON(upload_Status(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, upload)
+	})
+
+	// 서버가 추적 중인 STT 작업의 최종 상태와 결과 링크
+	router.POST("/sttResult", func(c *gin.Context) {
+
+		var requestBody NeedSTT
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
+
+		tracked_JobResponse(c, app, stt_JobName(requestBody.SessionId, requestBody.Index, requestBody.IsOriginal))
+	})
+
+	router.POST("/videoSttResult", func(c *gin.Context) {
+
+		var requestBody NeedSTT
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
+
+		tracked_JobResponse(c, app, video_JobName(requestBody.SessionId, requestBody.Index))
+	})
+
+	router.GET("/webhookDeliveries", func(c *gin.Context) {
+
+		sessionId := c.Query("sessionId")
+		if !require_Session(c, app, sessionId) {
+			return
+		}
+
+		c.JSON(http.StatusOK, app.Store.list_Deliveries(sessionId))
+	})
+
+	router.GET("/webhookDelivery/:deliveryId", func(c *gin.Context) {
+
+		delivery, err := app.Store.get_Delivery(c.Param("deliveryId"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, delivery)
+	})
+
+	router.GET("/sttJobs", func(c *gin.Context) {
+
+		sessionId := c.Query("sessionId")
+		if !require_Session(c, app, sessionId) {
+			return
+		}
+
+		c.JSON(http.StatusOK, app.Poller.list_Jobs(sessionId))
+	})
+
+	// 세션(또는 파이프라인 실행) 진행 상황을 Server-Sent Events 로 전달
+	router.GET("/events", func(c *gin.Context) {
+
+		filter := EventFilter{SessionId: c.Query("sessionId"), RunId: c.Query("runId")}
+		if filter.RunId != "" {
+			run, err := app.Pipelines.get_Run(filter.RunId)
+			if err != nil {
+				c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+				return
+			}
+			filter.SessionId = run.SessionId
+		} else if !require_Session(c, app, filter.SessionId) {
+			return
+		}
+
+		stream_Events(c, app.Events, filter, 15*time.Second)
+	})
+
+	router.POST("/startPipeline", func(c *gin.Context) {
+
+		var requestBody NeedPipeline
+		if !bind_Body(c, &requestBody) {
+			return
+		}
+		if !require_Session(c, app, requestBody.SessionId) {
+			return
+		}
+
+		run, err := app.Pipelines.start_Run(requestBody.SessionId, requestBody.Count)
+		if err != nil {
+			log.Printf("Couldn't start pipeline run. Here's why: %v\n", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, run)
+	})
+
+	router.GET("/pipeline/:runId", func(c *gin.Context) {
+
+		run, err := app.Pipelines.get_Run(c.Param("runId"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, run)
+	})
+
+	router.GET("/pipelines", func(c *gin.Context) {
+
+		sessionId := c.Query("sessionId")
+		if !require_Session(c, app, sessionId) {
+			return
+		}
+
+		c.JSON(http.StatusOK, app.Pipelines.list_Runs(sessionId))
+	})
+
+	// 운영용: 배치에 대해 서버가 한 일(presign, STT, cleanup, 번역) 조회
+	router.GET("/records", func(c *gin.Context) {
+
+		query := RecordQuery{SessionId: c.Query("sessionId"), Kind: c.Query("kind")}
+
+		if since := c.Query("since"); since != "" {
+			sinceTime, err := time.Parse(time.RFC3339, since)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "since must be an RFC 3339 time"})
+				return
+			}
+			query.Since = sinceTime
+		}
+		if limit := c.Query("limit"); limit != "" {
+			limitCount, err := strconv.Atoi(limit)
+			if err != nil || limitCount < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a non-negative number"})
+				return
+			}
+			query.Limit = limitCount
+		}
+
+		c.JSON(http.StatusOK, app.Store.list_Records(query))
+	})
+
+	router.GET("/records/:recordId", func(c *gin.Context) {
+
+		record, err := app.Store.get_Record(c.Param("recordId"))
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, record)
+	})
+
 	router.GET("/ping", func(c *gin.Context) {
 
 		c.JSON(http.StatusOK, gin.H{
@@ -252,9 +1358,24 @@ func setRouter(router *gin.Engine) {
 }
 
 func main() {
+	appConfig, err := load_config()
+	if err != nil {
+		log.Fatalf("Invalid configuration. Here's why: %v\n", err)
+	}
+
+	app, err := new_App(appConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.Default()
 
-	setRouter(router)
+	setRouter(router, app)
+
+	go app.Pipelines.run(context.Background(), time.Duration(appConfig.PipelinePollSeconds)*time.Second)
+	go app.Poller.run(context.Background(), time.Second)
+	app.Webhooks.resume_Pending()
+
 	_ = router.Run(":8080")
 }
@@ -0,0 +1,2331 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"math"
+	"math/rand"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/gin-gonic/gin"
+)
+
+type testServer struct {
+	app         *App
+	storage     *MemoryStorage
+	transcriber *FakeTranscriber
+	router      *gin.Engine
+}
+
+func new_TestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	storage := new_MemoryStorage()
+	transcriber := new_FakeTranscriber(storage)
+	app := &App{
+		Config: AppConfig{StorageBackend: "memory", TranscribeProvider: "fake", TranslateProvider: "fake",
+			PresignPolicies: default_PresignPolicies(), PresignMaxLifetimeSecs: defaultPresignMaxLifetimeSecs,
+			ExcelMapping: default_ExcelMapping()},
+		Storage:     storage,
+		Transcriber: transcriber,
+		Translator:  FakeTranslator{},
+	}
+	app.Store, _ = new_JobStore("")
+	app.Events = new_EventHub()
+	app.Pipelines = new_Pipelines(app)
+	app.Webhooks = new_Webhooks(app, "test-secret", 3, []string{"127.0.0.1"})
+	app.Webhooks.Backoff = time.Millisecond
+	app.Poller = new_JobPoller(app, 2, time.Second)
+	app.Uploads, _ = new_TusUploads(app, t.TempDir(), 1<<20)
+
+	router := gin.New()
+	setRouter(router, app)
+
+	return &testServer{app: app, storage: storage, transcriber: transcriber, router: router}
+}
+
+// do sends body as JSON (or no body when it is nil) and returns the response.
+func (server *testServer) do(t *testing.T, method string, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	request := httptest.NewRequest(method, path, reader)
+	request.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	server.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+// post sends body as JSON and fails the test unless the answer is 200.
+func (server *testServer) post(t *testing.T, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	recorder := server.do(t, http.MethodPost, path, body)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("POST %v: status %v, body %v", path, recorder.Code, recorder.Body.String())
+	}
+	return recorder
+}
+
+func (server *testServer) put_Object(t *testing.T, objectKey string, data string) {
+	t.Helper()
+	if err := server.storage.PutObject(context.TODO(), objectKey, strings.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// wav_Data is a 16kHz mono 16-bit PCM WAV file with the given number of samples.
+func wav_Data(samples int) string {
+	return wav_Layout(samples, 16000, 1)
+}
+
+// wav_Layout is a 16-bit PCM WAV file with frames samples per channel.
+func wav_Layout(frames int, sampleRate int, channels int) string {
+	var buf bytes.Buffer
+	le := func(v interface{}) { binary.Write(&buf, binary.LittleEndian, v) }
+	dataSize := frames * channels * 2
+	buf.WriteString("RIFF")
+	le(uint32(36 + dataSize))
+	buf.WriteString("WAVEfmt ")
+	le(uint32(16))
+	le(uint16(1))
+	le(uint16(channels))
+	le(uint32(sampleRate))
+	le(uint32(sampleRate * channels * 2))
+	le(uint16(channels * 2))
+	le(uint16(16))
+	buf.WriteString("data")
+	le(uint32(dataSize))
+	for i := 0; i < frames*channels; i++ {
+		le(int16(i * 100))
+	}
+	return buf.String()
+}
+
+// wav_Samples is a mono 16-bit PCM WAV file of samples in [-1, 1].
+func wav_Samples(sampleRate int, samples []float64) string {
+	var buf bytes.Buffer
+	le := func(v interface{}) { binary.Write(&buf, binary.LittleEndian, v) }
+	buf.WriteString("RIFF")
+	le(uint32(36 + len(samples)*2))
+	buf.WriteString("WAVEfmt ")
+	le([]uint32{16})
+	le([]uint16{1, 1})
+	le([]uint32{uint32(sampleRate), uint32(sampleRate * 2)})
+	le([]uint16{2, 16})
+	buf.WriteString("data")
+	le(uint32(len(samples) * 2))
+	for _, sample := range samples {
+		le(int16(math.Max(-1, math.Min(1, sample)) * 32767))
+	}
+	return buf.String()
+}
+
+// wav_Floats is a mono 32-bit float WAV file of samples.
+func wav_Floats(sampleRate int, samples []float32) string {
+	var buf bytes.Buffer
+	le := func(v interface{}) { binary.Write(&buf, binary.LittleEndian, v) }
+	buf.WriteString("RIFF")
+	le(uint32(36 + len(samples)*4))
+	buf.WriteString("WAVEfmt ")
+	le([]uint32{16})
+	le([]uint16{3, 1})
+	le([]uint32{uint32(sampleRate), uint32(sampleRate * 4)})
+	le([]uint16{4, 32})
+	buf.WriteString("data")
+	le(uint32(len(samples) * 4))
+	le(samples)
+	return buf.String()
+}
+
+func (server *testServer) put_Wav(t *testing.T, objectKeys ...string) {
+	t.Helper()
+	for _, objectKey := range objectKeys {
+		server.put_Object(t, objectKey, wav_Data(16))
+	}
+}
+
+func (server *testServer) read_Object(t *testing.T, objectKey string) string {
+	t.Helper()
+	body, err := server.storage.GetObject(context.TODO(), objectKey)
+	if err != nil {
+		t.Fatalf("get %v: %v", objectKey, err)
+	}
+	defer body.Close()
+	data, _ := io.ReadAll(body)
+	return string(data)
+}
+
+func (server *testServer) has_Object(objectKey string) bool {
+	body, err := server.storage.GetObject(context.TODO(), objectKey)
+	if err != nil {
+		return false
+	}
+	body.Close()
+	return true
+}
+
+func decode[T any](t *testing.T, recorder *httptest.ResponseRecorder) T {
+	t.Helper()
+	var value T
+	if err := json.Unmarshal(recorder.Body.Bytes(), &value); err != nil {
+		t.Fatalf("decode %v: %v", recorder.Body.String(), err)
+	}
+	return value
+}
+
+// assert_Presigned checks that rawUrl was presigned for method on objectKey.
+func assert_Presigned(t *testing.T, rawUrl string, method string, objectKey string) {
+	t.Helper()
+	parsed, err := url.Parse(rawUrl)
+	if err != nil {
+		t.Fatalf("parse %v: %v", rawUrl, err)
+	}
+	if got := strings.TrimPrefix(parsed.Path, "/"); got != objectKey {
+		t.Errorf("%v: key %v, want %v", rawUrl, got, objectKey)
+	}
+	if got := parsed.Query().Get("method"); got != method {
+		t.Errorf("%v: method %v, want %v", rawUrl, got, method)
+	}
+}
+
+func TestPing(t *testing.T) {
+	server := new_TestServer(t)
+
+	request := httptest.NewRequest(http.MethodGet, "/ping", nil)
+	recorder := httptest.NewRecorder()
+	server.router.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "pong") {
+		t.Fatalf("got %v %v", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestPresignEnhance(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Wav(t, "original/1.wav", "original/2.wav")
+
+	res := decode[PreSignEnhance](t, server.post(t, "/presignEnhance", NeedEnhance{Count: 2}))
+
+	if res.Count != 2 || len(res.Urls) != 2 {
+		t.Fatalf("got %+v", res)
+	}
+	assert_Presigned(t, res.Urls[0].Input, "GET", "original/1.wav")
+	assert_Presigned(t, res.Urls[0].Output, "PUT", "enhance/1.wav")
+	assert_Presigned(t, res.Urls[1].Input, "GET", "original/2.wav")
+	assert_Presigned(t, res.Urls[1].Output, "PUT", "enhance/2.wav")
+}
+
+func TestPresignAnalyze(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Wav(t, "original/1.wav", "original/2.wav", "enhance/1.wav", "enhance/2.wav")
+
+	res := decode[PreSignAnalyze](t, server.post(t, "/presignAnalyze", NeedAnalyze{Count: 2}))
+
+	if res.Count != 2 || len(res.UrlJsons) != 2 {
+		t.Fatalf("got %+v", res)
+	}
+	urls := res.UrlJsons[1]
+	assert_Presigned(t, urls.OriginalUrl, "GET", "original/2.wav")
+	assert_Presigned(t, urls.OriginalOutputJson, "PUT", "analyze/2_origin.json")
+	assert_Presigned(t, urls.InputUrl, "GET", "enhance/2.wav")
+	assert_Presigned(t, urls.OutputJson, "PUT", "analyze/2.json")
+}
+
+func TestGetAnalyzeJson(t *testing.T) {
+	server := new_TestServer(t)
+
+	res := decode[AnalyzeJson](t, server.post(t, "/getAnalyzeJson", NeedAnalyzeJson{Index: 0}))
+
+	assert_Presigned(t, res.OriginalAnalyzeJsonData, "GET", "analyze/1_origin.json")
+	assert_Presigned(t, res.AnalyzeJsonData, "GET", "analyze/1.json")
+}
+
+func TestPresignEqualize(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Wav(t, "enhance/1.wav")
+
+	res := decode[PreSignEqualize](t, server.post(t, "/presignEqualize", NeedEqualize{Count: 1}))
+
+	if res.Count != 1 || len(res.Urls) != 1 {
+		t.Fatalf("got %+v", res)
+	}
+	assert_Presigned(t, res.Urls[0].Input, "GET", "enhance/1.wav")
+	assert_Presigned(t, res.Urls[0].Output, "PUT", "equalize/1.wav")
+}
+
+func TestPresignIndicesAndRange(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Wav(t, "original/37.wav")
+	for i := 501; i <= 750; i++ {
+		server.put_Wav(t, "original/"+strconv.Itoa(i)+".wav", "enhance/"+strconv.Itoa(i)+".wav")
+	}
+
+	// 37 번 항목만 다시 돌린다
+	res := decode[PreSignEnhance](t, server.post(t, "/presignEnhance", NeedEnhance{Indices: []int{36}}))
+	if res.Count != 1 || len(res.Items) != 1 {
+		t.Fatalf("got %+v", res)
+	}
+	assert_Presigned(t, res.Items[36].Output, "PUT", "enhance/37.wav")
+
+	// 1000 개 배치 중 한 조각
+	analyze := decode[PreSignAnalyze](t, server.post(t, "/presignAnalyze", NeedAnalyze{Range: &IndexRange{Start: 500, End: 750}}))
+	if analyze.Count != 250 || len(analyze.Items) != 250 || analyze.UrlJsons[0].Index != 500 {
+		t.Fatalf("got count %v, %v items", analyze.Count, len(analyze.Items))
+	}
+	assert_Presigned(t, analyze.Items[749].OutputJson, "PUT", "analyze/750.json")
+	if _, ok := analyze.Items[750]; ok {
+		t.Error("range end is included")
+	}
+
+	records := server.app.Store.list_Records(RecordQuery{Kind: RecordPresign, Limit: 1})
+	if len(records[0].Indices) != 250 || records[0].Indices[0] != 500 {
+		t.Errorf("recorded indices %v", records[0].Indices)
+	}
+
+	for _, body := range []NeedEqualize{
+		{},
+		{Indices: []int{3, 3}},
+		{Indices: []int{-1}},
+		{Range: &IndexRange{Start: 5, End: 5}},
+		{Indices: []int{1}, Range: &IndexRange{Start: 0, End: 2}},
+		{Range: &IndexRange{Start: 0, End: 2000000000}},
+		{Count: maxPresignIndices + 1},
+	} {
+		if recorder := server.do(t, http.MethodPost, "/presignEqualize", body); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%+v: got %v", body, recorder.Code)
+		}
+	}
+}
+
+func TestPresignPolicy(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Wav(t, "original/1.wav", "enhance/1.wav")
+
+	res := decode[PreSignEnhance](t, server.post(t, "/presignEnhance", NeedEnhance{Count: 1}))
+	if res.Policy != server.app.Config.PresignPolicies["enhance"] {
+		t.Errorf("policy %+v", res.Policy)
+	}
+	upload := res.Urls[0].OutputUpload
+	if upload.Method != http.MethodPut || upload.Url != res.Urls[0].Output || upload.Headers["Content-Type"] != "audio/wav" {
+		t.Errorf("upload %+v", upload)
+	}
+
+	// 요청마다 수명과 크기를 더 좁힐 수 있다
+	analyze := decode[PreSignAnalyze](t, server.post(t, "/presignAnalyze", NeedAnalyze{Count: 1, LifetimeSeconds: 60, MaxBytes: 1024}))
+	parsed, _ := url.Parse(analyze.UrlJsons[0].OutputJson)
+	query := parsed.Query()
+	if query.Get("expires") != "60" || query.Get("max-bytes") != "1024" || query.Get("content-type") != "application/json" {
+		t.Errorf("output url %v", analyze.UrlJsons[0].OutputJson)
+	}
+	if analyze.UrlJsons[0].OriginalOutputUpload.Headers["Content-Type"] != "application/json" {
+		t.Errorf("original upload %+v", analyze.UrlJsons[0].OriginalOutputUpload)
+	}
+
+	for _, body := range []NeedEqualize{
+		{Count: 1, MaxBytes: 1 << 40},
+		{Count: 1, LifetimeSeconds: defaultPresignMaxLifetimeSecs + 1},
+		{Count: 1, LifetimeSeconds: -1},
+	} {
+		if recorder := server.do(t, http.MethodPost, "/presignEqualize", body); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%+v: got %v", body, recorder.Code)
+		}
+	}
+}
+
+func TestLocalStorageUploadLimits(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	storage, err := new_LocalStorage(t.TempDir(), "http://localhost", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := gin.New()
+	storage.setRouter(router)
+
+	upload, err := storage.PresignUpload(context.TODO(), "enhance/1.wav", PresignPolicy{LifetimeSecs: 60, ContentType: "audio/wav", MaxBytes: 8})
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(rawUrl string, contentType string, body string) int {
+		request := httptest.NewRequest(http.MethodPut, rawUrl, strings.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	if code := put(upload.Url, "application/octet-stream", "RIFF"); code != http.StatusUnsupportedMediaType {
+		t.Errorf("wrong content type: got %v", code)
+	}
+	if code := put(upload.Url, "audio/wav", "RIFF and a lot more"); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("too large: got %v", code)
+	}
+	if code := put(strings.Replace(upload.Url, "max-bytes=8", "max-bytes=800", 1), "audio/wav", "RIFF"); code != http.StatusForbidden {
+		t.Errorf("raised limit: got %v", code)
+	}
+	if code := put(upload.Url, "audio/wav", "RIFF"); code != http.StatusOK {
+		t.Errorf("upload: got %v", code)
+	}
+
+	// 제한 없는 GET URL 은 그대로 동작한다
+	getUrl, _ := storage.PresignGetObject(context.TODO(), "enhance/1.wav", 60)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, getUrl, nil))
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "RIFF" {
+		t.Errorf("get: %v %v", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestS3PostPolicy(t *testing.T) {
+	storage := &S3Storage{
+		BucketName:  "bucket",
+		Region:      "ap-northeast-2",
+		Credentials: credentials.NewStaticCredentialsProvider("AKID", "SECRET", ""),
+	}
+
+	upload, err := storage.PresignUpload(context.TODO(), "enhance/1.wav", PresignPolicy{LifetimeSecs: 60, ContentType: "audio/wav", MaxBytes: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upload.Method != http.MethodPost || upload.Url != "https://bucket.s3.ap-northeast-2.amazonaws.com/" {
+		t.Fatalf("got %+v", upload)
+	}
+	if upload.Fields["key"] != "enhance/1.wav" || upload.Fields["Content-Type"] != "audio/wav" || len(upload.Fields["x-amz-signature"]) != 64 {
+		t.Errorf("fields %v", upload.Fields)
+	}
+
+	document, err := base64.StdEncoding.DecodeString(upload.Fields["policy"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(document), `["content-length-range",0,1024]`) || !strings.Contains(string(document), `{"bucket":"bucket"}`) {
+		t.Errorf("policy %s", document)
+	}
+}
+
+func TestPresignVerifiesInputs(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Wav(t, "original/1.wav", "original/5.wav", "enhance/1.wav")
+	server.put_Object(t, "original/3.wav", "RIFF not really")
+	server.put_Object(t, "original/4.wav", wav_Data(16)[:50])
+	server.storage.set_ContentType("original/5.wav", "application/json")
+
+	res := decode[PreSignEnhance](t, server.post(t, "/presignEnhance", NeedEnhance{Count: 5}))
+	if len(res.Items) != 1 || len(res.Errors) != 4 {
+		t.Fatalf("got %v items, errors %+v", len(res.Items), res.Errors)
+	}
+	assert_Presigned(t, res.Items[0].Input, "GET", "original/1.wav")
+	if err := res.Errors[1]; err.Problem != InputMissing || err.Key != "original/2.wav" {
+		t.Errorf("missing original: %+v", err)
+	}
+	for idx, reason := range map[int]string{2: "RIFF", 3: "truncated", 4: "application/json"} {
+		if err := res.Errors[idx]; err == nil || err.Problem != InputInvalid || !strings.Contains(err.Reason, reason) {
+			t.Errorf("index %v: %+v", idx, err)
+		}
+	}
+
+	records := server.app.Store.list_Records(RecordQuery{Kind: RecordPresign, Limit: 1})
+	if len(records[0].Indices) != 1 || records[0].Indices[0] != 0 {
+		t.Errorf("recorded indices %v", records[0].Indices)
+	}
+
+	// 다음 단계도 앞 단계의 출력이 멀쩡할 때만 발급한다
+	server.put_Object(t, "enhance/2.wav", "data")
+	equalize := decode[PreSignEqualize](t, server.post(t, "/presignEqualize", NeedEqualize{Count: 3}))
+	if len(equalize.Items) != 1 || equalize.Errors[1].Problem != InputInvalid || equalize.Errors[2].Problem != InputMissing {
+		t.Fatalf("got %v items, errors %+v", len(equalize.Items), equalize.Errors)
+	}
+	server.put_Wav(t, "original/2.wav")
+	analyze := decode[PreSignAnalyze](t, server.post(t, "/presignAnalyze", NeedAnalyze{Indices: []int{1}}))
+	if len(analyze.Items) != 0 || analyze.Errors[1].Key != "enhance/2.wav" {
+		t.Fatalf("got %+v", analyze)
+	}
+}
+
+func TestParseWavHeaderChunkSizes(t *testing.T) {
+	wav := wav_Data(4)
+
+	// fmt 청크 크기는 파일이 말하는 대로 믿지 않는다
+	huge := []byte(wav)
+	binary.LittleEndian.PutUint32(huge[16:20], 0xFFFFFFF0)
+	if _, err := parse_WavHeader(bytes.NewReader(huge)); err == nil || !strings.Contains(err.Error(), "fmt chunk") {
+		t.Errorf("huge fmt chunk: %v", err)
+	}
+
+	// cbSize 가 붙은 18 바이트 fmt 청크
+	extended := wav[:16] + "\x12\x00\x00\x00" + wav[20:36] + "\x00\x00" + wav[36:]
+	format, err := parse_WavHeader(strings.NewReader(extended))
+	if err != nil || format.DataOffset != 46 || format.DataSize != 8 || format.SampleRate != 16000 {
+		t.Errorf("extended fmt chunk: %+v, %v", format, err)
+	}
+
+	// 4096 채널 × 16 비트를 uint16 으로 곱하면 0 이 되어 block align 0 이 통과했다
+	for _, blockAlign := range []uint16{0, 8192} {
+		overflow := []byte(wav)
+		binary.LittleEndian.PutUint16(overflow[22:24], 4096)
+		binary.LittleEndian.PutUint16(overflow[32:34], blockAlign)
+		if _, err := parse_WavHeader(bytes.NewReader(overflow)); err == nil {
+			t.Errorf("4096 channels with block align %v accepted", blockAlign)
+		}
+	}
+}
+
+func TestAudioMetadata(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Object(t, "original/1.wav", wav_Layout(24000, 48000, 1))
+	server.put_Object(t, "enhance/1.wav", wav_Layout(24000, 48000, 1))
+	// 재시도한 enhancer 가 몰래 리샘플링하고 스테레오로 바꿨다
+	server.put_Object(t, "enhance/1.retry1.wav", wav_Layout(8000, 16000, 2))
+
+	res := decode[AudioInfo](t, server.post(t, "/getAudioMetadata", NeedAudioMetadata{Index: 0}))
+	original := res.Stages["original"]
+	if original.SampleRate != 48000 || original.Channels != 1 || original.BitsPerSample != 16 || original.DurationSecs != 0.5 {
+		t.Errorf("original %+v", original)
+	}
+	enhance := res.Stages["enhance"]
+	if enhance.Key != "enhance/1.retry1.wav" || enhance.Attempt != 1 || enhance.DurationSecs != 0.5 {
+		t.Errorf("enhance %+v", enhance)
+	}
+	if len(res.Changes) != 2 || !strings.Contains(res.Changes[0], "48000 Hz to 16000 Hz") || !strings.Contains(res.Changes[1], "1 channels to 2") {
+		t.Errorf("changes %v", res.Changes)
+	}
+	if err := res.Errors["equalize"]; err == nil || err.Problem != InputMissing {
+		t.Errorf("equalize %+v", err)
+	}
+
+	if recorder := server.do(t, http.MethodPost, "/getAudioMetadata", NeedAudioMetadata{Index: -1}); recorder.Code != http.StatusBadRequest {
+		t.Errorf("negative index: %v", recorder.Code)
+	}
+}
+
+func TestAudioLevels(t *testing.T) {
+	server := new_TestServer(t)
+
+	// 1 초는 잡음만, 1 초는 1kHz 사인파 + 잡음
+	speech := func(amplitude float64, noise float64) string {
+		random := rand.New(rand.NewSource(1))
+		samples := make([]float64, 96000)
+		for i := range samples {
+			samples[i] = noise * (2*random.Float64() - 1)
+			if i >= 48000 {
+				samples[i] += amplitude * math.Sin(2*math.Pi*1000*float64(i)/48000)
+			}
+		}
+		return wav_Samples(48000, samples)
+	}
+	server.put_Object(t, "original/1.wav", speech(0.5, 0.01))
+	server.put_Object(t, "enhance/1.wav", speech(0.5, 0.0005))
+	server.put_Object(t, "equalize/1.wav", speech(1.2, 0.0005))
+
+	res := decode[AudioLevelsResult](t, server.post(t, "/getAudioLevels", NeedAudioLevels{Count: 2}))
+	if res.Count != 2 || len(res.Items) != 2 {
+		t.Fatalf("got %+v", res)
+	}
+	item := res.Items[0]
+	original := item.Stages["original"]
+	near := func(got float64, want float64, within float64) bool { return math.Abs(got-want) <= within }
+	if !near(original.RmsDb, -12.04, 0.2) || !near(original.PeakDb, -5.85, 0.1) || !near(original.LoudnessLufs, -9.03, 1) {
+		t.Errorf("original levels %+v", original)
+	}
+	if !near(original.NoiseFloorDb, -44.8, 1) || !near(original.SnrDb, 35.8, 1.5) || original.ClippingRatio != 0 {
+		t.Errorf("original noise %+v", original)
+	}
+	if change := item.Changes["enhance"]; !near(change.SnrDb, 26, 2) || !near(change.LoudnessLu, 0, 0.2) {
+		t.Errorf("enhance change %+v", change)
+	}
+	if equalize := item.Stages["equalize"]; equalize.ClippingRatio < 0.15 || equalize.ClippingRatio > 0.2 || item.Changes["equalize"].LoudnessLu < 5 {
+		t.Errorf("equalize %+v, change %+v", equalize, item.Changes["equalize"])
+	}
+	if len(res.Items[1].Stages) != 0 || res.Items[1].Errors["original"].Problem != InputMissing {
+		t.Errorf("item 1 %+v", res.Items[1])
+	}
+
+	if recorder := server.do(t, http.MethodPost, "/getAudioLevels", NeedAudioLevels{Count: maxLevelIndices + 1}); recorder.Code != http.StatusBadRequest {
+		t.Errorf("too many indices: %v", recorder.Code)
+	}
+
+	// 한 프레임도 안 되는 data 청크
+	short := []byte(wav_Layout(0, 8000, 1) + "\x00")
+	binary.LittleEndian.PutUint32(short[40:44], 1)
+	server.put_Object(t, "original/3.wav", string(short))
+	res = decode[AudioLevelsResult](t, server.post(t, "/getAudioLevels", NeedAudioLevels{Indices: []int{2}}))
+	if err := res.Items[2].Errors["original"]; err == nil || err.Problem != InputInvalid {
+		t.Errorf("short data chunk %+v", res.Items[2])
+	}
+	if _, err := json.Marshal(new_LevelMeter(WavFormat{Channels: 1, SampleRate: 8000, BitsPerSample: 16, BlockAlign: 2}).levels()); err != nil {
+		t.Errorf("levels without samples: %v", err)
+	}
+
+	// float 샘플의 Inf 와 NaN 은 클리핑으로 센다
+	inf, nan := float32(math.Inf(1)), float32(math.NaN())
+	server.put_Object(t, "original/4.wav", wav_Floats(8000, []float32{0.5, inf, -inf, nan, 0.25, 3e38, -0.5, 0}))
+	res = decode[AudioLevelsResult](t, server.post(t, "/getAudioLevels", NeedAudioLevels{Indices: []int{3}}))
+	levels, ok := res.Items[3].Stages["original"]
+	if !ok || levels.ClippingRatio != 0.5 {
+		t.Fatalf("non-finite samples %+v", res.Items[3])
+	}
+	for name, value := range map[string]float64{"rms": levels.RmsDb, "peak": levels.PeakDb, "loudness": levels.LoudnessLufs, "noise": levels.NoiseFloorDb, "snr": levels.SnrDb} {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			t.Errorf("%v level %v", name, value)
+		}
+	}
+}
+
+func TestWaveformAndSpectrogram(t *testing.T) {
+	server := new_TestServer(t)
+
+	// 앞 0.5 초는 무음, 뒤 0.5 초는 2kHz 사인파
+	samples := make([]float64, 16000)
+	for i := 8000; i < len(samples); i++ {
+		samples[i] = 0.5 * math.Sin(2*math.Pi*2000*float64(i)/16000)
+	}
+	server.put_Object(t, "original/1.wav", wav_Samples(16000, samples))
+	server.put_Object(t, "enhance/1.wav", "RIFF")
+
+	waveform := decode[Waveform](t, server.post(t, "/getWaveform", NeedWaveform{Index: 0, Stage: "original", Points: 100}))
+	if waveform.FramesPerPoint != 160 || len(waveform.Min) != 100 || len(waveform.Max) != 100 || waveform.DurationSecs != 1 {
+		t.Fatalf("got %v points of %v frames", len(waveform.Max), waveform.FramesPerPoint)
+	}
+	if waveform.Max[10] != 0 || math.Abs(float64(waveform.Max[90])-0.5) > 0.01 || math.Abs(float64(waveform.Min[90])+0.5) > 0.01 {
+		t.Errorf("peaks %v %v %v", waveform.Max[10], waveform.Min[90], waveform.Max[90])
+	}
+
+	// 캐시는 오디오가 바뀌기 전까지 그대로 쓴다
+	server.put_Object(t, "original/1.waveform100.json", `{"key":"cached","framesPerPoint":1}`)
+	cached := decode[Waveform](t, server.post(t, "/getWaveform", NeedWaveform{Index: 0, Stage: "original", Points: 100}))
+	if cached.Key != "cached" {
+		t.Errorf("cache not used: %v", cached.Key)
+	}
+	server.put_Object(t, "original/1.wav", wav_Samples(16000, samples))
+	redrawn := decode[Waveform](t, server.post(t, "/getWaveform", NeedWaveform{Index: 0, Stage: "original", Points: 100}))
+	if redrawn.Key != "original/1.wav" {
+		t.Errorf("stale cache used: %v", redrawn.Key)
+	}
+
+	spectrogram := decode[Spectrogram](t, server.post(t, "/getSpectrogram", NeedSpectrogram{Index: 0, Stage: "original", Width: 50, Height: 64}))
+	assert_Presigned(t, spectrogram.Url, "GET", "original/1.spectrogram50x64.png")
+	img, err := png.Decode(strings.NewReader(server.read_Object(t, spectrogram.ImageKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.Bounds().Dx() != 50 || img.Bounds().Dy() != 64 {
+		t.Fatalf("image %v", img.Bounds())
+	}
+	// 2kHz 는 128 점 FFT 의 16 번째 bin
+	red := func(x int, bin int) uint32 { r, _, _, _ := img.At(x, 63-bin).RGBA(); return r >> 8 }
+	if red(45, 16) < 200 || red(45, 40) > 100 || red(5, 16) > 10 {
+		t.Errorf("tone %v, other band %v, silence %v", red(45, 16), red(45, 40), red(5, 16))
+	}
+
+	for body, want := range map[NeedSpectrogram]int{
+		{Index: 0, Stage: "analyze"}:               http.StatusBadRequest,
+		{Index: 0, Stage: "original", Height: 100}: http.StatusBadRequest,
+		{Index: 0, Stage: "equalize"}:              http.StatusNotFound,
+		{Index: 0, Stage: "enhance"}:               http.StatusUnprocessableEntity,
+	} {
+		if recorder := server.do(t, http.MethodPost, "/getSpectrogram", body); recorder.Code != want {
+			t.Errorf("%+v: got %v, want %v", body, recorder.Code, want)
+		}
+	}
+
+	// Inf 와 NaN 이 섞인 float 샘플도 캐시하고 JSON 으로 보낼 수 있어야 한다
+	inf, nan := float32(math.Inf(1)), float32(math.NaN())
+	server.put_Object(t, "original/2.wav", wav_Floats(8000, []float32{0.5, inf, nan, -inf, 0.25, 0}))
+	peaks := decode[Waveform](t, server.post(t, "/getWaveform", NeedWaveform{Index: 1, Stage: "original", Points: 3}))
+	if len(peaks.Max) != 3 || peaks.Max[0] != 1 || peaks.Max[1] != 1 || peaks.Min[1] != -1 {
+		t.Errorf("non-finite peaks %v %v", peaks.Min, peaks.Max)
+	}
+	server.post(t, "/getSpectrogram", NeedSpectrogram{Index: 1, Stage: "original", Width: 4, Height: 32})
+}
+
+// getFailStorage fails every GetObject.
+type getFailStorage struct {
+	*MemoryStorage
+}
+
+func (storage getFailStorage) GetObject(ctx context.Context, objectKey string) (io.ReadCloser, error) {
+	return nil, errors.New("storage unavailable")
+}
+
+func TestScoreTranscripts(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Object(t, "stt_original/1.txt", "the cat sat on mat")
+	server.put_Object(t, "stt/1.txt", "The cat sat on the mat!")
+	server.put_Object(t, "stt_original/2.txt", "오늘 날씨 좋네요")
+
+	res := decode[TranscriptScores](t, server.post(t, "/scoreTranscripts", NeedScore{References: map[int]string{
+		0: "the cat sat on the mat",
+		1: "오늘 날씨가 좋네요.",
+	}}))
+	if res.Count != 2 || len(res.Items) != 2 {
+		t.Fatalf("got %+v", res)
+	}
+
+	first := res.Items[0]
+	if first.Original.Key != "stt_original/1.txt" || first.Original.Words != (EditCounts{Reference: 6, Hits: 5, Deletions: 1}) {
+		t.Errorf("original %+v", first.Original)
+	}
+	if first.Equalized.Wer != 0 || first.Equalized.Cer != 0 || first.Delta == nil || first.Delta.Wer != -1.0/6 {
+		t.Errorf("equalized %+v, delta %+v", first.Equalized, first.Delta)
+	}
+
+	// 한국어는 띄어쓰기 없이 글자 단위로도 센다
+	second := res.Items[1]
+	if second.Original.Words.Substitutions != 1 || second.Original.Chars != (EditCounts{Reference: 8, Hits: 7, Deletions: 1}) {
+		t.Errorf("korean %+v", second.Original)
+	}
+	if second.Delta != nil || second.Errors["equalized"].Problem != InputMissing {
+		t.Errorf("untranscribed item %+v", second)
+	}
+
+	if original := res.Averages["original"]; original.Items != 2 || original.Wer != 0.25 || original.Words.Reference != 9 {
+		t.Errorf("original average %+v", original)
+	}
+	if res.Averages["equalized"].Items != 1 || res.Delta.Wer != -1.0/6 {
+		t.Errorf("equalized average %+v, delta %+v", res.Averages["equalized"], res.Delta)
+	}
+
+	if counts := align([]string{"a", "b"}, []string{"x", "a", "b"}); counts != (EditCounts{Reference: 2, Hits: 2, Insertions: 1}) {
+		t.Errorf("insertion %+v", counts)
+	}
+	if recorder := server.do(t, http.MethodPost, "/scoreTranscripts", NeedScore{}); recorder.Code != http.StatusBadRequest {
+		t.Errorf("no references: %v", recorder.Code)
+	}
+
+	long := strings.Repeat("가", maxReferenceChars+1)
+	if recorder := server.do(t, http.MethodPost, "/scoreTranscripts", NeedScore{References: map[int]string{0: long}}); recorder.Code != http.StatusBadRequest {
+		t.Errorf("long reference: %v", recorder.Code)
+	}
+
+	// 저장소 오류는 항목별 오류가 아니라 500 이다
+	server.app.Storage = getFailStorage{server.storage}
+	if recorder := server.do(t, http.MethodPost, "/scoreTranscripts", NeedScore{References: map[int]string{0: "the cat"}}); recorder.Code != http.StatusInternalServerError {
+		t.Errorf("storage error: %v", recorder.Code)
+	}
+	server.app.Storage = server.storage
+	// 저장된 정답과 받아쓰기가 너무 길면 채점하지 않고 알린다
+	server.put_Object(t, "reference/3.txt", strings.Repeat("나", 5000))
+	server.put_Object(t, "stt_original/3.txt", strings.Repeat("다", 5000))
+	res = decode[TranscriptScores](t, server.post(t, "/scoreTranscripts", NeedScore{Indices: []int{2}}))
+	if err := res.Items[2].Errors["original"]; err == nil || err.Problem != InputInvalid || res.Items[2].Original != nil {
+		t.Errorf("too long to score %+v", res.Items[2])
+	}
+}
+
+func TestTextNormalization(t *testing.T) {
+	for digits, want := range map[string]string{
+		"0":         "영",
+		"10":        "십",
+		"35":        "삼십오",
+		"1000":      "천",
+		"2024":      "이천이십사",
+		"10000":     "만",
+		"11000":     "만천",
+		"100000000": "일억",
+		"120000305": "일억이천만삼백오",
+		"010":       "공일공",
+	} {
+		if got := sino_Korean(digits); got != want {
+			t.Errorf("sino_Korean(%v) = %v, want %v", digits, got, want)
+		}
+	}
+	if got := read_Numeral("1,500.25"); got != "천오백점이오" {
+		t.Errorf("read_Numeral = %v", got)
+	}
+	if got := string(hangul_Jamo([]rune("같a"))); got != "\u1100\u1161\u11c0a" {
+		t.Errorf("hangul_Jamo = %q", got)
+	}
+
+	// 띄어쓰기, 문장부호, 숫자 표기만 다르다
+	reference, hypothesis := "2024년에 학교에 갔어요.", "이천이십사 년에 학교에갔어요"
+	if score, _ := score_Transcript(reference, hypothesis, default_TextNormalization()); score.Wer != 0 || score.Cer != 0 {
+		t.Errorf("normalized %+v", score)
+	}
+	if score, _ := score_Transcript(reference, hypothesis, TextNormalization{StripPunctuation: true}); score.Wer != 1 {
+		t.Errorf("spacing and numerals counted %+v", score)
+	}
+	if words := respace_Words([]string{"오늘", "날씨가", "좋네요"}, []string{"오늘날씨가", "좋", "네요"}); strings.Join(words, " ") != "오늘 날씨가 좋네요" {
+		t.Errorf("respaced %v", words)
+	}
+
+	server := new_TestServer(t)
+	server.put_Object(t, "stt_original/1.txt", "갔아요")
+	jamo := TextNormalization{IgnoreSpacing: true, StripPunctuation: true, UnifyNumerals: true, Jamo: true}
+	res := decode[TranscriptScores](t, server.post(t, "/scoreTranscripts", NeedScore{References: map[int]string{0: "같아요"}, Normalization: &jamo}))
+	if res.Normalization != jamo || res.Items[0].Original.Chars != (EditCounts{Reference: 7, Hits: 6, Substitutions: 1}) {
+		t.Errorf("jamo %+v %+v", res.Normalization, res.Items[0].Original)
+	}
+	res = decode[TranscriptScores](t, server.post(t, "/scoreTranscripts", NeedScore{References: map[int]string{0: "같아요"}}))
+	if res.Normalization != default_TextNormalization() || res.Items[0].Original.Cer != 1.0/3 {
+		t.Errorf("syllables %+v %+v", res.Normalization, res.Items[0].Original)
+	}
+}
+
+func TestSttLifecycle(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Object(t, "original/1.wav", "RIFF")
+	server.put_Object(t, "equalize/1.wav", "RIFF")
+	server.transcriber.Transcripts["original/1.wav"] = "안녕 하세요"
+	server.transcriber.Transcripts["equalize/1.wav"] = "안녕하세요"
+
+	for _, isOriginal := range []bool{true, false} {
+		res := decode[STTStatus](t, server.post(t, "/startStt", NeedSTT{Index: 0, IsOriginal: isOriginal}))
+		if !strings.HasSuffix(res.Result, "started") {
+			t.Fatalf("startStt(%v): %v", isOriginal, res.Result)
+		}
+	}
+
+	// starting the same index twice is rejected by the provider
+	res := decode[STTStatus](t, server.post(t, "/startStt", NeedSTT{Index: 0}))
+	if strings.HasSuffix(res.Result, "started") {
+		t.Fatalf("duplicate startStt: %v", res.Result)
+	}
+
+	for _, isOriginal := range []bool{true, false} {
+		for _, want := range []string{JobStatusInProgress, JobStatusCompleted} {
+			res := decode[STTStatus](t, server.post(t, "/getStt", NeedSTT{Index: 0, IsOriginal: isOriginal}))
+			if res.Result != want {
+				t.Fatalf("getStt(%v): %v, want %v", isOriginal, res.Result, want)
+			}
+		}
+	}
+	if !server.has_Object("stt/1.json") || !server.has_Object("stt_original/1.json") {
+		t.Fatal("transcript json not written")
+	}
+
+	// cleanUpSTT treats Index as the number of items
+	for _, isOriginal := range []bool{true, false} {
+		recorder := server.post(t, "/cleanUpSTT", NeedSTT{Index: 1, IsOriginal: isOriginal})
+		if decode[string](t, recorder) != "delete ok" {
+			t.Fatalf("cleanUpSTT: %v", recorder.Body.String())
+		}
+	}
+
+	if got := server.read_Object(t, "stt_original/1.txt"); got != "안녕 하세요" {
+		t.Errorf("stt_original/1.txt = %q", got)
+	}
+	if got := server.read_Object(t, "stt/1.txt"); got != "안녕하세요" {
+		t.Errorf("stt/1.txt = %q", got)
+	}
+	if server.has_Object("stt/1.json") || server.has_Object("stt_original/1.json") {
+		t.Error("transcript json not deleted")
+	}
+	if jobs, _ := server.transcriber.ListJobs(context.TODO(), ""); len(jobs) != 0 {
+		t.Errorf("jobs left after cleanup: %+v", jobs)
+	}
+}
+
+func TestGetSttFailsWithoutMedia(t *testing.T) {
+	server := new_TestServer(t)
+
+	server.post(t, "/startStt", NeedSTT{Index: 4})
+	server.post(t, "/getStt", NeedSTT{Index: 4})
+	res := decode[STTStatus](t, server.post(t, "/getStt", NeedSTT{Index: 4}))
+
+	if res.Result != JobStatusFailed {
+		t.Fatalf("got %v", res.Result)
+	}
+}
+
+func TestTestCleanup(t *testing.T) {
+	server := new_TestServer(t)
+
+	server.post(t, "/startStt", NeedSTT{Index: 0})
+	server.post(t, "/startStt", NeedSTT{Index: 1})
+	server.post(t, "/test_cleanup", NeedSTT{Index: 2})
+
+	if jobs, _ := server.transcriber.ListJobs(context.TODO(), ""); len(jobs) != 0 {
+		t.Fatalf("jobs left: %+v", jobs)
+	}
+}
+
+// xlsx_Data builds a workbook with a sheet per name. Numbers are stored as
+// numbers; text is a shared string on the first sheet and inline elsewhere.
+func xlsx_Data(t *testing.T, names []string, sheets ...[][]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	write := func(name string, content string) {
+		part, err := archive.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(part, content)
+	}
+
+	workbook := `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets>`
+	rels := `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">`
+	var shared []string
+	for n, rows := range sheets {
+		id := strconv.Itoa(n + 1)
+		workbook += `<sheet name="` + names[n] + `" sheetId="` + id + `" r:id="rId` + id + `"/>`
+		rels += `<Relationship Id="rId` + id + `" Target="worksheets/sheet` + id + `.xml"/>`
+
+		sheet := `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+		for r, row := range rows {
+			sheet += `<row r="` + strconv.Itoa(r+1) + `">`
+			for c, value := range row {
+				ref := ` r="` + column_Name(c) + strconv.Itoa(r+1) + `"`
+				if _, err := strconv.ParseFloat(value, 64); err == nil {
+					sheet += `<c` + ref + `><v>` + value + `</v></c>`
+				} else if value != "" && n == 0 {
+					sheet += `<c` + ref + ` t="s"><v>` + strconv.Itoa(len(shared)) + `</v></c>`
+					shared = append(shared, value)
+				} else if value != "" {
+					sheet += `<c` + ref + ` t="inlineStr"><is><t>` + value + `</t></is></c>`
+				}
+			}
+			sheet += `</row>`
+		}
+		write("xl/worksheets/sheet"+id+".xml", sheet+`</sheetData></worksheet>`)
+	}
+	write("xl/workbook.xml", workbook+`</sheets></workbook>`)
+	write("xl/_rels/workbook.xml.rels", rels+`</Relationships>`)
+
+	sst := `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">`
+	for _, value := range shared {
+		sst += `<si><t>` + value + `</t></si>`
+	}
+	write("xl/sharedStrings.xml", sst+`</sst>`)
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func (server *testServer) upload_Excel(filename string, data []byte, fields map[string]string) *httptest.ResponseRecorder {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, _ := writer.CreateFormFile("excelfile", filename)
+	part.Write(data)
+	for name, value := range fields {
+		writer.WriteField(name, value)
+	}
+	writer.Close()
+
+	request := httptest.NewRequest(http.MethodPost, "/uploadExcel", &body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	recorder := httptest.NewRecorder()
+	server.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestUploadExcel(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Wav(t, "original/1.wav", "original/2.wav", "original/3.wav")
+
+	data := xlsx_Data(t, []string{"메모", "정답"},
+		[][]string{{"not the answers"}},
+		[][]string{
+			{"번호", "정답"},
+			{"1", "안녕하세요"},
+			{"2", ""},
+			{"3", "오늘 날씨가 좋네요"},
+			{"3.0", "again"},
+			{"three", "bad"},
+			{"4", "no audio"},
+			{},
+		})
+	recorder := server.upload_Excel("reference.xlsx", data, map[string]string{"sheet": "정답"})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("got %v %v", recorder.Code, recorder.Body.String())
+	}
+	if got := server.read_Object(t, "reference.xlsx"); got != string(data) {
+		t.Errorf("uploaded object has %v bytes", len(got))
+	}
+
+	report := decode[ExcelImport](t, recorder)
+	rows := func(issues []ExcelRowIssue) (numbers []int) {
+		for _, issue := range issues {
+			numbers = append(numbers, issue.Row)
+		}
+		return numbers
+	}
+	if report.Sheet != "정답" || report.Parsed != 2 || fmt.Sprint(report.Indices) != "[0 2]" {
+		t.Errorf("report %+v", report)
+	}
+	if fmt.Sprint(rows(report.Skipped)) != "[3 7 8]" || fmt.Sprint(rows(report.Malformed)) != "[5 6]" {
+		t.Errorf("skipped %+v, malformed %+v", report.Skipped, report.Malformed)
+	}
+	if server.read_Object(t, "reference/3.txt") != "오늘 날씨가 좋네요" || server.has_Object("reference/4.txt") {
+		t.Error("references not stored per item")
+	}
+
+	// 저장된 정답으로 채점한다
+	server.put_Object(t, "stt_original/1.txt", "안녕하세요")
+	scores := decode[TranscriptScores](t, server.post(t, "/scoreTranscripts", NeedScore{Count: 2}))
+	if scores.Items[0].Original == nil || scores.Items[0].Original.Wer != 0 || scores.Items[1].Errors["reference"].Problem != InputMissing {
+		t.Errorf("scores %+v", scores.Items)
+	}
+
+	for _, upload := range []struct {
+		data   []byte
+		fields map[string]string
+		want   int
+	}{
+		{[]byte("xlsx data"), nil, http.StatusUnprocessableEntity},
+		{data, map[string]string{"sheet": "없음"}, http.StatusUnprocessableEntity},
+		{data, map[string]string{"indexColumn": "B"}, http.StatusBadRequest},
+		{data, map[string]string{"referenceColumn": "1"}, http.StatusBadRequest},
+	} {
+		if recorder := server.upload_Excel("reference.xlsx", upload.data, upload.fields); recorder.Code != upload.want {
+			t.Errorf("%v: got %v %v", upload.fields, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	// 파일 이름의 경로는 버리고 세션 안에 저장한다
+	if recorder := server.upload_Excel(`..\..\other/answers.xlsx`, data, map[string]string{"sheet": "정답"}); recorder.Code != http.StatusOK {
+		t.Errorf("got %v %v", recorder.Code, recorder.Body.String())
+	}
+	if !server.has_Object("answers.xlsx") || decode[ExcelImport](t, server.upload_Excel("../answers.xlsx", data, map[string]string{"sheet": "정답"})).ObjectKey != "answers.xlsx" {
+		t.Error("filename not sanitized")
+	}
+
+	// 풀면 한도를 넘는 파트는 읽지 않는다
+	var bomb bytes.Buffer
+	archive := zip.NewWriter(&bomb)
+	part, err := archive.CreateRaw(&zip.FileHeader{Name: "xl/workbook.xml", Method: zip.Store, CompressedSize64: 9, UncompressedSize64: maxXlsxPartBytes + 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("<workbook"))
+	archive.Close()
+	recorder = server.upload_Excel("bomb.xlsx", bomb.Bytes(), nil)
+	if recorder.Code != http.StatusUnprocessableEntity || !strings.Contains(recorder.Body.String(), "unpacks to") {
+		t.Errorf("zip bomb: got %v %v", recorder.Code, recorder.Body.String())
+	}
+
+	recorder = server.upload_Excel("huge.xlsx", make([]byte, maxExcelUploadBytes+1), nil)
+	if recorder.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("huge upload: got %v", recorder.Code)
+	}
+}
+
+func TestUploadExcelWithoutFile(t *testing.T) {
+	server := new_TestServer(t)
+
+	request := httptest.NewRequest(http.MethodPost, "/uploadExcel", strings.NewReader("{}"))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	server.router.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("got %v %v", recorder.Code, recorder.Body.String())
+	}
+	if res := decode[map[string]string](t, recorder); res["error"] != "excelfile is required" {
+		t.Errorf("got %v", recorder.Body.String())
+	}
+}
+
+func TestExportReport(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Wav(t, "original/1.wav", "equalize/1.wav", "original/2.wav")
+	server.put_Object(t, "reference/1.txt", "오늘 날씨가 좋네요")
+	server.put_Object(t, "stt_original/1.txt", "오늘 날씨 좋네요")
+	server.put_Object(t, "stt/1.txt", "오늘 날씨가 좋네요")
+	server.app.Poller.track(stt_TrackedJob("", 0, false, ""))
+
+	recorder := server.post(t, "/exportReport", NeedReport{Count: 2})
+	res := decode[ExportedReport](t, recorder)
+	if res.Count != 2 || len(res.Files) != 2 || res.Files["csv"].Key != "reports/"+res.ReportId+".csv" || res.Files["xlsx"].Url == "" {
+		t.Fatalf("got %+v", res)
+	}
+
+	data := []byte(server.read_Object(t, res.Files["xlsx"].Key))
+	sheet, rows, err := read_XlsxRows(bytes.NewReader(data), int64(len(data)), "")
+	if err != nil || sheet != "Report" || len(rows) != 3 {
+		t.Fatalf("read back %v %v rows: %v", sheet, len(rows), err)
+	}
+	cell := func(row ExcelRow, heading string) string {
+		for i, column := range res.Columns {
+			if column == heading {
+				return row.Cells[column_Name(i)]
+			}
+		}
+		t.Fatalf("no column %v", heading)
+		return ""
+	}
+	first, second := rows[1], rows[2]
+	if cell(rows[0], "errors") != "errors" || cell(first, "item") != "1" || cell(first, "original sample rate") == "" {
+		t.Errorf("first row %+v", first.Cells)
+	}
+	if cell(first, "equalized WER") != "0" || cell(first, "WER delta") == "" || cell(first, "equalized transcript") != "오늘 날씨가 좋네요" {
+		t.Errorf("scores %+v", first.Cells)
+	}
+	if cell(first, "equalized job status") != JobStatusInProgress || cell(first, "equalized job started") == "" || cell(first, "original job status") != "" {
+		t.Errorf("jobs %+v", first.Cells)
+	}
+	if cell(first, "equalize audio url") == "" || cell(first, "enhance audio url") != "" || !strings.Contains(cell(first, "errors"), "enhance/1.wav is missing") {
+		t.Errorf("links %+v", first.Cells)
+	}
+	if cell(second, "reference") != "" || cell(second, "original WER") != "" || !strings.Contains(cell(second, "errors"), "stt/2.txt is missing") {
+		t.Errorf("second row %+v", second.Cells)
+	}
+
+	csv := server.read_Object(t, res.Files["csv"].Key)
+	if !strings.HasPrefix(csv, "\ufeffindex,item,") || strings.Count(csv, "\n") != 3 || !strings.Contains(csv, "오늘 날씨가 좋네요") {
+		t.Errorf("csv %q", csv)
+	}
+
+	only := decode[ExportedReport](t, server.post(t, "/exportReport", NeedReport{Indices: []int{1}, Formats: []string{"csv"}}))
+	if len(only.Files) != 1 || only.Files["csv"].Key == res.Files["csv"].Key {
+		t.Errorf("csv only %+v", only)
+	}
+	for _, request := range []NeedReport{{}, {Count: 1, Formats: []string{"pdf"}}, {Count: 1, Formats: []string{"csv", "csv"}}, {Count: 1, LifetimeSeconds: -1}} {
+		if recorder := server.do(t, http.MethodPost, "/exportReport", request); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%+v: got %v", request, recorder.Code)
+		}
+	}
+}
+
+func TestDiffTranscripts(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Object(t, "stt_original/1.txt", "오늘 날씨 좋네 정말")
+	server.put_Object(t, "stt/1.txt", "오늘 날씨가 좋네요 <정말>")
+	server.put_Object(t, "reference/1.txt", "오늘 날씨가 좋네요. 정말")
+
+	kinds := func(diff WordDiff) (kinds []string) {
+		for _, word := range diff.Words {
+			kinds = append(kinds, word.Kind+":"+word.From+">"+word.To)
+		}
+		return kinds
+	}
+
+	res := decode[TranscriptDiffs](t, server.post(t, "/diffTranscripts", NeedDiff{Index: 0}))
+	if res.Against != DiffEqualized || len(res.Diffs) != 1 || len(res.Errors) != 0 {
+		t.Fatalf("got %+v", res)
+	}
+	diff := res.Diffs[0]
+	if diff.From != "original" || diff.ToKey != "stt/1.txt" || diff.Counts != (EditCounts{Reference: 4, Hits: 2, Substitutions: 2}) {
+		t.Errorf("diff %+v", diff)
+	}
+	if got := fmt.Sprint(kinds(diff)); got != "[hit:오늘>오늘 substitution:날씨>날씨가 substitution:좋네>좋네요 hit:정말>정말]" {
+		t.Errorf("words %v", got)
+	}
+
+	res = decode[TranscriptDiffs](t, server.post(t, "/diffTranscripts", NeedDiff{Index: 0, Against: DiffReference}))
+	if len(res.Diffs) != 2 || res.Diffs[0].To != "original" || res.Diffs[0].Wer != 0.5 || res.Diffs[1].Wer != 0 {
+		t.Fatalf("against reference %+v", res)
+	}
+
+	// 띄어쓰기를 따지면 붙여 쓴 말은 끼어든 낱말이 된다
+	res = decode[TranscriptDiffs](t, server.post(t, "/diffTranscripts", NeedDiff{Index: 0, Normalization: &TextNormalization{StripPunctuation: true}}))
+	if got := fmt.Sprint(kinds(res.Diffs[0])); got != "[hit:오늘>오늘 substitution:날씨>날씨가 substitution:좋네>좋네요 hit:정말>정말]" {
+		t.Errorf("words %v", got)
+	}
+	server.put_Object(t, "stt/1.txt", "음 오늘 날씨")
+	res = decode[TranscriptDiffs](t, server.post(t, "/diffTranscripts", NeedDiff{Index: 0, Normalization: &TextNormalization{}}))
+	if got := fmt.Sprint(kinds(res.Diffs[0])); got != "[insertion:>음 hit:오늘>오늘 hit:날씨>날씨 deletion:좋네> deletion:정말>]" {
+		t.Errorf("words %v", got)
+	}
+
+	recorder := server.do(t, http.MethodGet, "/diffTranscripts?index=0", nil)
+	page := recorder.Body.String()
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("got %v %v", recorder.Code, page)
+	}
+	if !strings.Contains(page, "<del>오늘</del> <ins>음오늘</ins>") || !strings.Contains(page, `<span class="deletion"><del>좋네</del></span>`) {
+		t.Errorf("page %v", page)
+	}
+
+	server.put_Object(t, "stt/2.txt", "<script>")
+	res = decode[TranscriptDiffs](t, server.post(t, "/diffTranscripts", NeedDiff{Index: 1, Against: DiffReference}))
+	if len(res.Diffs) != 0 || res.Errors["reference"].Problem != InputMissing || res.Errors["equalized"] != nil {
+		t.Errorf("missing reference %+v", res)
+	}
+	res = decode[TranscriptDiffs](t, server.post(t, "/diffTranscripts", NeedDiff{Index: 1, Normalization: &TextNormalization{}}))
+	if res.Errors["original"].Key != "stt_original/2.txt" {
+		t.Errorf("missing original %+v", res)
+	}
+	recorder = server.do(t, http.MethodPost, "/diffTranscripts", NeedDiff{Index: 1, Format: "html", Normalization: &TextNormalization{}})
+	if strings.Contains(recorder.Body.String(), "<script>") {
+		t.Errorf("transcript not escaped: %v", recorder.Body.String())
+	}
+
+	for _, request := range []NeedDiff{{Index: -1}, {Against: "translation"}, {Format: "pdf"}} {
+		if recorder := server.do(t, http.MethodPost, "/diffTranscripts", request); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%+v: got %v", request, recorder.Code)
+		}
+	}
+	if recorder := server.do(t, http.MethodGet, "/diffTranscripts?index=one", nil); recorder.Code != http.StatusBadRequest {
+		t.Errorf("bad index: got %v", recorder.Code)
+	}
+}
+
+func TestVideoSttLifecycle(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Object(t, "video/1.mp4", "mp4")
+	server.transcriber.Transcripts["video/1.mp4"] = "hello class"
+
+	res := decode[STTStatus](t, server.post(t, "/startVideoStt", NeedSTT{Index: 0}))
+	if res.Result != "Transcription started" {
+		t.Fatalf("startVideoStt: %v", res.Result)
+	}
+
+	for _, want := range []string{JobStatusInProgress, JobStatusCompleted} {
+		res := decode[STTStatus](t, server.post(t, "/getVideoStt", NeedSTT{Index: 0}))
+		if res.Result != want {
+			t.Fatalf("getVideoStt: %v, want %v", res.Result, want)
+		}
+	}
+
+	server.post(t, "/cleanUpVideoSTT", NeedSTT{Index: 1})
+
+	if got := server.read_Object(t, "video/1.enT.txt"); got != "hello class" {
+		t.Errorf("video/1.enT.txt = %q", got)
+	}
+	if got := server.read_Object(t, "video/1.enT_ko.txt"); got != "[ko] hello class" {
+		t.Errorf("video/1.enT_ko.txt = %q", got)
+	}
+	if !server.has_Object("video/1.enT.srt") {
+		t.Error("subtitles not written")
+	}
+	if server.has_Object("video/1.enT.json") {
+		t.Error("transcript json not deleted")
+	}
+}
+
+func TestVideoMultipartUpload(t *testing.T) {
+	server := new_TestServer(t)
+
+	upload := decode[VideoUpload](t, server.post(t, "/startVideoUpload", NeedVideoUpload{Index: 0}))
+	if upload.Key != "video/1.mp4" || upload.UploadId == "" {
+		t.Fatalf("got %+v", upload)
+	}
+
+	presigned := decode[VideoUpload](t, server.post(t, "/presignVideoParts", NeedVideoUpload{UploadId: upload.UploadId, PartCount: 2}))
+	if len(presigned.Urls) != 2 || presigned.Urls[1].PartNumber != 2 {
+		t.Fatalf("got %+v", presigned)
+	}
+	assert_Presigned(t, presigned.Urls[1].Url, "PUT", "video/1.mp4")
+	if !strings.Contains(presigned.Urls[1].Url, "partNumber=2") {
+		t.Errorf("part url %v", presigned.Urls[1].Url)
+	}
+
+	first := bytes.Repeat([]byte("a"), minUploadPartSize)
+	firstETag, _ := server.storage.upload_Part(upload.UploadId, 1, first)
+
+	// 중간에 끊겨도 올라간 조각을 확인하고 이어서 올린다
+	listed := decode[VideoUpload](t, server.post(t, "/listVideoParts", NeedVideoUpload{UploadId: upload.UploadId}))
+	if len(listed.Parts) != 1 || listed.Parts[0].ETag != firstETag || listed.Parts[0].Size != minUploadPartSize {
+		t.Fatalf("got %+v", listed)
+	}
+	secondETag, _ := server.storage.upload_Part(upload.UploadId, 2, []byte("end"))
+
+	completed := decode[VideoUpload](t, server.post(t, "/completeVideoUpload", NeedVideoUpload{UploadId: upload.UploadId,
+		Parts: []UploadPart{{PartNumber: 2, ETag: secondETag}, {PartNumber: 1, ETag: firstETag}}}))
+	if len(completed.Parts) != 2 || completed.Parts[0].PartNumber != 1 {
+		t.Errorf("got %+v", completed)
+	}
+	if video := server.read_Object(t, "video/1.mp4"); len(video) != minUploadPartSize+3 || !strings.HasSuffix(video, "end") {
+		t.Errorf("video has %v bytes", len(video))
+	}
+	records := server.app.Store.list_Records(RecordQuery{Kind: RecordUpload})
+	if len(records) != 2 || records[0].Detail["status"] != "completed" {
+		t.Errorf("records %+v", records)
+	}
+
+	recorder := server.do(t, http.MethodPost, "/completeVideoUpload", NeedVideoUpload{UploadId: upload.UploadId,
+		Parts: []UploadPart{{PartNumber: 1, ETag: firstETag}}})
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("completed twice: got %v", recorder.Code)
+	}
+
+	// 마지막이 아닌 조각이 5 MiB 보다 작으면 거절된다
+	upload = decode[VideoUpload](t, server.post(t, "/startVideoUpload", NeedVideoUpload{Index: 1}))
+	smallETag, _ := server.storage.upload_Part(upload.UploadId, 1, []byte("small"))
+	server.storage.upload_Part(upload.UploadId, 2, []byte("end"))
+	recorder = server.do(t, http.MethodPost, "/completeVideoUpload", NeedVideoUpload{Index: 1, UploadId: upload.UploadId,
+		Parts: []UploadPart{{PartNumber: 1, ETag: smallETag}, {PartNumber: 2, ETag: secondETag}}})
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("small part: got %v", recorder.Code)
+	}
+
+	server.post(t, "/abortVideoUpload", NeedVideoUpload{Index: 1, UploadId: upload.UploadId})
+	if recorder := server.do(t, http.MethodPost, "/listVideoParts", NeedVideoUpload{Index: 1, UploadId: upload.UploadId}); recorder.Code != http.StatusNotFound {
+		t.Errorf("aborted upload: got %v", recorder.Code)
+	}
+	if server.has_Object("video/2.mp4") {
+		t.Error("aborted upload was stored")
+	}
+
+	for _, body := range []NeedVideoUpload{{}, {PartNumbers: []int32{0}}, {PartNumbers: []int32{3, 3}}, {PartCount: maxUploadParts + 1}} {
+		if recorder := server.do(t, http.MethodPost, "/presignVideoParts", body); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%+v: got %v", body, recorder.Code)
+		}
+	}
+}
+
+// tus sends a tus request with the given headers and body.
+func (server *testServer) tus(method string, path string, headers map[string]string, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header.Set("Tus-Resumable", TusVersion)
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	recorder := httptest.NewRecorder()
+	server.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func tus_MetadataHeader(pairs ...string) string {
+	var encoded []string
+	for i := 0; i < len(pairs); i += 2 {
+		encoded = append(encoded, pairs[i]+" "+base64.StdEncoding.EncodeToString([]byte(pairs[i+1])))
+	}
+	return strings.Join(encoded, ",")
+}
+
+func TestTusUpload(t *testing.T) {
+	server := new_TestServer(t)
+
+	options := server.tus(http.MethodOptions, "/uploads", nil, "")
+	if options.Code != http.StatusNoContent || options.Header().Get("Tus-Extension") != "creation,termination" {
+		t.Fatalf("options: %v %v", options.Code, options.Header())
+	}
+
+	created := server.tus(http.MethodPost, "/uploads", map[string]string{
+		"Upload-Length":   "10",
+		"Upload-Metadata": tus_MetadataHeader("kind", "audio", "index", "2"),
+	}, "")
+	location := created.Header().Get("Location")
+	if created.Code != http.StatusCreated || !strings.HasPrefix(location, "/uploads/") {
+		t.Fatalf("create: %v %v", created.Code, created.Body.String())
+	}
+
+	chunk := map[string]string{"Content-Type": "application/offset+octet-stream", "Upload-Offset": "0"}
+	if recorder := server.tus(http.MethodPatch, location, chunk, "RIFF"); recorder.Code != http.StatusNoContent || recorder.Header().Get("Upload-Offset") != "4" {
+		t.Fatalf("first chunk: %v %v", recorder.Code, recorder.Header())
+	}
+
+	// 연결이 끊긴 뒤 HEAD 로 위치를 확인하고 이어서 보낸다
+	head := server.tus(http.MethodHead, location, nil, "")
+	if head.Header().Get("Upload-Offset") != "4" || head.Header().Get("Upload-Length") != "10" {
+		t.Fatalf("head: %v", head.Header())
+	}
+	if recorder := server.tus(http.MethodPatch, location, chunk, "RIFF"); recorder.Code != http.StatusConflict {
+		t.Errorf("stale offset: got %v", recorder.Code)
+	}
+	if server.has_Object("original/3.wav") {
+		t.Fatal("committed before the upload finished")
+	}
+
+	chunk["Upload-Offset"] = "4"
+	if recorder := server.tus(http.MethodPatch, location, chunk, "-data-"); recorder.Code != http.StatusNoContent || recorder.Header().Get("Upload-Offset") != "10" {
+		t.Fatalf("last chunk: %v %v", recorder.Code, recorder.Header())
+	}
+	if data := server.read_Object(t, "original/3.wav"); data != "RIFF-data-" {
+		t.Errorf("stored %q", data)
+	}
+	// 저장한 업로드는 상태 파일까지 지운다
+	if head := server.tus(http.MethodHead, location, nil, ""); head.Code != http.StatusNotFound {
+		t.Errorf("finished upload: got %v", head.Code)
+	}
+	if staged, _ := filepath.Glob(filepath.Join(server.app.Uploads.Dir, "*")); len(staged) != 0 {
+		t.Errorf("staged files left after commit: %v", staged)
+	}
+
+	// 재시작한 뒤에도 스테이징된 업로드를 이어받는다
+	excel := server.tus(http.MethodPost, "/uploads", map[string]string{
+		"Upload-Length":   "3",
+		"Upload-Metadata": tus_MetadataHeader("kind", "excel", "filename", `C:\refs\answers.xlsx`),
+	}, "")
+	excelLocation := excel.Header().Get("Location")
+	server.app.Uploads, _ = new_TusUploads(server.app, server.app.Uploads.Dir, server.app.Uploads.MaxSize)
+	server.router = gin.New()
+	setRouter(server.router, server.app)
+	chunk["Upload-Offset"] = "0"
+	if recorder := server.tus(http.MethodPatch, excelLocation, chunk, "xls"); recorder.Code != http.StatusUnprocessableEntity {
+		t.Errorf("unreadable workbook: got %v", recorder.Code)
+	}
+	if !server.has_Object("answers.xlsx") {
+		t.Error("excel upload not stored under its file name")
+	}
+
+	// 엑셀은 /uploadExcel 처럼 정답 문장을 가져온다
+	workbook := string(xlsx_Data(t, []string{"Sheet1"}, [][]string{{"3", "세 번째 정답"}}))
+	excel = server.tus(http.MethodPost, "/uploads", map[string]string{
+		"Upload-Length":   strconv.Itoa(len(workbook)),
+		"Upload-Metadata": tus_MetadataHeader("kind", "excel", "filename", "answers.xlsx", "headerRows", "0"),
+	}, "")
+	if recorder := server.tus(http.MethodPatch, excel.Header().Get("Location"), chunk, workbook); recorder.Code != http.StatusNoContent {
+		t.Fatalf("workbook: got %v %v", recorder.Code, recorder.Body.String())
+	}
+	if server.read_Object(t, "reference/3.txt") != "세 번째 정답" {
+		t.Error("references not imported from the tus upload")
+	}
+
+	deleted := server.tus(http.MethodPost, "/uploads", map[string]string{"Upload-Length": "5", "Upload-Metadata": tus_MetadataHeader("kind", "video", "index", "0")}, "")
+	override := map[string]string{"X-HTTP-Method-Override": http.MethodDelete}
+	if recorder := server.tus(http.MethodPost, deleted.Header().Get("Location"), override, ""); recorder.Code != http.StatusNoContent {
+		t.Errorf("terminate: got %v", recorder.Code)
+	}
+	if recorder := server.tus(http.MethodHead, deleted.Header().Get("Location"), nil, ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("terminated upload: got %v", recorder.Code)
+	}
+}
+
+func TestTusUploadRejects(t *testing.T) {
+	server := new_TestServer(t)
+
+	audio := tus_MetadataHeader("kind", "audio", "index", "0")
+	for _, test := range []struct {
+		headers map[string]string
+		code    int
+	}{
+		{map[string]string{"Upload-Metadata": audio}, http.StatusBadRequest},
+		{map[string]string{"Upload-Length": "1", "Upload-Metadata": tus_MetadataHeader("kind", "pdf")}, http.StatusBadRequest},
+		{map[string]string{"Upload-Length": "1", "Upload-Metadata": tus_MetadataHeader("kind", "audio", "index", "-1")}, http.StatusBadRequest},
+		{map[string]string{"Upload-Length": "1", "Upload-Metadata": tus_MetadataHeader("kind", "excel", "filename", "a.xlsx", "headerRows", "one")}, http.StatusBadRequest},
+		{map[string]string{"Upload-Length": "1", "Upload-Metadata": tus_MetadataHeader("kind", "audio", "index", "0", "sessionId", "missing")}, http.StatusNotFound},
+		{map[string]string{"Upload-Length": strconv.Itoa(2 << 20), "Upload-Metadata": audio}, http.StatusRequestEntityTooLarge},
+		{map[string]string{"Upload-Length": "1", "Upload-Metadata": audio, "Tus-Resumable": "0.2.2"}, http.StatusPreconditionFailed},
+	} {
+		if recorder := server.tus(http.MethodPost, "/uploads", test.headers, ""); recorder.Code != test.code {
+			t.Errorf("%v: got %v, want %v", test.headers, recorder.Code, test.code)
+		}
+	}
+
+	created := server.tus(http.MethodPost, "/uploads", map[string]string{"Upload-Length": "1", "Upload-Metadata": audio}, "")
+	if recorder := server.tus(http.MethodPatch, created.Header().Get("Location"), map[string]string{"Upload-Offset": "0"}, "x"); recorder.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("wrong content type: got %v", recorder.Code)
+	}
+	if recorder := server.tus(http.MethodHead, "/uploads/..", nil, ""); recorder.Code != http.StatusNotFound {
+		t.Errorf("bad id: got %v", recorder.Code)
+	}
+}
+
+func TestCreateSession(t *testing.T) {
+	server := new_TestServer(t)
+
+	session := decode[Session](t, server.post(t, "/createSession", NeedSession{Name: "batch A"}))
+	if len(session.SessionId) != 16 || session.Name != "batch A" {
+		t.Fatalf("got %+v", session)
+	}
+
+	recorder := server.do(t, http.MethodGet, "/session/"+session.SessionId, nil)
+	if got := decode[Session](t, recorder); recorder.Code != http.StatusOK || got.SessionId != session.SessionId {
+		t.Fatalf("get session: %v %v", recorder.Code, recorder.Body.String())
+	}
+
+	if recorder := server.do(t, http.MethodGet, "/session/0123456789abcdef", nil); recorder.Code != http.StatusNotFound {
+		t.Fatalf("unknown session: %v", recorder.Code)
+	}
+}
+
+func TestUnknownSessionIsRejected(t *testing.T) {
+	server := new_TestServer(t)
+
+	recorder := server.do(t, http.MethodPost, "/presignEnhance", NeedEnhance{Count: 1, SessionId: "0123456789abcdef"})
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("got %v %v", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestMalformedBodiesAreRejected(t *testing.T) {
+	server := new_TestServer(t)
+
+	// 멀티파트나 tus 로 받는 경로는 JSON 본문이 없다
+	skip := map[string]bool{"/uploadExcel": true, "/uploads": true}
+	for _, route := range server.router.Routes() {
+		if route.Method != http.MethodPost || skip[route.Path] || strings.ContainsAny(route.Path, ":*") {
+			continue
+		}
+		recorder := server.do(t, http.MethodPost, route.Path, "not an object")
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%v: got %v", route.Path, recorder.Code)
+			continue
+		}
+		var body map[string]string
+		if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil || body["error"] == "" {
+			t.Errorf("%v: body %v", route.Path, recorder.Body.String())
+		}
+	}
+}
+
+func TestSessionsDoNotCollide(t *testing.T) {
+	server := new_TestServer(t)
+
+	first := decode[Session](t, server.post(t, "/createSession", NeedSession{}))
+	second := decode[Session](t, server.post(t, "/createSession", NeedSession{}))
+
+	for _, session := range []Session{first, second} {
+		server.put_Wav(t, "sessions/"+session.SessionId+"/original/1.wav")
+		res := decode[PreSignEnhance](t, server.post(t, "/presignEnhance", NeedEnhance{Count: 1, SessionId: session.SessionId}))
+		assert_Presigned(t, res.Urls[0].Input, "GET", "sessions/"+session.SessionId+"/original/1.wav")
+		assert_Presigned(t, res.Urls[0].Output, "PUT", "sessions/"+session.SessionId+"/enhance/1.wav")
+
+		server.put_Object(t, "sessions/"+session.SessionId+"/equalize/1.wav", "RIFF")
+		server.transcriber.Transcripts["sessions/"+session.SessionId+"/equalize/1.wav"] = session.SessionId
+	}
+
+	// the same index runs in both sessions without a job name conflict
+	for _, session := range []Session{first, second} {
+		res := decode[STTStatus](t, server.post(t, "/startStt", NeedSTT{Index: 0, SessionId: session.SessionId}))
+		if res.Result != "Transcription started" {
+			t.Fatalf("startStt(%v): %v", session.SessionId, res.Result)
+		}
+	}
+	for _, session := range []Session{first, second} {
+		server.post(t, "/getStt", NeedSTT{Index: 0, SessionId: session.SessionId})
+		server.post(t, "/getStt", NeedSTT{Index: 0, SessionId: session.SessionId})
+		server.post(t, "/cleanUpSTT", NeedSTT{Index: 1, SessionId: session.SessionId})
+
+		if got := server.read_Object(t, "sessions/"+session.SessionId+"/stt/1.txt"); got != session.SessionId {
+			t.Errorf("session %v transcript = %q", session.SessionId, got)
+		}
+	}
+}
+
+func TestPipelineRun(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Wav(t, "original/1.wav", "original/2.wav")
+
+	run := decode[PipelineRun](t, server.post(t, "/startPipeline", NeedPipeline{Count: 2}))
+	if run.Status != RunStatusRunning || len(run.Items) != 2 || run.Items[0].Stage != "" {
+		t.Fatalf("got %+v", run)
+	}
+
+	// URL 은 요청 안에서가 아니라 다음 주기에 발급한다
+	server.app.Pipelines.advance_All()
+	run = decode[PipelineRun](t, server.do(t, http.MethodGet, "/pipeline/"+run.RunId, nil))
+	for _, item := range run.Items {
+		if item.Stage != StageEnhance {
+			t.Fatalf("item %v in %v", item.Index, item.Stage)
+		}
+	}
+	assert_Presigned(t, run.Items[1].Urls["output"], "PUT", "enhance/2.wav")
+
+	// item 0 goes all the way, item 1 uploads a broken enhance output so it fails
+	steps := []struct {
+		objects map[string]string
+		want    [2]string
+	}{
+		{map[string]string{"enhance/1.wav": wav_Data(16), "enhance/2.wav": "data"}, [2]string{StageAnalyze, StageFailed}},
+		{map[string]string{"analyze/1.json": "data", "analyze/1_origin.json": "data"}, [2]string{StageEqualize, StageFailed}},
+		{map[string]string{"equalize/1.wav": wav_Data(16)}, [2]string{StageStt, StageFailed}},
+		{nil, [2]string{StageStt, StageFailed}},
+		{nil, [2]string{StageDone, StageFailed}},
+	}
+	for n, step := range steps {
+		for objectKey, data := range step.objects {
+			server.put_Object(t, objectKey, data)
+		}
+		server.app.Poller.poll_Due(time.Now().Add(time.Hour))
+		server.app.Pipelines.advance_All()
+
+		recorder := server.do(t, http.MethodGet, "/pipeline/"+run.RunId, nil)
+		run = decode[PipelineRun](t, recorder)
+		for i, want := range step.want {
+			if run.Items[i].Stage != want {
+				t.Fatalf("step %v: item %v in %v, want %v (%v)", n, i, run.Items[i].Stage, want, run.Items[i].Error)
+			}
+		}
+	}
+
+	if run.Status != RunStatusFailed || run.Stages[StageDone] != 1 || run.Stages[StageFailed] != 1 {
+		t.Fatalf("got %v %+v", run.Status, run.Stages)
+	}
+	assert_Presigned(t, run.Items[0].Urls["equalized"], "GET", "stt/1.txt")
+	if server.read_Object(t, "stt/1.txt") != "fake transcript of equalize/1.wav" {
+		t.Error("transcript not finalized")
+	}
+	if !strings.Contains(run.Items[1].Error, "enhance/2.wav is invalid") {
+		t.Errorf("failed item error %q", run.Items[1].Error)
+	}
+	if audio := run.Items[0].Audio; len(audio) != 3 || audio["equalize"].SampleRate != 16000 || len(run.Items[0].AudioChanges) != 0 {
+		t.Errorf("item audio %+v %v", audio, run.Items[0].AudioChanges)
+	}
+}
+
+func TestPipelineRunQueuesTranscriptions(t *testing.T) {
+	server := new_TestServer(t)
+	server.transcriber.MaxActiveJobs = 1
+	server.put_Wav(t, "original/1.wav", "enhance/1.wav", "equalize/1.wav")
+	server.put_Object(t, "analyze/1.json", "data")
+	server.put_Object(t, "analyze/1_origin.json", "data")
+
+	run := decode[PipelineRun](t, server.post(t, "/startPipeline", NeedPipeline{Count: 1}))
+	for i := 0; i < 4; i++ {
+		server.app.Pipelines.advance_All()
+	}
+	run, _ = server.app.Pipelines.get_Run(run.RunId)
+	// 한도에 걸린 받아쓰기는 실패가 아니라 대기열로 간다
+	if item := run.Items[0]; item.Stage != StageStt || item.Jobs["equalized"] != JobStatusPending {
+		t.Fatalf("item %+v", item)
+	}
+
+	for i := 0; i < 10 && run.Items[0].Stage == StageStt; i++ {
+		server.app.Poller.poll_Due(time.Now().Add(time.Hour))
+		server.app.Pipelines.advance_All()
+		run, _ = server.app.Pipelines.get_Run(run.RunId)
+	}
+	if run.Items[0].Stage != StageDone || server.read_Object(t, "stt/1.txt") != "fake transcript of equalize/1.wav" {
+		t.Errorf("item %+v", run.Items[0])
+	}
+}
+
+// listHookStorage calls onList before every ListObjects.
+type listHookStorage struct {
+	*MemoryStorage
+	onList func()
+}
+
+func (storage listHookStorage) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	storage.onList()
+	return storage.MemoryStorage.ListObjects(ctx, prefix)
+}
+
+func TestPipelineRunReadableWhileAdvancing(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Wav(t, "original/1.wav")
+	run := decode[PipelineRun](t, server.post(t, "/startPipeline", NeedPipeline{Count: 1}))
+	server.app.Pipelines.advance_All()
+
+	// 저장소를 읽는 동안에도 실행 상태는 조회할 수 있어야 한다
+	server.app.Storage = listHookStorage{server.storage, func() {
+		done := make(chan struct{})
+		go func() {
+			server.app.Pipelines.get_Run(run.RunId)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Error("get_Run blocked by advance")
+		}
+	}}
+	server.put_Wav(t, "enhance/1.wav")
+	server.app.Pipelines.advance_All()
+
+	if run, _ := server.app.Pipelines.get_Run(run.RunId); run.Items[0].Stage != StageAnalyze {
+		t.Errorf("item in %v", run.Items[0].Stage)
+	}
+	if recorder := server.do(t, http.MethodPost, "/startPipeline", NeedPipeline{Count: maxPipelineItems + 1}); recorder.Code != http.StatusBadRequest {
+		t.Errorf("too many items: got %v", recorder.Code)
+	}
+}
+
+func TestPipelineRunFollowsRetries(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Wav(t, "original/1.wav")
+	run := decode[PipelineRun](t, server.post(t, "/startPipeline", NeedPipeline{Count: 1}))
+	server.app.Pipelines.advance_All()
+
+	// 재시도한 출력만 있어도 다음 단계로 넘어간다
+	advance := func(want string) PipelineItem {
+		t.Helper()
+		server.app.Poller.poll_Due(time.Now().Add(time.Hour))
+		server.app.Pipelines.advance_All()
+		run = decode[PipelineRun](t, server.do(t, http.MethodGet, "/pipeline/"+run.RunId, nil))
+		if item := run.Items[0]; item.Stage != want {
+			t.Fatalf("item in %v, want %v (%v)", item.Stage, want, item.Error)
+		}
+		return run.Items[0]
+	}
+	server.put_Wav(t, "enhance/1.retry1.wav")
+	assert_Presigned(t, advance(StageAnalyze).Urls["inputurl"], "GET", "enhance/1.retry1.wav")
+	server.put_Object(t, "analyze/1.retry1.json", "data")
+	server.put_Object(t, "analyze/1.retry1_origin.json", "data")
+	advance(StageEqualize)
+	server.put_Wav(t, "equalize/1.retry2.wav")
+	advance(StageStt)
+	advance(StageStt)
+	advance(StageDone)
+
+	if got := server.read_Object(t, "stt/1.txt"); got != "fake transcript of equalize/1.retry2.wav" {
+		t.Errorf("transcribed %q", got)
+	}
+}
+
+func TestPipelineRunNotFound(t *testing.T) {
+	server := new_TestServer(t)
+
+	if recorder := server.do(t, http.MethodGet, "/pipeline/0123456789abcdef", nil); recorder.Code != http.StatusNotFound {
+		t.Fatalf("got %v", recorder.Code)
+	}
+}
+
+func TestRecords(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Wav(t, "original/1.wav", "original/2.wav")
+
+	server.post(t, "/presignEnhance", NeedEnhance{Count: 2})
+	server.post(t, "/startStt", NeedSTT{Index: 0, IsOriginal: true})
+	server.post(t, "/startStt", NeedSTT{Index: 0, IsOriginal: true})
+	server.post(t, "/getStt", NeedSTT{Index: 0, IsOriginal: true})
+	server.post(t, "/getStt", NeedSTT{Index: 0, IsOriginal: true})
+	server.post(t, "/cleanUpSTT", NeedSTT{Index: 1, IsOriginal: true})
+
+	records := decode[[]JobRecord](t, server.do(t, http.MethodGet, "/records", nil))
+	kinds := []string{}
+	for _, record := range records {
+		kinds = append(kinds, record.Kind+"/"+record.Outcome)
+	}
+	want := "cleanup/ok transcription/error transcription/ok presign/ok"
+	if strings.Join(kinds, " ") != want {
+		t.Fatalf("got %v, want %v", kinds, want)
+	}
+	if records[3].Name != "presignEnhance" || len(records[3].Indices) != 2 {
+		t.Errorf("presign record %+v", records[3])
+	}
+
+	records = decode[[]JobRecord](t, server.do(t, http.MethodGet, "/records?kind=transcription&limit=1", nil))
+	if len(records) != 1 || records[0].Error == "" {
+		t.Fatalf("got %+v", records)
+	}
+
+	record := decode[JobRecord](t, server.do(t, http.MethodGet, "/records/"+records[0].RecordId, nil))
+	if record.RecordId != records[0].RecordId || record.Detail["mediaKey"] != "original/1.wav" {
+		t.Errorf("got %+v", record)
+	}
+	if recorder := server.do(t, http.MethodGet, "/records/0123456789abcdef", nil); recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown record: %v", recorder.Code)
+	}
+	if recorder := server.do(t, http.MethodGet, "/records?since=yesterday", nil); recorder.Code != http.StatusBadRequest {
+		t.Errorf("bad since: %v", recorder.Code)
+	}
+}
+
+func TestJobStoreSurvivesRestart(t *testing.T) {
+	server := new_TestServer(t)
+	path := filepath.Join(t.TempDir(), "jobs.jsonl")
+
+	store, err := new_JobStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.app.Store = store
+	server.put_Wav(t, "original/1.wav")
+
+	run := decode[PipelineRun](t, server.post(t, "/startPipeline", NeedPipeline{Count: 1}))
+	server.app.Pipelines.advance_All()
+	server.put_Wav(t, "enhance/1.wav")
+	server.app.Pipelines.advance_All()
+
+	// 재시작: 같은 파일에서 다시 읽는다
+	store, err = new_JobStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.app.Store = store
+	server.app.Pipelines = new_Pipelines(server.app)
+
+	if records := store.list_Records(RecordQuery{Kind: RecordPresign}); len(records) != 2 {
+		t.Fatalf("got %+v", records)
+	}
+	restored := decode[PipelineRun](t, server.do(t, http.MethodGet, "/pipeline/"+run.RunId, nil))
+	if restored.Items[0].Stage != StageAnalyze {
+		t.Fatalf("restored run in %v", restored.Items[0].Stage)
+	}
+
+	server.put_Object(t, "analyze/1.json", "data")
+	server.put_Object(t, "analyze/1_origin.json", "data")
+	server.app.Pipelines.advance_All()
+	restored = decode[PipelineRun](t, server.do(t, http.MethodGet, "/pipeline/"+run.RunId, nil))
+	if restored.Items[0].Stage != StageEqualize {
+		t.Fatalf("restored run did not advance: %v", restored.Items[0].Stage)
+	}
+}
+
+func TestJobStoreCompactsWhileRunning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.jsonl")
+	store, err := new_JobStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.CompactBytes = 4096
+
+	// 오래 도는 서버에서 같은 실행이 계속 바뀐다
+	run := PipelineRun{RunId: "run", Status: RunStatusRunning, Items: make([]PipelineItem, 20)}
+	for i := 0; i < 500; i++ {
+		run.Items[i%20].Stage = fmt.Sprint(i)
+		store.save_Run(copy_Run(run))
+	}
+	store.add_Record(JobRecord{Kind: RecordPresign, Name: "kept"})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > 2*4096 {
+		t.Errorf("store file grew to %v bytes", len(data))
+	}
+
+	store, err = new_JobStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runs := store.list_StoredRuns()
+	if len(runs) != 1 || runs[0].Items[499%20].Stage != "499" || len(store.list_Records(RecordQuery{})) != 1 {
+		t.Errorf("reloaded %+v", runs)
+	}
+}
+
+func TestPollerFinalizesJobs(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Object(t, "original/1.wav", "RIFF")
+	server.put_Object(t, "video/1.mp4", "mp4")
+	server.transcriber.Transcripts["original/1.wav"] = "안녕 하세요"
+	server.transcriber.Transcripts["video/1.mp4"] = "hello class"
+
+	server.post(t, "/startStt", NeedSTT{Index: 0, IsOriginal: true})
+	server.post(t, "/startVideoStt", NeedSTT{Index: 0})
+
+	// 첫 backoff 전에는 조회하지 않는다
+	server.app.Poller.poll_Due(time.Now())
+	job := decode[TrackedJob](t, server.post(t, "/sttResult", NeedSTT{Index: 0, IsOriginal: true}))
+	if job.Polls != 0 || job.Status != JobStatusInProgress {
+		t.Fatalf("polled before backoff: %+v", job)
+	}
+
+	later := time.Now().Add(time.Hour)
+	server.app.Poller.poll_Due(later)
+	job = decode[TrackedJob](t, server.post(t, "/sttResult", NeedSTT{Index: 0, IsOriginal: true}))
+	if job.Finalized || job.Status != JobStatusInProgress {
+		t.Fatalf("after one poll: %+v", job)
+	}
+
+	server.app.Poller.poll_Due(later)
+	job = decode[TrackedJob](t, server.post(t, "/sttResult", NeedSTT{Index: 0, IsOriginal: true}))
+	if !job.Finalized || job.Status != JobStatusCompleted || job.CompletedAt == nil {
+		t.Fatalf("after two polls: %+v", job)
+	}
+	assert_Presigned(t, job.Urls["transcript"], "GET", "stt_original/1.txt")
+	if got := server.read_Object(t, "stt_original/1.txt"); got != "안녕 하세요" {
+		t.Errorf("stt_original/1.txt = %q", got)
+	}
+
+	video := decode[TrackedJob](t, server.post(t, "/videoSttResult", NeedSTT{Index: 0}))
+	if !video.Finalized {
+		t.Fatalf("video: %+v", video)
+	}
+	assert_Presigned(t, video.Urls["translation"], "GET", "video/1.enT_ko.txt")
+	if got := server.read_Object(t, "video/1.enT_ko.txt"); got != "[ko] hello class" {
+		t.Errorf("video/1.enT_ko.txt = %q", got)
+	}
+	if jobs, _ := server.transcriber.ListJobs(context.TODO(), ""); len(jobs) != 0 {
+		t.Errorf("jobs left after finalizing: %+v", jobs)
+	}
+
+	// 기존 클라이언트 흐름도 그대로 동작한다
+	res := decode[STTStatus](t, server.post(t, "/getStt", NeedSTT{Index: 0, IsOriginal: true}))
+	if res.Result != JobStatusCompleted {
+		t.Errorf("getStt after finalizing: %v", res.Result)
+	}
+	server.post(t, "/cleanUpSTT", NeedSTT{Index: 1, IsOriginal: true})
+	for _, record := range server.app.Store.list_Records(RecordQuery{Kind: RecordCleanup}) {
+		if record.Outcome != OutcomeOk {
+			t.Errorf("cleanup ran again: %+v", record)
+		}
+	}
+
+	jobs := decode[[]TrackedJob](t, server.do(t, http.MethodGet, "/sttJobs", nil))
+	if len(jobs) != 2 {
+		t.Errorf("got %+v", jobs)
+	}
+	if recorder := server.do(t, http.MethodPost, "/sttResult", NeedSTT{Index: 5}); recorder.Code != http.StatusNotFound {
+		t.Errorf("untracked job: %v", recorder.Code)
+	}
+}
+
+func TestPollerStopsOnFailedJob(t *testing.T) {
+	server := new_TestServer(t)
+
+	// equalize/1.wav 가 없어서 작업이 실패한다
+	server.post(t, "/startStt", NeedSTT{Index: 0})
+
+	later := time.Now().Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		server.app.Poller.poll_Due(later)
+	}
+
+	job := decode[TrackedJob](t, server.post(t, "/sttResult", NeedSTT{Index: 0}))
+	if job.Status != JobStatusFailed || job.Finalized || job.Error == "" || job.Polls != 2 {
+		t.Fatalf("got %+v", job)
+	}
+}
+
+func TestPollerKeepsJobsCleanedUpByClient(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Object(t, "original/1.wav", "RIFF")
+	server.transcriber.Transcripts["original/1.wav"] = "먼저 정리함"
+
+	// 예전 클라이언트가 폴러보다 먼저 결과를 받아 정리한다
+	server.post(t, "/startStt", NeedSTT{Index: 0, IsOriginal: true})
+	server.post(t, "/getStt", NeedSTT{Index: 0, IsOriginal: true})
+	server.post(t, "/getStt", NeedSTT{Index: 0, IsOriginal: true})
+	server.post(t, "/cleanUpSTT", NeedSTT{Index: 1, IsOriginal: true})
+
+	server.app.Poller.poll_Due(time.Now().Add(time.Hour))
+	job := decode[TrackedJob](t, server.post(t, "/sttResult", NeedSTT{Index: 0, IsOriginal: true}))
+	if job.Status != JobStatusCompleted || !job.Finalized || job.Error != "" {
+		t.Fatalf("got %+v", job)
+	}
+	assert_Presigned(t, job.Urls["transcript"], "GET", "stt_original/1.txt")
+}
+
+// getJobHookTranscriber calls onGet before every GetJob.
+type getJobHookTranscriber struct {
+	*FakeTranscriber
+	onGet func()
+}
+
+func (transcriber getJobHookTranscriber) GetJob(ctx context.Context, jobName string) (TranscriptionJob, error) {
+	transcriber.onGet()
+	return transcriber.FakeTranscriber.GetJob(ctx, jobName)
+}
+
+func TestPollerKeepsJobRestartedWhilePolling(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Object(t, "original/1.wav", "RIFF")
+	server.post(t, "/startStt", NeedSTT{Index: 0, IsOriginal: true})
+
+	// 폴링 도중 같은 이름의 작업이 새로 추적된다
+	restarted := TrackedJob{JobName: stt_JobName("", 0, true), ObjectKey: "stt_original/1", IsOriginal: true}
+	server.app.Transcriber = getJobHookTranscriber{server.transcriber, func() { server.app.Poller.track(restarted) }}
+	server.app.Poller.poll_Due(time.Now().Add(time.Hour))
+
+	job, _ := server.app.Poller.get_Job(restarted.JobName)
+	if job.Polls != 0 || job.Status != JobStatusInProgress {
+		t.Fatalf("restarted job overwritten: %+v", job)
+	}
+	if stored := server.app.Store.list_StoredJobs(); len(stored) != 1 || stored[0].Polls != 0 {
+		t.Errorf("stored %+v", stored)
+	}
+}
+
+// read_Events reads Server-Sent Events from body until count have arrived.
+func read_Events(t *testing.T, body io.Reader, count int) []Event {
+	t.Helper()
+
+	var events []Event
+	scanner := bufio.NewScanner(body)
+	for len(events) < count && scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var event Event
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			t.Fatalf("decode %v: %v", data, err)
+		}
+		events = append(events, event)
+	}
+	if len(events) < count {
+		t.Fatalf("stream ended after %+v: %v", events, scanner.Err())
+	}
+	return events
+}
+
+func TestEventStream(t *testing.T) {
+	server := new_TestServer(t)
+	session := decode[Session](t, server.post(t, "/createSession", NeedSession{Name: "events"}))
+	server.put_Object(t, session_Key(session.SessionId, "original/1.wav"), "RIFF")
+
+	httpServer := httptest.NewServer(server.router)
+	defer httpServer.Close()
+
+	response, err := http.Get(httpServer.URL + "/events?sessionId=" + session.SessionId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	if got := response.Header.Get("Content-Type"); got != "text/event-stream" {
+		t.Fatalf("content type %v", got)
+	}
+
+	// 다른 세션의 이벤트는 오지 않는다
+	server.post(t, "/presignEnhance", NeedEnhance{Count: 3})
+
+	server.post(t, "/presignEnhance", NeedEnhance{Count: 2, SessionId: session.SessionId})
+	server.post(t, "/startStt", NeedSTT{Index: 0, IsOriginal: true, SessionId: session.SessionId})
+	server.post(t, "/startStt", NeedSTT{Index: 0, IsOriginal: true, SessionId: session.SessionId})
+	later := time.Now().Add(time.Hour)
+	server.app.Poller.poll_Due(later)
+	server.app.Poller.poll_Due(later)
+
+	events := read_Events(t, response.Body, 6)
+	types := []string{}
+	for _, event := range events {
+		if event.SessionId != session.SessionId {
+			t.Errorf("event of another session: %+v", event)
+		}
+		types = append(types, event.Type)
+	}
+	want := "presign transcription error status transcription cleanup"
+	if strings.Join(types, " ") != want {
+		t.Fatalf("got %v, want %v", types, want)
+	}
+	if events[2].Kind != RecordTranscription || events[2].Error == "" {
+		t.Errorf("error event %+v", events[2])
+	}
+	if events[3].Status != JobStatusCompleted || events[3].Indices[0] != 0 {
+		t.Errorf("status event %+v", events[3])
+	}
+
+	// 재접속하면 Last-Event-ID 이후의 이벤트를 다시 받는다
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	request := httptest.NewRequest(http.MethodGet, "/events?sessionId="+session.SessionId, nil).WithContext(ctx)
+	request.Header.Set("Last-Event-ID", strconv.FormatInt(events[3].EventId, 10))
+	recorder := httptest.NewRecorder()
+	server.router.ServeHTTP(recorder, request)
+
+	replayed := read_Events(t, recorder.Body, 2)
+	if replayed[0].EventId != events[4].EventId || replayed[1].EventId != events[5].EventId {
+		t.Errorf("replayed %+v", replayed)
+	}
+}
+
+func TestEventStreamUnknownRun(t *testing.T) {
+	server := new_TestServer(t)
+
+	if recorder := server.do(t, http.MethodGet, "/events?runId=0123456789abcdef", nil); recorder.Code != http.StatusNotFound {
+		t.Fatalf("got %v", recorder.Code)
+	}
+}
+
+func TestWebhookCallbacks(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Object(t, "video/1.mp4", "mp4")
+
+	var mutex sync.Mutex
+	var payloads []WebhookPayload
+	requests := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		body, _ := io.ReadAll(r.Body)
+		if got := r.Header.Get(WebhookSignatureHeader); got != server.app.Webhooks.sign(body) {
+			t.Errorf("signature %v", got)
+		}
+		// 첫 요청은 실패시켜 재시도를 확인한다
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload WebhookPayload
+		json.Unmarshal(body, &payload)
+		payloads = append(payloads, payload)
+	}))
+	defer receiver.Close()
+
+	server.post(t, "/startVideoStt", NeedSTT{Index: 0, CallbackUrl: receiver.URL})
+	server.post(t, "/startStt", NeedSTT{Index: 0, CallbackUrl: receiver.URL + "/missing"})
+
+	later := time.Now().Add(time.Hour)
+	server.app.Poller.poll_Due(later)
+	server.app.Poller.poll_Due(later)
+	server.app.Webhooks.wait()
+
+	events := map[string]WebhookPayload{}
+	for _, payload := range payloads {
+		events[payload.JobName+" "+payload.Event] = payload
+	}
+	if len(payloads) != 3 {
+		t.Fatalf("got %+v", payloads)
+	}
+	artifacts := events["videoStt_0 "+WebhookArtifacts]
+	if strings.Join(artifacts.Artifacts, " ") != "video/1.enT.txt video/1.enT_ko.txt" || artifacts.Status != JobStatusCompleted {
+		t.Errorf("artifacts payload %+v", artifacts)
+	}
+	if _, ok := events["videoStt_0 "+WebhookCompleted]; !ok {
+		t.Errorf("no completed payload in %+v", payloads)
+	}
+	if failed := events["dolbyEqualizeStt_0 "+WebhookFailed]; failed.Error == "" {
+		t.Errorf("failed payload %+v", failed)
+	}
+
+	deliveries := decode[[]WebhookDelivery](t, server.do(t, http.MethodGet, "/webhookDeliveries", nil))
+	attempts := 0
+	for _, delivery := range deliveries {
+		if delivery.Outcome != DeliveryDelivered {
+			t.Errorf("delivery %+v", delivery)
+		}
+		attempts += delivery.Attempts
+	}
+	if len(deliveries) != 3 || attempts != 4 {
+		t.Errorf("got %+v", deliveries)
+	}
+	delivery := decode[WebhookDelivery](t, server.do(t, http.MethodGet, "/webhookDelivery/"+deliveries[0].DeliveryId, nil))
+	if delivery.DeliveryId != deliveries[0].DeliveryId {
+		t.Errorf("got %+v", delivery)
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	server := new_TestServer(t)
+
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	server.app.notify_TrackedJob(TrackedJob{JobName: "job", Status: JobStatusFailed, CallbackUrl: receiver.URL}, WebhookFailed)
+	server.app.Webhooks.wait()
+
+	deliveries := server.app.Store.list_Deliveries("")
+	if len(deliveries) != 1 || deliveries[0].Outcome != DeliveryFailed || deliveries[0].Attempts != 3 || deliveries[0].StatusCode != http.StatusInternalServerError {
+		t.Fatalf("got %+v", deliveries)
+	}
+}
+
+func TestWebhookBlocksPrivateHosts(t *testing.T) {
+	server := new_TestServer(t)
+
+	for _, callbackUrl := range []string{"http://169.254.169.254/latest/meta-data", "http://10.0.0.5/hook", "http://localhost:9000", "http://[::1]/hook", "http://0.0.0.0/"} {
+		recorder := server.do(t, http.MethodPost, "/startStt", NeedSTT{Index: 0, CallbackUrl: callbackUrl})
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%v: got %v", callbackUrl, recorder.Code)
+		}
+	}
+
+	// 이름이 루프백으로 풀리는 호스트는 dial 에서 막힌다
+	requests := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer receiver.Close()
+	_, port, _ := net.SplitHostPort(receiver.Listener.Addr().String())
+
+	server.app.notify_TrackedJob(TrackedJob{JobName: "job", Status: JobStatusFailed, CallbackUrl: "http://localhost:" + port}, WebhookFailed)
+	server.app.Webhooks.wait()
+
+	deliveries := server.app.Store.list_Deliveries("")
+	if len(deliveries) != 1 || deliveries[0].Outcome != DeliveryFailed || !strings.Contains(deliveries[0].Error, ErrCallbackHostBlocked.Error()) {
+		t.Fatalf("got %+v", deliveries)
+	}
+	if requests != 0 {
+		t.Errorf("blocked host got %v requests", requests)
+	}
+}
+
+func TestWebhookResumesPendingDeliveries(t *testing.T) {
+	server := new_TestServer(t)
+
+	var mutex sync.Mutex
+	var payloads []WebhookPayload
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		var payload WebhookPayload
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads = append(payloads, payload)
+	}))
+	defer receiver.Close()
+
+	// 재시작 전에 끝나지 못한 전송
+	now := time.Now().UTC()
+	payload := WebhookPayload{DeliveryId: "resumed", Event: WebhookCompleted, JobName: "job", Status: JobStatusCompleted}
+	server.app.Store.save_Delivery(WebhookDelivery{DeliveryId: "resumed", Url: receiver.URL, Event: WebhookCompleted, JobName: "job",
+		Outcome: DeliveryPending, Attempts: 1, Payload: &payload, CreatedAt: now, UpdatedAt: now})
+	server.app.Store.save_Delivery(WebhookDelivery{DeliveryId: "old", Url: receiver.URL, Event: WebhookCompleted, JobName: "job",
+		Outcome: DeliveryPending, CreatedAt: now, UpdatedAt: now})
+
+	server.app.Webhooks.resume_Pending()
+	server.app.Webhooks.wait()
+
+	if len(payloads) != 1 || payloads[0].DeliveryId != "resumed" || payloads[0].JobName != "job" {
+		t.Fatalf("got %+v", payloads)
+	}
+	if delivery, _ := server.app.Store.get_Delivery("resumed"); delivery.Outcome != DeliveryDelivered || delivery.Attempts != 2 {
+		t.Errorf("got %+v", delivery)
+	}
+	if delivery, _ := server.app.Store.get_Delivery("old"); delivery.Outcome != DeliveryFailed || delivery.Error == "" {
+		t.Errorf("got %+v", delivery)
+	}
+}
+
+func TestStartSttRejectsBadCallbackUrl(t *testing.T) {
+	server := new_TestServer(t)
+
+	recorder := server.do(t, http.MethodPost, "/startStt", NeedSTT{Index: 0, CallbackUrl: "ftp://example.com"})
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("got %v", recorder.Code)
+	}
+	if jobs, _ := server.transcriber.ListJobs(context.TODO(), ""); len(jobs) != 0 {
+		t.Errorf("job started anyway: %+v", jobs)
+	}
+}
+
+func TestSttBatch(t *testing.T) {
+	server := new_TestServer(t)
+	server.transcriber.MaxActiveJobs = 3
+	for i := 1; i <= 5; i++ {
+		server.put_Object(t, "original/"+strconv.Itoa(i)+".wav", "RIFF")
+	}
+
+	status := decode[STTBatchStatus](t, server.post(t, "/startSttBatch", NeedSTTBatch{Count: 5, IsOriginal: true}))
+	if status.Count != 5 || status.Started != 3 || status.Queued != 2 || status.Failed != 0 {
+		t.Fatalf("got %+v", status)
+	}
+	for i, result := range status.Results {
+		if result.Index != i || result.JobName != stt_JobName("", i, true) {
+			t.Errorf("result %v: %+v", i, result)
+		}
+	}
+
+	var queued STTBatchResult
+	for _, result := range status.Results {
+		if result.Result == BatchQueued {
+			queued = result
+		}
+	}
+	res := decode[STTStatus](t, server.post(t, "/getStt", NeedSTT{Index: queued.Index, IsOriginal: true}))
+	if res.Result != JobStatusPending {
+		t.Errorf("getStt of a queued job: %v", res.Result)
+	}
+
+	// 대기열의 작업은 앞선 작업이 끝나면 poller 가 시작한다
+	later := time.Now().Add(time.Hour)
+	for round := 0; round < 10; round++ {
+		server.app.Poller.poll_Due(later)
+	}
+	for i := 1; i <= 5; i++ {
+		if !server.has_Object("stt_original/" + strconv.Itoa(i) + ".txt") {
+			t.Errorf("stt_original/%v.txt not written", i)
+		}
+	}
+	for _, job := range server.app.Poller.list_Jobs("") {
+		if !job.Finalized {
+			t.Errorf("job not finalized: %+v", job)
+		}
+	}
+}
+
+func TestSttBatchIndices(t *testing.T) {
+	server := new_TestServer(t)
+
+	status := decode[STTBatchStatus](t, server.post(t, "/startSttBatch", NeedSTTBatch{Indices: []int{4, 2}}))
+	if status.Started != 2 || status.Results[0].JobName != stt_JobName("", 4, false) || status.Results[1].Index != 2 {
+		t.Fatalf("got %+v", status)
+	}
+
+	// 같은 작업을 다시 시작하면 실패로 표시된다
+	status = decode[STTBatchStatus](t, server.post(t, "/startSttBatch", NeedSTTBatch{Indices: []int{2, 3}}))
+	if status.Failed != 1 || status.Results[0].Result != BatchFailed || status.Results[0].Error == "" {
+		t.Fatalf("got %+v", status)
+	}
+
+	status = decode[STTBatchStatus](t, server.post(t, "/startSttBatch", NeedSTTBatch{Range: &IndexRange{Start: 10, End: 13}}))
+	if status.Count != 3 || status.Started != 3 || status.Results[2].Index != 12 {
+		t.Fatalf("got %+v", status)
+	}
+
+	for _, body := range []NeedSTTBatch{{}, {Indices: []int{1, 1}}, {Indices: []int{-1}}, {Count: maxSttBatch + 1},
+		{Range: &IndexRange{Start: 0, End: maxSttBatch + 1}}} {
+		if recorder := server.do(t, http.MethodPost, "/startSttBatch", body); recorder.Code != http.StatusBadRequest {
+			t.Errorf("%+v: got %v", body, recorder.Code)
+		}
+	}
+}
+
+func TestPresignEnhanceRetry(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Wav(t, "original/1.wav", "original/2.wav", "original/3.wav", "original/4.wav")
+	server.put_Object(t, "enhance/1.wav", "ok")
+	server.put_Object(t, "enhance/3.wav", "ok")
+
+	// 출력이 없는 2, 4 번만 다시 발급된다
+	res := decode[PreSignEnhance](t, server.post(t, "/presignEnhance", NeedEnhance{Count: 4, Retry: 1}))
+	if res.Attempt != 1 || len(res.Urls) != 2 || res.Urls[0].Index != 1 || res.Urls[1].Index != 3 {
+		t.Fatalf("got %+v", res)
+	}
+	assert_Presigned(t, res.Urls[0].Output, "PUT", "enhance/2.retry1.wav")
+	assert_Presigned(t, res.Urls[0].Input, "GET", "original/2.wav")
+
+	// 명시한 인덱스는 출력이 있어도 다시 발급된다
+	res = decode[PreSignEnhance](t, server.post(t, "/presignEnhance", NeedEnhance{Count: 4, Retry: 2, Indices: []int{0}}))
+	if len(res.Urls) != 1 || res.Urls[0].Attempt != 2 {
+		t.Fatalf("got %+v", res)
+	}
+	assert_Presigned(t, res.Urls[0].Output, "PUT", "enhance/1.retry2.wav")
+}
+
+func TestRetryOutputsBecomeCurrent(t *testing.T) {
+	server := new_TestServer(t)
+	server.put_Wav(t, "original/1.wav", "enhance/1.retry2.wav")
+	server.put_Object(t, "enhance/1.wav", "first")
+	server.put_Object(t, "enhance/10.retry5.wav", "other item")
+
+	// 이후 단계는 가장 최근 시도의 결과를 입력으로 받는다
+	equalize := decode[PreSignEqualize](t, server.post(t, "/presignEqualize", NeedEqualize{Count: 1}))
+	assert_Presigned(t, equalize.Urls[0].Input, "GET", "enhance/1.retry2.wav")
+	assert_Presigned(t, equalize.Urls[0].Output, "PUT", "equalize/1.wav")
+
+	analyze := decode[PreSignAnalyze](t, server.post(t, "/presignAnalyze", NeedAnalyze{Count: 1, Retry: 1, Indices: []int{0}}))
+	assert_Presigned(t, analyze.UrlJsons[0].InputUrl, "GET", "enhance/1.retry2.wav")
+	assert_Presigned(t, analyze.UrlJsons[0].OutputJson, "PUT", "analyze/1.retry1.json")
+
+	server.put_Object(t, "analyze/1.json", "{}")
+	server.put_Object(t, "analyze/1_origin.json", "{}")
+	server.put_Object(t, "analyze/1.retry1.json", "{}")
+	server.put_Object(t, "analyze/1.retry1_origin.json", "{}")
+
+	current := decode[AnalyzeJson](t, server.post(t, "/getAnalyzeJson", NeedAnalyzeJson{Index: 0}))
+	if current.Attempt != 1 {
+		t.Errorf("current attempt %v", current.Attempt)
+	}
+	assert_Presigned(t, current.AnalyzeJsonData, "GET", "analyze/1.retry1.json")
+	assert_Presigned(t, current.OriginalAnalyzeJsonData, "GET", "analyze/1.retry1_origin.json")
+
+	// 이전 시도도 그대로 남아 있고 읽을 수 있다
+	previous := decode[AnalyzeJson](t, server.post(t, "/getAnalyzeJson", NeedAnalyzeJson{Index: 0, Retry: 1}))
+	assert_Presigned(t, previous.AnalyzeJsonData, "GET", "analyze/1.retry1.json")
+	if !server.has_Object("analyze/1.json") || !server.has_Object("enhance/1.wav") {
+		t.Error("earlier attempts were removed")
+	}
+
+	// equalize 재시도는 출력이 없는 항목만
+	retry := decode[PreSignEqualize](t, server.post(t, "/presignEqualize", NeedEqualize{Count: 1, Retry: 1}))
+	if len(retry.Urls) != 1 || retry.Attempt != 1 {
+		t.Fatalf("got %+v", retry)
+	}
+	assert_Presigned(t, retry.Urls[0].Output, "PUT", "equalize/1.retry1.wav")
+	server.put_Object(t, "equalize/1.retry1.wav", "RIFF")
+	retry = decode[PreSignEqualize](t, server.post(t, "/presignEqualize", NeedEqualize{Count: 1, Retry: 2}))
+	if len(retry.Urls) != 0 {
+		t.Fatalf("nothing left to retry, got %+v", retry)
+	}
+
+	server.post(t, "/startStt", NeedSTT{Index: 0})
+	records := server.app.Store.list_Records(RecordQuery{Kind: RecordTranscription, Limit: 1})
+	if records[0].Detail["mediaKey"] != "equalize/1.retry1.wav" {
+		t.Errorf("stt media %v", records[0].Detail["mediaKey"])
+	}
+}
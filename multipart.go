@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// ErrUploadNotFound is returned for an unknown multipart upload, or one that
+// was already completed or aborted.
+var ErrUploadNotFound = errors.New("multipart upload not found")
+
+// ErrInvalidParts is returned when the parts given to complete an upload
+// don't match the uploaded ones.
+var ErrInvalidParts = errors.New("invalid upload parts")
+
+// ErrMultipartUnsupported is returned when the storage backend can't take
+// an object in parts.
+var ErrMultipartUnsupported = errors.New("storage backend does not support multipart uploads")
+
+// S3 numbers parts 1..10000; every part but the last has at least 5 MiB.
+const (
+	maxUploadParts    = 10000
+	minUploadPartSize = 5 << 20
+)
+
+// UploadPart is one uploaded part. ETag is the ETag header the part's PUT
+// was answered with.
+type UploadPart struct {
+	PartNumber int32  `json:"partNumber"`
+	ETag       string `json:"etag"`
+	Size       int64  `json:"size,omitempty"`
+}
+
+// PartUrl is the presigned PUT of one part.
+type PartUrl struct {
+	PartNumber int32  `json:"partNumber"`
+	Url        string `json:"url"`
+}
+
+// MultipartStorage is implemented by the Storage backends that accept an
+// object uploaded by the client in parts, so a large upload can be resumed
+// part by part instead of starting over.
+type MultipartStorage interface {
+	// CreateMultipartUpload starts an upload of objectKey and returns its id.
+	CreateMultipartUpload(ctx context.Context, objectKey string, contentType string) (string, error)
+	// PresignUploadPart returns a URL any HTTP client can PUT part partNumber to.
+	PresignUploadPart(ctx context.Context, objectKey string, uploadId string, partNumber int32, lifetimeSecs int64) (string, error)
+	// ListUploadedParts returns the parts uploaded so far, ordered by number.
+	ListUploadedParts(ctx context.Context, objectKey string, uploadId string) ([]UploadPart, error)
+	// CompleteMultipartUpload joins parts, ordered by number, into objectKey.
+	CompleteMultipartUpload(ctx context.Context, objectKey string, uploadId string, parts []UploadPart) error
+	// AbortMultipartUpload drops the upload and every part uploaded for it.
+	AbortMultipartUpload(ctx context.Context, objectKey string, uploadId string) error
+}
+
+func (app *App) multipart_Storage() (MultipartStorage, error) {
+	storage, ok := app.Storage.(MultipartStorage)
+	if !ok {
+		return nil, ErrMultipartUnsupported
+	}
+	return storage, nil
+}
+
+// part_Numbers returns partNumbers when given, otherwise 1..partCount.
+func part_Numbers(partNumbers []int32, partCount int) ([]int32, error) {
+
+	if len(partNumbers) == 0 {
+		if partCount <= 0 || partCount > maxUploadParts {
+			return nil, fmt.Errorf("partNumbers or a partCount between 1 and %v is required", maxUploadParts)
+		}
+		for i := 1; i <= partCount; i++ {
+			partNumbers = append(partNumbers, int32(i))
+		}
+		return partNumbers, nil
+	}
+
+	seen := map[int32]bool{}
+	for _, partNumber := range partNumbers {
+		if partNumber < 1 || partNumber > maxUploadParts {
+			return nil, fmt.Errorf("part number %v is not between 1 and %v", partNumber, maxUploadParts)
+		}
+		if seen[partNumber] {
+			return nil, fmt.Errorf("part number %v is listed twice", partNumber)
+		}
+		seen[partNumber] = true
+	}
+	return partNumbers, nil
+}
+
+// sorted_Parts checks the parts a client completes an upload with and
+// orders them by number.
+func sorted_Parts(parts []UploadPart) ([]UploadPart, error) {
+
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("%w: no parts given", ErrInvalidParts)
+	}
+	sorted := append([]UploadPart(nil), parts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].PartNumber < sorted[j].PartNumber })
+
+	for i, part := range sorted {
+		if part.PartNumber < 1 || part.PartNumber > maxUploadParts || part.ETag == "" {
+			return nil, fmt.Errorf("%w: part %v needs a number between 1 and %v and an etag", ErrInvalidParts, part.PartNumber, maxUploadParts)
+		}
+		if i > 0 && sorted[i-1].PartNumber == part.PartNumber {
+			return nil, fmt.Errorf("%w: part %v is listed twice", ErrInvalidParts, part.PartNumber)
+		}
+	}
+	return sorted, nil
+}
+
+// video_UploadRecord records a step of the upload of video idx.
+func (app *App) video_UploadRecord(sessionId string, idx int, uploadId string, status string, err error) {
+	app.record(RecordUpload, sessionId, "videoUpload", []int{idx}, err, map[string]string{
+		"uploadId":  uploadId,
+		"status":    status,
+		"objectKey": video_MediaKey(sessionId, idx),
+	})
+}
+
+// start_VideoUpload starts the multipart upload of video idx to the key the
+// video transcription reads.
+func (app *App) start_VideoUpload(sessionId string, idx int, policy PresignPolicy) (VideoUpload, error) {
+
+	storage, err := app.multipart_Storage()
+	if err != nil {
+		return VideoUpload{}, err
+	}
+
+	objectKey := video_MediaKey(sessionId, idx)
+	uploadId, err := storage.CreateMultipartUpload(context.TODO(), objectKey, policy.ContentType)
+	app.video_UploadRecord(sessionId, idx, uploadId, "started", err)
+	if err != nil {
+		return VideoUpload{}, err
+	}
+
+	return VideoUpload{Index: idx, Key: objectKey, UploadId: uploadId}, nil
+}
+
+// presign_VideoParts presigns the PUT of every part in partNumbers.
+func (app *App) presign_VideoParts(sessionId string, idx int, uploadId string, partNumbers []int32, policy PresignPolicy) (VideoUpload, error) {
+
+	storage, err := app.multipart_Storage()
+	if err != nil {
+		return VideoUpload{}, err
+	}
+
+	objectKey := video_MediaKey(sessionId, idx)
+	upload := VideoUpload{Index: idx, Key: objectKey, UploadId: uploadId}
+	for _, partNumber := range partNumbers {
+		presignedUrl, err := storage.PresignUploadPart(context.TODO(), objectKey, uploadId, partNumber, policy.LifetimeSecs)
+		if err != nil {
+			return VideoUpload{}, err
+		}
+		upload.Urls = append(upload.Urls, PartUrl{PartNumber: partNumber, Url: presignedUrl})
+	}
+	return upload, nil
+}
+
+// list_VideoParts returns what a client resuming the upload already sent.
+func (app *App) list_VideoParts(sessionId string, idx int, uploadId string) (VideoUpload, error) {
+
+	storage, err := app.multipart_Storage()
+	if err != nil {
+		return VideoUpload{}, err
+	}
+
+	objectKey := video_MediaKey(sessionId, idx)
+	parts, err := storage.ListUploadedParts(context.TODO(), objectKey, uploadId)
+	if err != nil {
+		return VideoUpload{}, err
+	}
+	return VideoUpload{Index: idx, Key: objectKey, UploadId: uploadId, Parts: parts}, nil
+}
+
+func (app *App) complete_VideoUpload(sessionId string, idx int, uploadId string, parts []UploadPart) (VideoUpload, error) {
+
+	storage, err := app.multipart_Storage()
+	if err != nil {
+		return VideoUpload{}, err
+	}
+	parts, err = sorted_Parts(parts)
+	if err != nil {
+		return VideoUpload{}, err
+	}
+
+	objectKey := video_MediaKey(sessionId, idx)
+	err = storage.CompleteMultipartUpload(context.TODO(), objectKey, uploadId, parts)
+	app.video_UploadRecord(sessionId, idx, uploadId, "completed", err)
+	if err != nil {
+		return VideoUpload{}, err
+	}
+	return VideoUpload{Index: idx, Key: objectKey, UploadId: uploadId, Parts: parts}, nil
+}
+
+func (app *App) abort_VideoUpload(sessionId string, idx int, uploadId string) (VideoUpload, error) {
+
+	storage, err := app.multipart_Storage()
+	if err != nil {
+		return VideoUpload{}, err
+	}
+
+	objectKey := video_MediaKey(sessionId, idx)
+	err = storage.AbortMultipartUpload(context.TODO(), objectKey, uploadId)
+	app.video_UploadRecord(sessionId, idx, uploadId, "aborted", err)
+	if err != nil {
+		return VideoUpload{}, err
+	}
+	return VideoUpload{Index: idx, Key: objectKey, UploadId: uploadId}, nil
+}
+
+// upload_Status is the HTTP status answering a failed multipart upload step.
+func upload_Status(err error) int {
+	switch {
+	case errors.Is(err, ErrUploadNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidParts):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrMultipartUnsupported):
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// TextNormalization says what comparing a transcript with its reference
+// ignores. Transcribe runs with ko-KR, where spacing, punctuation and how
+// numbers are written differ between people far more than what was said.
+type TextNormalization struct {
+	// IgnoreSpacing re-spaces the transcript like its reference before
+	// counting words, so 띄어쓰기 alone is never a word error.
+	IgnoreSpacing bool `json:"ignoreSpacing"`
+	// StripPunctuation drops punctuation and symbols.
+	StripPunctuation bool `json:"stripPunctuation"`
+	// UnifyNumerals reads Arabic numerals as Sino-Korean ones, so "2024년"
+	// and "이천이십사년" are the same words.
+	UnifyNumerals bool `json:"unifyNumerals"`
+	// Jamo counts character errors per jamo rather than per syllable, so
+	// 갔 for 같 is one error in three instead of a whole wrong character.
+	Jamo bool `json:"jamo"`
+}
+
+// default_TextNormalization is used when a request doesn't bring its own.
+func default_TextNormalization() TextNormalization {
+	return TextNormalization{IgnoreSpacing: true, StripPunctuation: true, UnifyNumerals: true}
+}
+
+// maxAlignCells bounds the table align_Path keeps in memory.
+const maxAlignCells = 1 << 24
+
+// numeralPattern matches Arabic numerals, with thousands separators and a
+// decimal part.
+var numeralPattern = regexp.MustCompile(`[0-9]+(?:,[0-9]{3})*(?:\.[0-9]+)?`)
+
+var (
+	sinoDigits  = []string{"영", "일", "이", "삼", "사", "오", "육", "칠", "팔", "구"}
+	sinoUnits   = []string{"", "십", "백", "천"}
+	sinoGroups  = []string{"", "만", "억", "조", "경"}
+	phoneDigits = []string{"공", "일", "이", "삼", "사", "오", "육", "칠", "팔", "구"}
+)
+
+// sino_Korean reads a run of digits the way it is said: 35 as 삼십오 and
+// 10000 as 만. Runs with a leading zero, like phone numbers, and runs too
+// long for 경 are read digit by digit.
+func sino_Korean(digits string) string {
+
+	var reading strings.Builder
+	if (len(digits) > 1 && digits[0] == '0') || len(digits) > 4*len(sinoGroups) {
+		for _, digit := range digits {
+			reading.WriteString(phoneDigits[digit-'0'])
+		}
+		return reading.String()
+	}
+
+	groupCount := (len(digits) + 3) / 4
+	for group := groupCount - 1; group >= 0; group-- {
+		end := len(digits) - 4*group
+		start := end - 4
+		if start < 0 {
+			start = 0
+		}
+
+		var groupReading strings.Builder
+		for i := start; i < end; i++ {
+			digit := digits[i] - '0'
+			place := end - 1 - i
+			if digit == 0 {
+				continue
+			}
+			// 십, 백, 천 앞의 일은 읽지 않는다
+			if digit != 1 || place == 0 {
+				groupReading.WriteString(sinoDigits[digit])
+			}
+			groupReading.WriteString(sinoUnits[place])
+		}
+		if groupReading.Len() == 0 {
+			continue
+		}
+		// 만은 일만이 아니라 만이지만 억부터는 일억이다
+		if group == 1 && groupReading.String() == "일" {
+			groupReading.Reset()
+		}
+		reading.WriteString(groupReading.String())
+		reading.WriteString(sinoGroups[group])
+	}
+	if reading.Len() == 0 {
+		return sinoDigits[0]
+	}
+	return reading.String()
+}
+
+// read_Numeral reads a match of numeralPattern; the decimal part is read
+// digit by digit after 점.
+func read_Numeral(numeral string) string {
+
+	whole, fraction, hasFraction := strings.Cut(strings.ReplaceAll(numeral, ",", ""), ".")
+	reading := sino_Korean(whole)
+	if hasFraction {
+		reading += "점"
+		for _, digit := range fraction {
+			reading += sinoDigits[digit-'0']
+		}
+	}
+	return reading
+}
+
+// normalize_Words applies normalization to text and splits it into words.
+func normalize_Words(text string, normalization TextNormalization) []string {
+
+	text = strings.ToLower(text)
+	if normalization.UnifyNumerals {
+		text = numeralPattern.ReplaceAllStringFunc(text, read_Numeral)
+	}
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return unicode.IsSpace(r) || (normalization.StripPunctuation && (unicode.IsPunct(r) || unicode.IsSymbol(r)))
+	})
+}
+
+// hangul_Jamo splits precomposed Hangul syllables into their conjoining
+// jamo and leaves every other character alone.
+func hangul_Jamo(chars []rune) []rune {
+
+	jamo := make([]rune, 0, len(chars)*3)
+	for _, char := range chars {
+		if char < 0xAC00 || char > 0xD7A3 {
+			jamo = append(jamo, char)
+			continue
+		}
+		syllable := char - 0xAC00
+		jamo = append(jamo, 0x1100+syllable/588, 0x1161+syllable%588/28)
+		if final := syllable % 28; final != 0 {
+			jamo = append(jamo, 0x11A7+final)
+		}
+	}
+	return jamo
+}
+
+// respace_Words splits the characters of hypothesis where the reference
+// has its word boundaries. A character the hypothesis adds joins the word
+// of the reference character before it.
+func respace_Words(reference []string, hypothesis []string) []string {
+
+	var referenceChars []rune
+	var owners []int
+	for word, text := range reference {
+		for _, char := range text {
+			referenceChars = append(referenceChars, char)
+			owners = append(owners, word)
+		}
+	}
+	hypothesisChars := []rune(strings.Join(hypothesis, ""))
+	if len(reference) == 0 || (len(referenceChars)+1)*(len(hypothesisChars)+1) > maxAlignCells {
+		return hypothesis
+	}
+
+	parts := make([][]rune, len(reference))
+	word := 0
+	for _, op := range align_Path(referenceChars, hypothesisChars) {
+		if op.Ref >= 0 {
+			word = owners[op.Ref]
+		}
+		if op.Hyp >= 0 {
+			parts[word] = append(parts[word], hypothesisChars[op.Hyp])
+		}
+	}
+
+	respaced := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if len(part) > 0 {
+			respaced = append(respaced, string(part))
+		}
+	}
+	return respaced
+}
+
+// EditOp kinds.
+const (
+	EditHit          = "hit"
+	EditSubstitution = "substitution"
+	EditInsertion    = "insertion"
+	EditDeletion     = "deletion"
+)
+
+// EditOp is one step of an alignment: the reference token Ref became the
+// hypothesis token Hyp. Ref is -1 for an insertion and Hyp for a deletion.
+type EditOp struct {
+	Kind string `json:"kind"`
+	Ref  int    `json:"ref"`
+	Hyp  int    `json:"hyp"`
+}
+
+// align_Path is align with the steps that get there, in order. It keeps the
+// whole table, so callers bound the sizes with maxAlignCells.
+func align_Path[T comparable](reference []T, hypothesis []T) []EditOp {
+
+	width := len(hypothesis) + 1
+	costs := make([]int32, (len(reference)+1)*width)
+	for j := 0; j < width; j++ {
+		costs[j] = int32(j)
+	}
+	for i := 1; i <= len(reference); i++ {
+		costs[i*width] = int32(i)
+		for j := 1; j < width; j++ {
+			best := costs[(i-1)*width+j-1]
+			if reference[i-1] != hypothesis[j-1] {
+				best++
+			}
+			if deletion := costs[(i-1)*width+j] + 1; deletion < best {
+				best = deletion
+			}
+			if insertion := costs[i*width+j-1] + 1; insertion < best {
+				best = insertion
+			}
+			costs[i*width+j] = best
+		}
+	}
+
+	// 끝에서부터 거꾸로 따라가며 align 과 같은 순서로 고른다
+	var path []EditOp
+	i, j := len(reference), len(hypothesis)
+	for i > 0 || j > 0 {
+		cost := costs[i*width+j]
+		switch {
+		case i > 0 && j > 0 && reference[i-1] == hypothesis[j-1] && cost == costs[(i-1)*width+j-1]:
+			path = append(path, EditOp{Kind: EditHit, Ref: i - 1, Hyp: j - 1})
+			i, j = i-1, j-1
+		case i > 0 && j > 0 && cost == costs[(i-1)*width+j-1]+1:
+			path = append(path, EditOp{Kind: EditSubstitution, Ref: i - 1, Hyp: j - 1})
+			i, j = i-1, j-1
+		case i > 0 && cost == costs[(i-1)*width+j]+1:
+			path = append(path, EditOp{Kind: EditDeletion, Ref: i - 1, Hyp: -1})
+			i--
+		default:
+			path = append(path, EditOp{Kind: EditInsertion, Ref: -1, Hyp: j - 1})
+			j--
+		}
+	}
+	for left, right := 0, len(path)-1; left < right; left, right = left+1, right-1 {
+		path[left], path[right] = path[right], path[left]
+	}
+	return path
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrRunNotFound is returned for an unknown pipeline run id.
+var ErrRunNotFound = errors.New("pipeline run not found")
+
+// Pipeline stages, in order. An item waits in a stage until that stage's
+// output is in storage (for stt, until both transcription jobs finish),
+// then the next stage's action runs and the item moves on.
+const (
+	StageEnhance  = "enhance"
+	StageAnalyze  = "analyze"
+	StageEqualize = "equalize"
+	StageStt      = "stt"
+	StageDone     = "done"
+	StageFailed   = "failed"
+)
+
+// Pipeline run statuses.
+const (
+	RunStatusRunning   = "running"
+	RunStatusCompleted = "completed"
+	RunStatusFailed    = "failed"
+)
+
+// PipelineItem is one audio file moving through the pipeline.
+// Urls holds the presigned URLs the worker of the current stage needs;
+// once the item is done it holds links to the two transcripts. A new item
+// has no stage until the first sweep hands out its enhance URLs.
+type PipelineItem struct {
+	Index     int               `json:"index"`
+	Stage     string            `json:"stage"`
+	Urls      map[string]string `json:"urls,omitempty"`
+	Jobs      map[string]string `json:"jobs,omitempty"`
+	Error     string            `json:"error,omitempty"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+	// Audio has the WAV header of each stage output seen so far, and
+	// AudioChanges where the enhancers didn't keep the original's layout.
+	Audio        map[string]AudioMetadata `json:"audio,omitempty"`
+	AudioChanges []string                 `json:"audioChanges,omitempty"`
+}
+
+// PipelineRun tracks Count items of one session through
+// enhance → analyze → equalize → stt.
+type PipelineRun struct {
+	RunId     string         `json:"runId"`
+	SessionId string         `json:"sessionId"`
+	Status    string         `json:"status"`
+	Stages    map[string]int `json:"stages"`
+	Items     []PipelineItem `json:"items"`
+	CreatedAt time.Time      `json:"createdAt"`
+	UpdatedAt time.Time      `json:"updatedAt"`
+}
+
+// Pipelines owns every pipeline run and advances them in the background.
+type Pipelines struct {
+	app *App
+
+	mutex sync.Mutex
+	runs  map[string]*pipelineEntry
+}
+
+// pipelineEntry guards run with mutex for reads and short updates. Only
+// the background loop advances runs, one sweep at a time.
+type pipelineEntry struct {
+	mutex sync.Mutex
+	run   PipelineRun
+}
+
+// new_Pipelines restores the runs kept in app.Store; unfinished ones
+// continue from the stage they were in.
+func new_Pipelines(app *App) *Pipelines {
+
+	pipelines := &Pipelines{app: app, runs: map[string]*pipelineEntry{}}
+	for _, run := range app.Store.list_StoredRuns() {
+		pipelines.runs[run.RunId] = &pipelineEntry{run: copy_Run(run)}
+	}
+	return pipelines
+}
+
+// maxPipelineItems bounds the Count of /startPipeline; keep the max of
+// NeedPipeline.Count in step.
+const maxPipelineItems = 1000
+
+// start_Run creates and stores a run for items 0..count-1. The background
+// loop hands out the enhance URLs on its next sweep, so a large run doesn't
+// keep the request waiting on storage.
+func (pipelines *Pipelines) start_Run(sessionId string, count int) (PipelineRun, error) {
+
+	runId, err := random_Id()
+	if err != nil {
+		return PipelineRun{}, err
+	}
+
+	now := time.Now().UTC()
+	entry := &pipelineEntry{run: PipelineRun{
+		RunId:     runId,
+		SessionId: sessionId,
+		Status:    RunStatusRunning,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}}
+	for i := 0; i < count; i++ {
+		entry.run.Items = append(entry.run.Items, PipelineItem{Index: i, UpdatedAt: now})
+	}
+
+	pipelines.mutex.Lock()
+	pipelines.runs[runId] = entry
+	pipelines.mutex.Unlock()
+	pipelines.app.Store.save_Run(copy_Run(entry.run))
+
+	log.Printf("Pipeline run %v started for %v items", runId, count)
+
+	return copy_Run(entry.run), nil
+}
+
+func (pipelines *Pipelines) get_Run(runId string) (PipelineRun, error) {
+
+	pipelines.mutex.Lock()
+	entry, ok := pipelines.runs[runId]
+	pipelines.mutex.Unlock()
+	if !ok {
+		return PipelineRun{}, ErrRunNotFound
+	}
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+
+	return copy_Run(entry.run), nil
+}
+
+// advance_All moves every running run forward by at most one stage per item.
+func (pipelines *Pipelines) advance_All() {
+
+	pipelines.mutex.Lock()
+	var entries []*pipelineEntry
+	for _, entry := range pipelines.runs {
+		entries = append(entries, entry)
+	}
+	pipelines.mutex.Unlock()
+
+	for _, entry := range entries {
+		pipelines.advance(entry)
+	}
+}
+
+// run calls advance_All every interval until ctx is done.
+func (pipelines *Pipelines) run(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			pipelines.advance_All()
+		}
+	}
+}
+
+// advance moves every item of entry's run forward by at most one stage.
+// Storage and Transcribe are called without holding entry.mutex, so
+// get_Run answers while a sweep is under way; each item is applied as soon
+// as it has advanced.
+func (pipelines *Pipelines) advance(entry *pipelineEntry) PipelineRun {
+
+	entry.mutex.Lock()
+	run := copy_Run(entry.run)
+	entry.mutex.Unlock()
+
+	if run.Status != RunStatusRunning {
+		return run
+	}
+	before := copy_Run(run)
+
+	for i := range run.Items {
+		item := &run.Items[i]
+		if item.Stage == StageDone || item.Stage == StageFailed {
+			continue
+		}
+
+		stage := item.Stage
+		err := pipelines.app.advance_PipelineItem(run.SessionId, item)
+		if err != nil {
+			// 일시적인 오류는 기록만 하고 다음 주기에 다시 시도
+			log.Printf("Pipeline run %v item %v (%v) not advanced. Here's why: %v\n", run.RunId, item.Index, stage, err)
+			item.Error = err.Error()
+		}
+		if item.Stage != stage {
+			item.UpdatedAt = time.Now().UTC()
+			run.UpdatedAt = item.UpdatedAt
+		}
+
+		entry.mutex.Lock()
+		entry.run.Items[i] = *item
+		entry.run.UpdatedAt = run.UpdatedAt
+		entry.mutex.Unlock()
+
+		if item.Stage != stage {
+			log.Printf("Pipeline run %v item %v: %v -> %v", run.RunId, item.Index, stage, item.Stage)
+			pipelines.app.Events.publish(Event{
+				Type:      EventStage,
+				SessionId: run.SessionId,
+				RunId:     run.RunId,
+				Indices:   []int{item.Index},
+				Status:    item.Stage,
+				Error:     item.Error,
+			})
+		}
+	}
+
+	update_RunStatus(&run)
+
+	entry.mutex.Lock()
+	entry.run = copy_Run(run)
+	entry.mutex.Unlock()
+
+	if !reflect.DeepEqual(before, run) {
+		pipelines.app.Store.save_Run(copy_Run(run))
+	}
+	return run
+}
+
+// advance_PipelineItem runs at most one stage transition for item.
+// A returned error is transient; failures that cannot be retried move the
+// item to StageFailed instead.
+func (app *App) advance_PipelineItem(sessionId string, item *PipelineItem) error {
+
+	ctx := context.TODO()
+	idx := item.Index
+
+	switch item.Stage {
+	case "":
+		urls, err := app.presign_Enhance(sessionId, idx, 0, app.Config.PresignPolicies["enhance"])
+		app.record(RecordPresign, sessionId, "presignEnhance", []int{idx}, err, nil)
+		if invalid_Input(err) {
+			item.fail(err.Error())
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := app.inspect_ItemAudio(ctx, sessionId, item, "original"); err != nil {
+			return err
+		}
+		item.enter(StageEnhance, map[string]string{"input": urls.Input, "output": urls.Output})
+
+	case StageEnhance:
+		ready, err := app.outputs_Exist(sessionId, "enhance", idx, ".wav")
+		if err != nil || !ready {
+			return err
+		}
+		urls, err := app.presign_Analyze(sessionId, idx, 0, app.Config.PresignPolicies["analyze"])
+		app.record(RecordPresign, sessionId, "presignAnalyze", []int{idx}, err, nil)
+		if invalid_Input(err) {
+			item.fail(err.Error())
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := app.inspect_ItemAudio(ctx, sessionId, item, "enhance"); err != nil {
+			return err
+		}
+		item.enter(StageAnalyze, map[string]string{
+			"originalurl":       urls.OriginalUrl,
+			"originalouputjson": urls.OriginalOutputJson,
+			"inputurl":          urls.InputUrl,
+			"outputjson":        urls.OutputJson,
+		})
+
+	case StageAnalyze:
+		ready, err := app.outputs_Exist(sessionId, "analyze", idx, "_origin.json", ".json")
+		if err != nil || !ready {
+			return err
+		}
+		urls, err := app.presign_Equalize(sessionId, idx, 0, app.Config.PresignPolicies["equalize"])
+		app.record(RecordPresign, sessionId, "presignEqualize", []int{idx}, err, nil)
+		if invalid_Input(err) {
+			item.fail(err.Error())
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		item.enter(StageEqualize, map[string]string{"input": urls.Input, "output": urls.Output})
+
+	case StageEqualize:
+		ready, err := app.outputs_Exist(sessionId, "equalize", idx, ".wav")
+		if err != nil || !ready {
+			return err
+		}
+		err = app.inspect_ItemAudio(ctx, sessionId, item, "equalize")
+		if invalid_Input(err) {
+			item.fail(err.Error())
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		item.enter(StageStt, nil)
+		item.Jobs = map[string]string{}
+		for _, isOriginal := range []bool{true, false} {
+			// start_Job 은 equalize 의 최신 시도를 받아쓴다
+			job := stt_TrackedJob(sessionId, idx, isOriginal, "")
+			err := app.start_TrackedJob(job)
+			if errors.Is(err, ErrLimitExceeded) {
+				// 한도에 걸린 작업은 poller 가 자리가 나면 시작한다
+				app.Poller.queue(job)
+				item.Jobs[stt_Label(isOriginal)] = JobStatusPending
+				continue
+			}
+			if err != nil {
+				// 먼저 시작한 작업은 poller 가 끝까지 추적한다
+				item.fail(fmt.Sprintf("couldn't start transcription %v: %v", job.JobName, err))
+				return nil
+			}
+			item.Jobs[stt_Label(isOriginal)] = JobStatusInProgress
+		}
+
+	case StageStt:
+		// 작업은 poller 가 폴링하고 정리한다. poller 가 모르는 작업은
+		// 이전 버전이 시작한 것이니 직접 조회하고 정리한다.
+		completed := 0
+		var untracked []bool
+		for _, isOriginal := range []bool{true, false} {
+			jobName := stt_JobName(sessionId, idx, isOriginal)
+			if job, ok := app.Poller.get_Job(jobName); ok {
+				item.Jobs[stt_Label(isOriginal)] = job.Status
+				if job.Status == JobStatusFailed {
+					item.fail(fmt.Sprintf("transcription %v failed: %v", jobName, job.Error))
+					return nil
+				}
+				if job.Finalized {
+					completed++
+				}
+				continue
+			}
+
+			job, err := app.Transcriber.GetJob(ctx, jobName)
+			if err != nil {
+				return err
+			}
+			item.Jobs[stt_Label(isOriginal)] = job.Status
+
+			switch job.Status {
+			case JobStatusFailed:
+				item.fail(fmt.Sprintf("transcription %v failed: %v", job.Name, job.FailureReason))
+				return nil
+			case JobStatusCompleted:
+				completed++
+				untracked = append(untracked, isOriginal)
+			}
+		}
+		if completed < 2 {
+			return nil
+		}
+
+		for _, isOriginal := range untracked {
+			err := app.Transcriber.DeleteJob(ctx, stt_JobName(sessionId, idx, isOriginal))
+			if err != nil && !errors.Is(err, ErrJobNotFound) {
+				return err
+			}
+			err = app.cleanup_TranscribeData(sessionId, idx, stt_ObjectKey(sessionId, idx, isOriginal))
+			if err != nil {
+				return err
+			}
+		}
+
+		urls := map[string]string{}
+		for _, isOriginal := range []bool{true, false} {
+			url, err := app.presign_Get(stt_ObjectKey(sessionId, idx, isOriginal)+".txt", defaultPresignLifetimeSecs)
+			if err != nil {
+				return err
+			}
+			urls[stt_Label(isOriginal)] = url
+		}
+		item.enter(StageDone, urls)
+	}
+
+	return nil
+}
+
+// inspect_ItemAudio keeps the WAV header of stage's output on item.
+func (app *App) inspect_ItemAudio(ctx context.Context, sessionId string, item *PipelineItem, stage string) error {
+
+	metadata, err := app.inspect_Audio(ctx, sessionId, stage, item.Index)
+	if err != nil {
+		return err
+	}
+	if item.Audio == nil {
+		item.Audio = map[string]AudioMetadata{}
+	}
+	item.Audio[stage] = metadata
+	item.AudioChanges = audio_Changes(item.Audio)
+	return nil
+}
+
+// invalid_Input reports whether err is an *InputError for an input that is
+// there but broken. A missing input may still be uploaded, so that is retried.
+func invalid_Input(err error) bool {
+	var inputErr *InputError
+	return errors.As(err, &inputErr) && inputErr.Problem == InputInvalid
+}
+
+// outputs_Exist reports whether some attempt of item idx wrote folder's
+// output with every one of suffixes. Like presign_* and start_Job, it counts
+// retries, not only attempt 0.
+func (app *App) outputs_Exist(sessionId string, folder string, idx int, suffixes ...string) (bool, error) {
+	for _, suffix := range suffixes {
+		_, found, err := app.latest_Attempt(sessionId, folder, idx, suffix)
+		if err != nil || !found {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func stt_Label(isOriginal bool) string {
+	if isOriginal {
+		return "original"
+	}
+	return "equalized"
+}
+
+func (item *PipelineItem) enter(stage string, urls map[string]string) {
+	item.Stage = stage
+	item.Urls = urls
+	item.Error = ""
+}
+
+func (item *PipelineItem) fail(reason string) {
+	item.Stage = StageFailed
+	item.Urls = nil
+	item.Error = reason
+}
+
+func update_RunStatus(run *PipelineRun) {
+
+	run.Stages = map[string]int{}
+	for _, item := range run.Items {
+		run.Stages[item.Stage]++
+	}
+
+	finished := run.Stages[StageDone] + run.Stages[StageFailed]
+	switch {
+	case finished < len(run.Items):
+		run.Status = RunStatusRunning
+	case run.Stages[StageFailed] > 0:
+		run.Status = RunStatusFailed
+	default:
+		run.Status = RunStatusCompleted
+	}
+}
+
+// copy_Run returns a copy of run that shares no maps or slices with it.
+func copy_Run(run PipelineRun) PipelineRun {
+
+	runCopy := run
+	runCopy.Stages = map[string]int{}
+	for stage, count := range run.Stages {
+		runCopy.Stages[stage] = count
+	}
+	runCopy.Items = make([]PipelineItem, len(run.Items))
+	for i, item := range run.Items {
+		runCopy.Items[i] = item
+		runCopy.Items[i].Urls = copy_StringMap(item.Urls)
+		runCopy.Items[i].Jobs = copy_StringMap(item.Jobs)
+		if item.Audio != nil {
+			runCopy.Items[i].Audio = make(map[string]AudioMetadata, len(item.Audio))
+			for stage, metadata := range item.Audio {
+				runCopy.Items[i].Audio[stage] = metadata
+			}
+		}
+	}
+	return runCopy
+}
+
+func copy_StringMap(values map[string]string) map[string]string {
+	if values == nil {
+		return nil
+	}
+	valuesCopy := make(map[string]string, len(values))
+	for key, value := range values {
+		valuesCopy[key] = value
+	}
+	return valuesCopy
+}
+
+// list_Runs returns the runs of sessionId, newest first.
+func (pipelines *Pipelines) list_Runs(sessionId string) []PipelineRun {
+
+	pipelines.mutex.Lock()
+	var entries []*pipelineEntry
+	for _, entry := range pipelines.runs {
+		entries = append(entries, entry)
+	}
+	pipelines.mutex.Unlock()
+
+	runs := []PipelineRun{}
+	for _, entry := range entries {
+		entry.mutex.Lock()
+		if entry.run.SessionId == sessionId {
+			runs = append(runs, copy_Run(entry.run))
+		}
+		entry.mutex.Unlock()
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].CreatedAt.After(runs[j].CreatedAt) })
+
+	return runs
+}
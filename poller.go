@@ -0,0 +1,390 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TrackedJob is a transcription job started through /startStt or
+// /startVideoStt that the server watches until it is finished.
+// ObjectKey is the transcript key without extension, as passed to cleanup.
+type TrackedJob struct {
+	JobName     string            `json:"jobName"`
+	SessionId   string            `json:"sessionId,omitempty"`
+	Index       int               `json:"index"`
+	IsOriginal  bool              `json:"isOriginal,omitempty"`
+	IsVideo     bool              `json:"isVideo,omitempty"`
+	ObjectKey   string            `json:"objectKey"`
+	CallbackUrl string            `json:"callbackUrl,omitempty"`
+	Status      string            `json:"status"`
+	Finalized   bool              `json:"finalized"`
+	Error       string            `json:"error,omitempty"`
+	Polls       int               `json:"polls"`
+	Urls        map[string]string `json:"urls,omitempty"`
+	StartedAt   time.Time         `json:"startedAt"`
+	CompletedAt *time.Time        `json:"completedAt,omitempty"`
+
+	nextPoll time.Time
+	// generation tells a job apart from a later one tracked under its name.
+	generation uint64
+}
+
+// JobStatusPending is the status of a job the server queued because the
+// provider refused to start it (ErrLimitExceeded). The poller keeps trying
+// to start it; unlike Transcribe's own QUEUED it was never accepted.
+const JobStatusPending = "PENDING"
+
+// finished reports whether the poller is done with job.
+func (job *TrackedJob) finished() bool {
+	return job.Finalized || job.Status == JobStatusFailed
+}
+
+// JobPoller polls tracked Transcribe jobs in the background so clients no
+// longer have to call /getStt in a loop. A job that is still running is
+// polled again after a backoff that doubles from MinBackoff up to MaxBackoff;
+// at most Concurrency jobs are polled at once. A completed job is deleted and
+// finalized with the same cleanup /cleanUpSTT and /cleanUpVideoSTT run.
+type JobPoller struct {
+	app *App
+
+	Concurrency int
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+
+	mutex       sync.Mutex
+	jobs        map[string]*TrackedJob
+	generations uint64
+}
+
+// new_JobPoller restores the unfinished jobs kept in app.Store.
+func new_JobPoller(app *App, concurrency int, minBackoff time.Duration) *JobPoller {
+
+	poller := &JobPoller{
+		app:         app,
+		Concurrency: concurrency,
+		MinBackoff:  minBackoff,
+		MaxBackoff:  2 * time.Minute,
+		jobs:        map[string]*TrackedJob{},
+	}
+	for _, job := range app.Store.list_StoredJobs() {
+		job := job
+		poller.jobs[job.JobName] = &job
+	}
+	return poller
+}
+
+// track starts watching a job that was just started.
+func (poller *JobPoller) track(job TrackedJob) {
+	job.Status = JobStatusInProgress
+	poller.add(job)
+}
+
+// queue starts watching a job the provider couldn't start yet; the poller
+// starts it once the provider accepts it.
+func (poller *JobPoller) queue(job TrackedJob) {
+	job.Status = JobStatusPending
+	poller.add(job)
+}
+
+func (poller *JobPoller) add(job TrackedJob) {
+
+	job.StartedAt = time.Now().UTC()
+	job.nextPoll = job.StartedAt.Add(poller.MinBackoff)
+
+	poller.mutex.Lock()
+	poller.generations++
+	job.generation = poller.generations
+	poller.jobs[job.JobName] = &job
+	poller.mutex.Unlock()
+
+	poller.app.Store.save_Job(job)
+}
+
+func (poller *JobPoller) get_Job(jobName string) (TrackedJob, bool) {
+	poller.mutex.Lock()
+	defer poller.mutex.Unlock()
+
+	job, ok := poller.jobs[jobName]
+	if !ok {
+		return TrackedJob{}, false
+	}
+	return *job, true
+}
+
+// list_Jobs returns the tracked jobs of sessionId ordered by index.
+func (poller *JobPoller) list_Jobs(sessionId string) []TrackedJob {
+	poller.mutex.Lock()
+	defer poller.mutex.Unlock()
+
+	jobs := []TrackedJob{}
+	for _, job := range poller.jobs {
+		if job.SessionId == sessionId {
+			jobs = append(jobs, *job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if jobs[i].Index != jobs[j].Index {
+			return jobs[i].Index < jobs[j].Index
+		}
+		return jobs[i].JobName < jobs[j].JobName
+	})
+	return jobs
+}
+
+// settled_Job returns jobName when the poller knows its status better than
+// the provider: it is finished (and maybe already deleted there) or still
+// waiting in the server's queue.
+func (poller *JobPoller) settled_Job(jobName string) (TrackedJob, bool) {
+	job, ok := poller.get_Job(jobName)
+	return job, ok && (job.finished() || job.Status == JobStatusPending)
+}
+
+// finalized_Job reports whether the poller already finalized jobName, so
+// /cleanUpSTT must not clean it up a second time.
+func (poller *JobPoller) finalized_Job(jobName string) bool {
+	job, ok := poller.get_Job(jobName)
+	return ok && job.Finalized
+}
+
+// run polls due jobs every interval until ctx is done.
+func (poller *JobPoller) run(ctx context.Context, interval time.Duration) {
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			poller.poll_Due(now)
+		}
+	}
+}
+
+// poll_Due polls every unfinished job whose backoff has passed at now and
+// waits for all of them.
+func (poller *JobPoller) poll_Due(now time.Time) {
+
+	poller.mutex.Lock()
+	var due []TrackedJob
+	for _, job := range poller.jobs {
+		if !job.finished() && !job.nextPoll.After(now) {
+			due = append(due, *job)
+		}
+	}
+	poller.mutex.Unlock()
+
+	concurrency := poller.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	slots := make(chan struct{}, concurrency)
+
+	var waitPoll sync.WaitGroup
+	waitPoll.Add(len(due))
+
+	for _, job := range due {
+		slots <- struct{}{}
+		go func(job TrackedJob) {
+			defer waitPoll.Done() //끝나면 .Done() 호출
+			defer func() { <-slots }()
+
+			poller.poll(&job)
+
+			// 폴링하는 사이 같은 이름으로 다시 시작된 작업은 덮어쓰지 않는다
+			poller.mutex.Lock()
+			current, ok := poller.jobs[job.JobName]
+			replaced := ok && current.generation != job.generation
+			if !replaced {
+				poller.jobs[job.JobName] = &job
+			}
+			poller.mutex.Unlock()
+
+			if !replaced {
+				poller.app.Store.save_Job(job)
+			}
+		}(job)
+	}
+
+	waitPoll.Wait()
+}
+
+// poll checks job once and finalizes it when Transcribe is done with it.
+func (poller *JobPoller) poll(job *TrackedJob) {
+
+	ctx := context.TODO()
+
+	if job.Status == JobStatusPending {
+		err := poller.app.start_Job(*job)
+		if errors.Is(err, ErrLimitExceeded) {
+			poller.back_Off(job)
+			return
+		}
+		if err != nil {
+			job.Error = err.Error()
+			poller.set_Status(job, JobStatusFailed)
+			poller.app.notify_TrackedJob(*job, WebhookFailed)
+			return
+		}
+		log.Printf("Queued transcription job %v started", job.JobName)
+		job.StartedAt = time.Now().UTC()
+		poller.set_Status(job, JobStatusInProgress)
+		poller.back_Off(job)
+		return
+	}
+
+	job.Polls++
+
+	// 정리에 실패했던 작업은 Transcribe 에서 이미 지워졌을 수 있으니 다시 조회하지 않는다
+	if job.Status != JobStatusCompleted {
+		transcription, err := poller.app.Transcriber.GetJob(ctx, job.JobName)
+		if errors.Is(err, ErrJobNotFound) {
+			poller.job_Gone(job, err)
+			return
+		}
+		if err != nil {
+			log.Printf("Couldn't poll transcription job %v. Here's why: %v\n", job.JobName, err)
+			job.Error = err.Error()
+			poller.back_Off(job)
+			return
+		}
+
+		job.Error = transcription.FailureReason
+		poller.set_Status(job, transcription.Status)
+
+		switch transcription.Status {
+		case JobStatusFailed:
+			poller.app.record(RecordTranscription, job.SessionId, job.JobName, []int{job.Index},
+				errors.New(transcription.FailureReason), map[string]string{"status": JobStatusFailed})
+			poller.app.notify_TrackedJob(*job, WebhookFailed)
+			return
+		case JobStatusCompleted:
+			poller.app.record(RecordTranscription, job.SessionId, job.JobName, []int{job.Index},
+				nil, map[string]string{"status": JobStatusCompleted})
+			poller.app.notify_TrackedJob(*job, WebhookCompleted)
+		default:
+			poller.back_Off(job)
+			return
+		}
+	}
+
+	err := poller.app.finalize_TrackedJob(*job)
+	if err != nil {
+		log.Printf("Couldn't finalize transcription job %v. Here's why: %v\n", job.JobName, err)
+		job.Error = err.Error()
+		poller.back_Off(job)
+		return
+	}
+
+	completedAt := time.Now().UTC()
+	job.Finalized = true
+	job.Error = ""
+	job.CompletedAt = &completedAt
+	log.Printf("Transcription job %v finalized", job.JobName)
+	poller.app.notify_TrackedJob(*job, WebhookArtifacts)
+}
+
+// job_Gone settles a job Transcribe no longer knows. When the client
+// already finalized it with /cleanUpSTT the transcript is there and the job
+// succeeded; otherwise it is lost.
+func (poller *JobPoller) job_Gone(job *TrackedJob, err error) {
+
+	exists, existsErr := object_Exists(context.TODO(), poller.app.Storage, job.ObjectKey+".txt")
+	if existsErr != nil {
+		log.Printf("Couldn't check transcript of job %v. Here's why: %v\n", job.JobName, existsErr)
+		job.Error = existsErr.Error()
+		poller.back_Off(job)
+		return
+	}
+	if !exists {
+		log.Printf("Tracked transcription job %v is gone, stop polling", job.JobName)
+		job.Error = err.Error()
+		poller.set_Status(job, JobStatusFailed)
+		poller.app.notify_TrackedJob(*job, WebhookFailed)
+		return
+	}
+
+	// 클라이언트가 /cleanUpSTT 로 먼저 정리한 경우
+	completedAt := time.Now().UTC()
+	job.Error = ""
+	poller.set_Status(job, JobStatusCompleted)
+	poller.app.record(RecordTranscription, job.SessionId, job.JobName, []int{job.Index},
+		nil, map[string]string{"status": JobStatusCompleted})
+	poller.app.notify_TrackedJob(*job, WebhookCompleted)
+	job.Finalized = true
+	job.CompletedAt = &completedAt
+	log.Printf("Transcription job %v was finalized by the client", job.JobName)
+	poller.app.notify_TrackedJob(*job, WebhookArtifacts)
+}
+
+// set_Status updates job.Status and sends a status event when it changed.
+func (poller *JobPoller) set_Status(job *TrackedJob, status string) {
+
+	if job.Status == status {
+		return
+	}
+	job.Status = status
+	poller.app.Events.publish(Event{
+		Type:      EventStatus,
+		SessionId: job.SessionId,
+		Name:      job.JobName,
+		Indices:   []int{job.Index},
+		Status:    job.Status,
+		Error:     job.Error,
+	})
+}
+
+func (poller *JobPoller) back_Off(job *TrackedJob) {
+
+	backoff := poller.MinBackoff
+	for i := 1; i < job.Polls && backoff < poller.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > poller.MaxBackoff {
+		backoff = poller.MaxBackoff
+	}
+	job.nextPoll = time.Now().Add(backoff)
+}
+
+// finalize_TrackedJob deletes the finished Transcribe job and writes the transcript text.
+func (app *App) finalize_TrackedJob(job TrackedJob) error {
+
+	err := app.Transcriber.DeleteJob(context.TODO(), job.JobName)
+	if err != nil && !errors.Is(err, ErrJobNotFound) {
+		return err
+	}
+
+	if job.IsVideo {
+		return app.cleanup_VideoTranscribeData(job.SessionId, job.Index, job.ObjectKey)
+	}
+	return app.cleanup_TranscribeData(job.SessionId, job.Index, job.ObjectKey)
+}
+
+// tracked_JobResult adds download links for the finished transcript to job.
+func (app *App) tracked_JobResult(job TrackedJob) (TrackedJob, error) {
+
+	if !job.Finalized {
+		return job, nil
+	}
+
+	job.Urls = map[string]string{}
+	url, err := app.presign_Get(job.ObjectKey+".txt", defaultPresignLifetimeSecs)
+	if err != nil {
+		return job, err
+	}
+	job.Urls["transcript"] = url
+
+	if job.IsVideo {
+		url, err = app.presign_Get(job.ObjectKey+"_ko.txt", defaultPresignLifetimeSecs)
+		if err != nil {
+			return job, err
+		}
+		job.Urls["translation"] = url
+	}
+	return job, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// presignStages are the stages whose workers get presigned URLs.
+// The video stage only sets the lifetime and Content-Type of multipart uploads.
+var presignStages = []string{"enhance", "analyze", "equalize", "video"}
+
+const (
+	// S3 은 7일보다 오래 유효한 presigned URL 을 만들지 않는다
+	maxPresignLifetimeSecs = 60 * 60 * 24 * 7
+
+	defaultPresignMaxLifetimeSecs = 60 * 60 * 12
+)
+
+// default_PresignPolicies are the stage policies used when no
+// PRESIGN_<STAGE>_* setting overrides them.
+func default_PresignPolicies() map[string]PresignPolicy {
+	return map[string]PresignPolicy{
+		"enhance":  {LifetimeSecs: defaultPresignLifetimeSecs, ContentType: "audio/wav", MaxBytes: 512 << 20},
+		"analyze":  {LifetimeSecs: defaultPresignLifetimeSecs, ContentType: "application/json", MaxBytes: 16 << 20},
+		"equalize": {LifetimeSecs: defaultPresignLifetimeSecs, ContentType: "audio/wav", MaxBytes: 512 << 20},
+		"video":    {LifetimeSecs: defaultPresignLifetimeSecs, ContentType: "video/mp4"},
+	}
+}
+
+// load_PresignPolicies reads PRESIGN_<STAGE>_LIFETIME_SECONDS,
+// PRESIGN_<STAGE>_CONTENT_TYPE and PRESIGN_<STAGE>_MAX_BYTES (0 for no
+// limit) over the defaults. No lifetime may exceed maxLifetimeSecs.
+func load_PresignPolicies(maxLifetimeSecs int64) (map[string]PresignPolicy, error) {
+
+	policies := default_PresignPolicies()
+	for _, stage := range presignStages {
+		policy := policies[stage]
+		prefix := "PRESIGN_" + strings.ToUpper(stage) + "_"
+
+		var err error
+		policy.LifetimeSecs, err = strconv.ParseInt(getenv_Default(prefix+"LIFETIME_SECONDS", strconv.FormatInt(policy.LifetimeSecs, 10)), 10, 64)
+		if err != nil || policy.LifetimeSecs <= 0 || policy.LifetimeSecs > maxLifetimeSecs {
+			return nil, fmt.Errorf("%vLIFETIME_SECONDS must be a number of seconds between 1 and %v", prefix, maxLifetimeSecs)
+		}
+		policy.MaxBytes, err = strconv.ParseInt(getenv_Default(prefix+"MAX_BYTES", strconv.FormatInt(policy.MaxBytes, 10)), 10, 64)
+		if err != nil || policy.MaxBytes < 0 {
+			return nil, fmt.Errorf("%vMAX_BYTES must be a number of bytes, 0 for no limit", prefix)
+		}
+		policy.ContentType = getenv_Default(prefix+"CONTENT_TYPE", policy.ContentType)
+
+		policies[stage] = policy
+	}
+	return policies, nil
+}
+
+// presign_Policy returns the presign policy of stage, narrowed by a request:
+// lifetimeSecs may go up to PRESIGN_MAX_LIFETIME_SECONDS and maxBytes up to
+// the stage's own limit. 0 keeps the stage setting.
+func (app *App) presign_Policy(stage string, lifetimeSecs int64, maxBytes int64) (PresignPolicy, error) {
+
+	policy := app.Config.PresignPolicies[stage]
+
+	if lifetimeSecs < 0 || lifetimeSecs > app.Config.PresignMaxLifetimeSecs {
+		return policy, fmt.Errorf("lifetimeSeconds must be between 1 and %v", app.Config.PresignMaxLifetimeSecs)
+	}
+	if lifetimeSecs > 0 {
+		policy.LifetimeSecs = lifetimeSecs
+	}
+
+	if maxBytes < 0 || (policy.MaxBytes > 0 && maxBytes > policy.MaxBytes) {
+		return policy, fmt.Errorf("maxBytes must be between 1 and %v", policy.MaxBytes)
+	}
+	if maxBytes > 0 {
+		policy.MaxBytes = maxBytes
+	}
+	return policy, nil
+}
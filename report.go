@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxReportIndices bounds /exportReport like /scoreTranscripts.
+const maxReportIndices = maxScoreIndices
+
+// Report formats.
+const (
+	ReportXlsx = "xlsx"
+	ReportCsv  = "csv"
+)
+
+var reportFormats = []string{ReportXlsx, ReportCsv}
+
+// reportColumns are the headings of a report. report_Row fills them in the
+// same order.
+var reportColumns = report_Columns()
+
+func report_Columns() []string {
+
+	columns := []string{"index", "item"}
+	for _, stage := range audioStages {
+		columns = append(columns, stage+" attempt", stage+" sample rate", stage+" channels", stage+" duration (s)")
+	}
+	columns = append(columns, "audio changes", "reference")
+	for _, isOriginal := range []bool{true, false} {
+		label := stt_Label(isOriginal)
+		columns = append(columns, label+" transcript", label+" WER", label+" CER",
+			label+" job status", label+" job started", label+" job completed")
+	}
+	columns = append(columns, "WER delta", "CER delta")
+	for _, stage := range audioStages {
+		columns = append(columns, stage+" audio url")
+	}
+	columns = append(columns, "reference url", stt_Label(true)+" transcript url", stt_Label(false)+" transcript url", "errors")
+	return columns
+}
+
+// report_Key is where the report reportId of a session is stored.
+func report_Key(sessionId string, reportId string, format string) string {
+	return session_Key(sessionId, "reports/"+reportId+"."+format)
+}
+
+// report_Row is one report row for item idx. Artifacts that don't exist yet
+// leave their cells empty and say why under errors.
+func (app *App) report_Row(ctx context.Context, sessionId string, idx int, references map[int]string, score ItemScore, lifetimeSecs int64) ([]interface{}, error) {
+
+	row := []interface{}{idx, idx + 1}
+	var urls []interface{}
+	var problems []string
+
+	link := func(objectKey string) (interface{}, error) {
+		url, err := app.presign_Get(objectKey, lifetimeSecs)
+		if err != nil {
+			return nil, err
+		}
+		return url, nil
+	}
+
+	audio := app.create_AudioInfo(sessionId, idx)
+	for _, stage := range audioStages {
+		metadata, ok := audio.Stages[stage]
+		if !ok {
+			row = append(row, nil, nil, nil, nil)
+			urls = append(urls, nil)
+			problems = append(problems, audio.Errors[stage].Error())
+			continue
+		}
+		row = append(row, metadata.Attempt, int(metadata.SampleRate), int(metadata.Channels), metadata.DurationSecs)
+		url, err := link(metadata.Key)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, url)
+	}
+	row = append(row, strings.Join(audio.Changes, "; "))
+
+	if reference, ok := references[idx]; ok {
+		url, err := link(reference_Key(sessionId, idx))
+		if err != nil {
+			return nil, err
+		}
+		row = append(row, reference)
+		urls = append(urls, url)
+	} else {
+		row = append(row, nil)
+		urls = append(urls, nil)
+	}
+
+	for _, isOriginal := range []bool{true, false} {
+		objectKey := stt_ObjectKey(sessionId, idx, isOriginal) + ".txt"
+
+		var transcript, url interface{}
+		text, err := app.read_SttText(ctx, objectKey)
+		var inputErr *InputError
+		if errors.As(err, &inputErr) {
+			problems = append(problems, inputErr.Error())
+		} else if err != nil {
+			return nil, err
+		} else {
+			transcript = text
+			if url, err = link(objectKey); err != nil {
+				return nil, err
+			}
+		}
+		urls = append(urls, url)
+
+		var wer, cer interface{}
+		transcriptScore := score.Original
+		if !isOriginal {
+			transcriptScore = score.Equalized
+		}
+		if transcriptScore != nil {
+			wer, cer = transcriptScore.Wer, transcriptScore.Cer
+		}
+
+		var status, started, completed interface{}
+		if job, ok := app.Poller.get_Job(stt_JobName(sessionId, idx, isOriginal)); ok {
+			status, started = job.Status, job.StartedAt.Format(time.RFC3339)
+			if job.CompletedAt != nil {
+				completed = job.CompletedAt.Format(time.RFC3339)
+			}
+			if job.Error != "" {
+				problems = append(problems, job.JobName+": "+job.Error)
+			}
+		}
+
+		row = append(row, transcript, wer, cer, status, started, completed)
+	}
+
+	if score.Delta != nil {
+		row = append(row, score.Delta.Wer, score.Delta.Cer)
+	} else {
+		row = append(row, nil, nil)
+	}
+
+	row = append(row, urls...)
+	return append(row, strings.Join(problems, "; ")), nil
+}
+
+func write_Csv(w io.Writer, rows [][]interface{}) error {
+
+	// BOM 이 없으면 엑셀이 한글을 깨뜨려 연다
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	for _, row := range rows {
+		record := make([]string, len(row))
+		for i, value := range row {
+			record[i] = cell_Text(value)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// create_Report writes a report of indices in every format of formats, one
+// row per item, and presigns a GET for each file. Transcripts are scored
+// against the references imported by /uploadExcel.
+func (app *App) create_Report(sessionId string, indices []int, formats []string, normalization TextNormalization, lifetimeSecs int64) (ExportedReport, error) {
+
+	ctx := context.TODO()
+	reportId, err := random_Id()
+	if err != nil {
+		return ExportedReport{}, err
+	}
+
+	indices = append([]int(nil), indices...)
+	sort.Ints(indices)
+
+	references, err := app.load_References(sessionId, indices)
+	if err != nil {
+		return ExportedReport{}, err
+	}
+	scores, err := app.create_TranscriptScores(ctx, sessionId, indices, references, normalization)
+	if err != nil {
+		return ExportedReport{}, err
+	}
+
+	columns := make([]interface{}, len(reportColumns))
+	for i, column := range reportColumns {
+		columns[i] = column
+	}
+	rows := [][]interface{}{columns}
+	for _, idx := range indices {
+		row, err := app.report_Row(ctx, sessionId, idx, references, scores.Items[idx], lifetimeSecs)
+		if err != nil {
+			return ExportedReport{}, err
+		}
+		rows = append(rows, row)
+	}
+
+	report := ExportedReport{ReportId: reportId, Count: len(indices), Columns: reportColumns, Files: map[string]ReportFile{}}
+	for _, format := range formats {
+		var buf bytes.Buffer
+		switch format {
+		case ReportXlsx:
+			err = write_Xlsx(&buf, "Report", rows)
+		case ReportCsv:
+			err = write_Csv(&buf, rows)
+		default:
+			err = fmt.Errorf("unknown report format %q", format)
+		}
+		if err != nil {
+			return ExportedReport{}, err
+		}
+
+		file := ReportFile{Key: report_Key(sessionId, reportId, format)}
+		if err := app.Storage.PutObject(ctx, file.Key, &buf); err != nil {
+			log.Printf("Couldn't upload file %v. Here's why: %v\n", file.Key, err)
+			return ExportedReport{}, err
+		}
+		if file.Url, err = app.presign_Get(file.Key, lifetimeSecs); err != nil {
+			return ExportedReport{}, err
+		}
+		report.Files[format] = file
+	}
+
+	app.record(RecordExport, sessionId, "exportReport", indices, nil, map[string]string{"reportId": reportId, "formats": strings.Join(formats, ",")})
+	return report, nil
+}
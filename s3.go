@@ -2,22 +2,29 @@ package main
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
-	"os"
+	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/tidwall/gjson"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
 )
 
 // Presigner encapsulates the Amazon Simple Storage Service (Amazon S3) presign actions
@@ -46,13 +53,18 @@ func (presigner Presigner) GetObject(
 }
 
 // PutObject makes a presigned request that can be used to put an object in a bucket.
-// The presigned request is valid for the specified number of seconds.
+// The presigned request is valid for the specified number of seconds. A contentType
+// is signed, so the upload has to send it.
 func (presigner Presigner) PutObject(
-	bucketName string, objectKey string, lifetimeSecs int64) (*v4.PresignedHTTPRequest, error) {
-	request, err := presigner.PresignClient.PresignPutObject(context.TODO(), &s3.PutObjectInput{
+	bucketName string, objectKey string, contentType string, lifetimeSecs int64) (*v4.PresignedHTTPRequest, error) {
+	input := &s3.PutObjectInput{
 		Bucket: aws.String(bucketName),
 		Key:    aws.String(objectKey),
-	}, func(opts *s3.PresignOptions) {
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	request, err := presigner.PresignClient.PresignPutObject(context.TODO(), input, func(opts *s3.PresignOptions) {
 		opts.Expires = time.Duration(lifetimeSecs * int64(time.Second))
 	})
 	if err != nil {
@@ -62,6 +74,25 @@ func (presigner Presigner) PutObject(
 	return request, err
 }
 
+// UploadPart makes a presigned request that can be used to put one part of a
+// multipart upload. The presigned request is valid for the specified number of seconds.
+func (presigner Presigner) UploadPart(
+	bucketName string, objectKey string, uploadId string, partNumber int32, lifetimeSecs int64) (*v4.PresignedHTTPRequest, error) {
+	request, err := presigner.PresignClient.PresignUploadPart(context.TODO(), &s3.UploadPartInput{
+		Bucket:     aws.String(bucketName),
+		Key:        aws.String(objectKey),
+		UploadId:   aws.String(uploadId),
+		PartNumber: partNumber,
+	}, func(opts *s3.PresignOptions) {
+		opts.Expires = time.Duration(lifetimeSecs * int64(time.Second))
+	})
+	if err != nil {
+		log.Printf("Couldn't get a presigned request to upload part %v of %v:%v. Here's why: %v\n",
+			partNumber, bucketName, objectKey, err)
+	}
+	return request, err
+}
+
 // DeleteObject makes a presigned request that can be used to delete an object from a bucket.
 func (presigner Presigner) DeleteObject(bucketName string, objectKey string) (*v4.PresignedHTTPRequest, error) {
 	request, err := presigner.PresignClient.PresignDeleteObject(context.TODO(), &s3.DeleteObjectInput{
@@ -74,473 +105,735 @@ func (presigner Presigner) DeleteObject(bucketName string, objectKey string) (*v
 	return request, err
 }
 
-func s3client_init() {
+// S3Storage is the Storage backed by an Amazon S3 bucket.
+// Credentials and Region sign the POST policies of size limited uploads.
+type S3Storage struct {
+	Client      *s3.Client
+	Presigner   Presigner
+	BucketName  string
+	Region      string
+	Credentials aws.CredentialsProvider
+}
 
-	err := godotenv.Load()
+func (storage *S3Storage) GetObject(ctx context.Context, objectKey string) (io.ReadCloser, error) {
+	result, err := storage.Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(storage.BucketName),
+		Key:    aws.String(objectKey),
+	})
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrObjectNotFound
+		}
+		return nil, err
 	}
+	return result.Body, nil
+}
+
+// PutObject uploads body. The SDK signs the payload, so body should be seekable
+// (a file, strings.Reader or bytes.Reader).
+func (storage *S3Storage) PutObject(ctx context.Context, objectKey string, body io.Reader) error {
+	_, err := storage.Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(storage.BucketName),
+		Key:    aws.String(objectKey),
+		Body:   body,
+	})
+	return err
+}
 
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
-	)
+func (storage *S3Storage) HeadObject(ctx context.Context, objectKey string) (ObjectInfo, error) {
+	result, err := storage.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(storage.BucketName),
+		Key:    aws.String(objectKey),
+	})
 	if err != nil {
-		log.Fatal(err)
-	}
+		var notFound *types.NotFound
+		if errors.As(err, &notFound) {
+			return ObjectInfo{}, ErrObjectNotFound
+		}
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{
+		Key:          objectKey,
+		Size:         result.ContentLength,
+		LastModified: aws.ToTime(result.LastModified),
+		ContentType:  aws.ToString(result.ContentType),
+	}, nil
+}
+
+func (storage *S3Storage) DeleteObject(ctx context.Context, objectKey string) error {
+	_, err := storage.Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(storage.BucketName),
+		Key:    aws.String(objectKey),
+	})
+	return err
+}
 
-	// Create an Amazon S3 service client
-	client := s3.NewFromConfig(cfg)
-	//presignClient := s3.NewPresignClient(client)
-	//presigner := Presigner{PresignClient: presignClient}
+func (storage *S3Storage) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
 
-	// Get the first page of results for ListObjectsV2 for a bucket
-	output, err := client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: aws.String(os.Getenv("S3_BUCKET_NAME")),
+	paginator := s3.NewListObjectsV2Paginator(storage.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(storage.BucketName),
+		Prefix: aws.String(prefix),
 	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range output.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.ToString(object.Key),
+				Size:         object.Size,
+				LastModified: aws.ToTime(object.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (storage *S3Storage) PresignGetObject(ctx context.Context, objectKey string, lifetimeSecs int64) (string, error) {
+	request, err := storage.Presigner.GetObject(storage.BucketName, objectKey, lifetimeSecs)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
+	return request.URL, nil
+}
 
-	log.Println("first page results:")
-	for _, object := range output.Contents {
-		log.Printf("key=%s size=%d", aws.ToString(object.Key), object.Size)
+// PresignUpload returns a presigned PUT, or a POST policy when policy limits
+// the size: S3 can't enforce the size of a presigned PUT.
+func (storage *S3Storage) PresignUpload(ctx context.Context, objectKey string, policy PresignPolicy) (PresignedUpload, error) {
+	if policy.MaxBytes > 0 {
+		return storage.presign_Post(ctx, objectKey, policy)
+	}
+	request, err := storage.Presigner.PutObject(storage.BucketName, objectKey, policy.ContentType, policy.LifetimeSecs)
+	if err != nil {
+		return PresignedUpload{}, err
 	}
+	return put_Upload(request.URL, policy), nil
 }
 
-func create_PreSignEnhance(num int) PreSignEnhance {
+// presign_Post signs a POST policy (Signature Version 4) that only accepts
+// objectKey with policy's Content-Type and at most policy.MaxBytes bytes.
+func (storage *S3Storage) presign_Post(ctx context.Context, objectKey string, policy PresignPolicy) (PresignedUpload, error) {
 
-	err := godotenv.Load()
+	credentials, err := storage.Credentials.Retrieve(ctx)
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Printf("Couldn't get credentials to presign POST %v. Here's why: %v\n", objectKey, err)
+		return PresignedUpload{}, err
+	}
+
+	now := time.Now().UTC()
+	date := now.Format("20060102")
+	fields := map[string]string{
+		"key":              objectKey,
+		"x-amz-algorithm":  "AWS4-HMAC-SHA256",
+		"x-amz-credential": credentials.AccessKeyID + "/" + date + "/" + storage.Region + "/s3/aws4_request",
+		"x-amz-date":       now.Format("20060102T150405Z"),
+	}
+	if credentials.SessionToken != "" {
+		fields["x-amz-security-token"] = credentials.SessionToken
+	}
+	if policy.ContentType != "" {
+		fields["Content-Type"] = policy.ContentType
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	conditions := []interface{}{
+		map[string]string{"bucket": storage.BucketName},
+		[]interface{}{"content-length-range", 0, policy.MaxBytes},
+	}
+	for _, name := range names {
+		conditions = append(conditions, map[string]string{name: fields[name]})
 	}
 
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
-	)
+	document, err := json.Marshal(map[string]interface{}{
+		"expiration": now.Add(time.Duration(policy.LifetimeSecs) * time.Second).Format("2006-01-02T15:04:05.000Z"),
+		"conditions": conditions,
+	})
 	if err != nil {
-		log.Fatal(err)
+		return PresignedUpload{}, err
 	}
+	encoded := base64.StdEncoding.EncodeToString(document)
 
-	// Create an Amazon S3 service client
-	client := s3.NewFromConfig(cfg)
-	presignClient := s3.NewPresignClient(client)
-	presigner := Presigner{PresignClient: presignClient}
+	// 서명 키: AWS4+secret → 날짜 → 리전 → 서비스 → aws4_request
+	key := []byte("AWS4" + credentials.SecretAccessKey)
+	for _, part := range []string{date, storage.Region, "s3", "aws4_request", encoded} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	fields["policy"] = encoded
+	fields["x-amz-signature"] = hex.EncodeToString(key)
 
-	var urls []EnhanceUrls
+	return PresignedUpload{
+		Method: http.MethodPost,
+		Url:    "https://" + storage.BucketName + ".s3." + storage.Region + ".amazonaws.com/",
+		Fields: fields,
+	}, nil
+}
 
-	for i := 0; i < num; i++ {
+func (storage *S3Storage) CreateMultipartUpload(ctx context.Context, objectKey string, contentType string) (string, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(storage.BucketName),
+		Key:    aws.String(objectKey),
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+	output, err := storage.Client.CreateMultipartUpload(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(output.UploadId), nil
+}
 
-		log.Printf("Let's presign a request to Get Presigned the object.")
-		presignedGetRequest, err := presigner.GetObject(os.Getenv("S3_BUCKET_NAME"), "original/"+strconv.Itoa(i+1)+".wav", 60*30)
-		if err != nil {
-			panic(err)
-		}
-		log.Printf("Got a presigned %v presignedGetRequest to URL:\n\t%v\n", presignedGetRequest.Method, presignedGetRequest.URL)
+func (storage *S3Storage) PresignUploadPart(ctx context.Context, objectKey string, uploadId string, partNumber int32, lifetimeSecs int64) (string, error) {
+	request, err := storage.Presigner.UploadPart(storage.BucketName, objectKey, uploadId, partNumber, lifetimeSecs)
+	if err != nil {
+		return "", err
+	}
+	return request.URL, nil
+}
+
+func (storage *S3Storage) ListUploadedParts(ctx context.Context, objectKey string, uploadId string) ([]UploadPart, error) {
+	parts := []UploadPart{}
 
-		log.Printf("Let's presign a request to Put Presigned the object.")
-		presignedPutRequest, err := presigner.PutObject(os.Getenv("S3_BUCKET_NAME"), "enhance/"+strconv.Itoa(i+1)+".wav", 60*30)
+	paginator := s3.NewListPartsPaginator(storage.Client, &s3.ListPartsInput{
+		Bucket:   aws.String(storage.BucketName),
+		Key:      aws.String(objectKey),
+		UploadId: aws.String(uploadId),
+	})
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
 		if err != nil {
-			panic(err)
+			return nil, s3_UploadError(err)
 		}
-		log.Printf("Got a presigned %v presignedPutRequest to URL:\n\t%v\n", presignedPutRequest.Method, presignedPutRequest.URL)
+		for _, part := range output.Parts {
+			parts = append(parts, UploadPart{PartNumber: part.PartNumber, ETag: aws.ToString(part.ETag), Size: part.Size})
+		}
+	}
+	return parts, nil
+}
 
-		url := EnhanceUrls{presignedGetRequest.URL, presignedPutRequest.URL}
-		urls = append(urls, url)
+func (storage *S3Storage) CompleteMultipartUpload(ctx context.Context, objectKey string, uploadId string, parts []UploadPart) error {
+	completed := make([]types.CompletedPart, 0, len(parts))
+	for _, part := range parts {
+		completed = append(completed, types.CompletedPart{PartNumber: part.PartNumber, ETag: aws.String(part.ETag)})
 	}
+	_, err := storage.Client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(storage.BucketName),
+		Key:             aws.String(objectKey),
+		UploadId:        aws.String(uploadId),
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: completed},
+	})
+	return s3_UploadError(err)
+}
 
-	presignenhance := PreSignEnhance{Count: num, Urls: urls}
+func (storage *S3Storage) AbortMultipartUpload(ctx context.Context, objectKey string, uploadId string) error {
+	_, err := storage.Client.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(storage.BucketName),
+		Key:      aws.String(objectKey),
+		UploadId: aws.String(uploadId),
+	})
+	return s3_UploadError(err)
+}
 
-	return presignenhance
+// s3_UploadError maps the S3 errors of a multipart upload to ErrUploadNotFound
+// and ErrInvalidParts.
+func s3_UploadError(err error) error {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch apiErr.ErrorCode() {
+	case "NoSuchUpload":
+		return fmt.Errorf("%w: %v", ErrUploadNotFound, err)
+	case "InvalidPart", "InvalidPartOrder", "EntityTooSmall":
+		return fmt.Errorf("%w: %v", ErrInvalidParts, err)
+	}
+	return err
 }
 
-func create_PreSignAnalyze(num int) PreSignAnalyze {
+func (app *App) s3client_init() {
 
-	err := godotenv.Load()
+	objects, err := app.Storage.ListObjects(context.TODO(), "")
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatal(err)
 	}
 
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
-	)
+	log.Println("first page results:")
+	for _, object := range objects {
+		log.Printf("key=%s size=%d", object.Key, object.Size)
+	}
+}
+
+// stage_Key returns the key of item idx (0 based, stored as idx+1) in a stage folder,
+// e.g. stage_Key("", "enhance", 0, ".wav") is "enhance/1.wav".
+func stage_Key(sessionId string, folder string, idx int, suffix string) string {
+	return session_Key(sessionId, folder+"/"+strconv.Itoa(idx+1)+suffix)
+}
+
+// defaultPresignLifetimeSecs is how long URLs outside the stage presign
+// policies, such as transcripts and analysis results, stay valid.
+const defaultPresignLifetimeSecs = 60 * 30
+
+func (app *App) presign_Get(objectKey string, lifetimeSecs int64) (string, error) {
+
+	log.Printf("Let's presign a request to Get Presigned the object.")
+	presignedGetUrl, err := app.Storage.PresignGetObject(context.TODO(), objectKey, lifetimeSecs)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Couldn't presign GET %v. Here's why: %v\n", objectKey, err)
+		return "", err
 	}
+	log.Printf("Got a presigned GET URL:\n\t%v\n", presignedGetUrl)
 
-	// Create an Amazon S3 service client
-	client := s3.NewFromConfig(cfg)
-	presignClient := s3.NewPresignClient(client)
-	presigner := Presigner{PresignClient: presignClient}
+	return presignedGetUrl, nil
+}
 
-	var urls []AnalyzeUrls
+func (app *App) presign_Upload(objectKey string, policy PresignPolicy) (PresignedUpload, error) {
 
-	for i := 0; i < num; i++ {
+	log.Printf("Let's presign a request to upload the object.")
+	upload, err := app.Storage.PresignUpload(context.TODO(), objectKey, policy)
+	if err != nil {
+		log.Printf("Couldn't presign upload of %v. Here's why: %v\n", objectKey, err)
+		return PresignedUpload{}, err
+	}
+	log.Printf("Got a presigned %v URL:\n\t%v\n", upload.Method, upload.Url)
 
-		log.Printf("Let's presign a request to Get Presigned the object.")
-		originalPresignedGetRequest, err := presigner.GetObject(os.Getenv("S3_BUCKET_NAME"), "original/"+strconv.Itoa(i+1)+".wav", 60*30)
-		if err != nil {
-			panic(err)
-		}
-		log.Printf("Got a presigned %v originalPresignedGetRequest to URL:\n\t%v\n", originalPresignedGetRequest.Method, originalPresignedGetRequest.URL)
+	return upload, nil
+}
 
-		log.Printf("Let's presign a request to Put Presigned the object.")
-		originalPresignedPutRequest, err := presigner.PutObject(os.Getenv("S3_BUCKET_NAME"), "analyze/"+strconv.Itoa(i+1)+"_origin"+".json", 60*30)
-		if err != nil {
-			panic(err)
-		}
-		log.Printf("Got a presigned %v presignedPutRequest to URL:\n\t%v\n", originalPresignedPutRequest.Method, originalPresignedPutRequest.URL)
+// presign_Enhance issues the URLs the enhancer needs for attempt of item idx.
+// It returns an *InputError when the original isn't a complete WAV file.
+func (app *App) presign_Enhance(sessionId string, idx int, attempt int, policy PresignPolicy) (EnhanceUrls, error) {
 
-		log.Printf("Let's presign a request to Get Presigned the object.")
-		presignedGetRequest, err := presigner.GetObject(os.Getenv("S3_BUCKET_NAME"), "enhance/"+strconv.Itoa(i+1)+".wav", 60*30)
-		if err != nil {
-			panic(err)
-		}
-		log.Printf("Got a presigned %v presignedGetRequest to URL:\n\t%v\n", presignedGetRequest.Method, presignedGetRequest.URL)
+	originalKey := stage_Key(sessionId, "original", idx, ".wav")
+	if _, err := app.verify_Wav(context.TODO(), originalKey); err != nil {
+		return EnhanceUrls{}, err
+	}
 
-		log.Printf("Let's presign a request to Put Presigned the object.")
-		presignedPutRequest, err := presigner.PutObject(os.Getenv("S3_BUCKET_NAME"), "analyze/"+strconv.Itoa(i+1)+".json", 60*30)
-		if err != nil {
-			panic(err)
-		}
-		log.Printf("Got a presigned %v presignedPutRequest to URL:\n\t%v\n", presignedPutRequest.Method, presignedPutRequest.URL)
+	presignedGetUrl, err := app.presign_Get(originalKey, policy.LifetimeSecs)
+	if err != nil {
+		return EnhanceUrls{}, err
+	}
+	upload, err := app.presign_Upload(attempt_Key(sessionId, "enhance", idx, attempt, ".wav"), policy)
+	if err != nil {
+		return EnhanceUrls{}, err
+	}
 
-		url := AnalyzeUrls{originalPresignedGetRequest.URL, originalPresignedPutRequest.URL, presignedGetRequest.URL, presignedPutRequest.URL}
-		urls = append(urls, url)
+	return EnhanceUrls{Input: presignedGetUrl, Output: upload.Url, OutputUpload: upload, Index: idx, Attempt: attempt}, nil
+}
+
+// presign_Analyze issues the URLs the analyzer needs for attempt of item idx.
+// The enhanced input is the current attempt of the enhance stage. It returns
+// an *InputError when either input isn't a complete WAV file.
+func (app *App) presign_Analyze(sessionId string, idx int, attempt int, policy PresignPolicy) (AnalyzeUrls, error) {
+
+	enhanceKey, err := app.current_Key(sessionId, "enhance", idx, ".wav")
+	if err != nil {
+		return AnalyzeUrls{}, err
+	}
+	originalKey := stage_Key(sessionId, "original", idx, ".wav")
+	for _, inputKey := range []string{originalKey, enhanceKey} {
+		if _, err := app.verify_Wav(context.TODO(), inputKey); err != nil {
+			return AnalyzeUrls{}, err
+		}
 	}
 
-	presignanalyzes := PreSignAnalyze{Count: num, UrlJsons: urls}
+	originalPresignedGetUrl, err := app.presign_Get(originalKey, policy.LifetimeSecs)
+	if err != nil {
+		return AnalyzeUrls{}, err
+	}
+	originalUpload, err := app.presign_Upload(attempt_Key(sessionId, "analyze", idx, attempt, "_origin.json"), policy)
+	if err != nil {
+		return AnalyzeUrls{}, err
+	}
+	presignedGetUrl, err := app.presign_Get(enhanceKey, policy.LifetimeSecs)
+	if err != nil {
+		return AnalyzeUrls{}, err
+	}
+	upload, err := app.presign_Upload(attempt_Key(sessionId, "analyze", idx, attempt, ".json"), policy)
+	if err != nil {
+		return AnalyzeUrls{}, err
+	}
 
-	return presignanalyzes
+	return AnalyzeUrls{
+		OriginalUrl:          originalPresignedGetUrl,
+		OriginalOutputJson:   originalUpload.Url,
+		OriginalOutputUpload: originalUpload,
+		InputUrl:             presignedGetUrl,
+		OutputJson:           upload.Url,
+		OutputUpload:         upload,
+		Index:                idx,
+		Attempt:              attempt,
+	}, nil
 }
 
-func create_PreSignAnalyzeRetry(num int, retryCount int) PreSignAnalyze {
+// presign_Equalize issues the URLs the equalizer needs for attempt of item idx.
+// The enhanced input is the current attempt of the enhance stage. It returns
+// an *InputError when that isn't a complete WAV file.
+func (app *App) presign_Equalize(sessionId string, idx int, attempt int, policy PresignPolicy) (EqualizeUrls, error) {
 
-	err := godotenv.Load()
+	enhanceKey, err := app.current_Key(sessionId, "enhance", idx, ".wav")
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		return EqualizeUrls{}, err
+	}
+	if _, err := app.verify_Wav(context.TODO(), enhanceKey); err != nil {
+		return EqualizeUrls{}, err
 	}
 
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
-	)
+	presignedGetUrl, err := app.presign_Get(enhanceKey, policy.LifetimeSecs)
 	if err != nil {
-		log.Fatal(err)
+		return EqualizeUrls{}, err
+	}
+	upload, err := app.presign_Upload(attempt_Key(sessionId, "equalize", idx, attempt, ".wav"), policy)
+	if err != nil {
+		return EqualizeUrls{}, err
 	}
 
-	// Create an Amazon S3 service client
-	client := s3.NewFromConfig(cfg)
-	presignClient := s3.NewPresignClient(client)
-	presigner := Presigner{PresignClient: presignClient}
+	return EqualizeUrls{Input: presignedGetUrl, Output: upload.Url, OutputUpload: upload, Index: idx, Attempt: attempt}, nil
+}
 
-	var urls []AnalyzeUrls
+func (app *App) create_PreSignEnhance(sessionId string, indices []int, policy PresignPolicy) PreSignEnhance {
 
-	for i := 0; i < num; i++ {
+	var urls []EnhanceUrls
+	var presigned []int
+	inputErrors := map[int]*InputError{}
 
-		log.Printf("Let's presign a request to Get Presigned the object.")
-		originalPresignedGetRequest, err := presigner.GetObject(os.Getenv("S3_BUCKET_NAME"), "original/"+strconv.Itoa(i+1)+".wav", 60*30)
-		if err != nil {
-			panic(err)
+	for _, i := range indices {
+		url, err := app.presign_Enhance(sessionId, i, 0, policy)
+		if skip_Input(err, inputErrors, i) {
+			continue
 		}
-		log.Printf("Got a presigned %v originalPresignedGetRequest to URL:\n\t%v\n", originalPresignedGetRequest.Method, originalPresignedGetRequest.URL)
-
-		log.Printf("Let's presign a request to Put Presigned the object.")
-		originalPresignedPutRequest, err := presigner.PutObject(os.Getenv("S3_BUCKET_NAME"), "analyze/"+strconv.Itoa(i+1)+"_origin"+".json", 60*30)
 		if err != nil {
+			app.record(RecordPresign, sessionId, "presignEnhance", indices, err, nil)
 			panic(err)
 		}
-		log.Printf("Got a presigned %v presignedPutRequest to URL:\n\t%v\n", originalPresignedPutRequest.Method, originalPresignedPutRequest.URL)
+		urls = append(urls, url)
+		presigned = append(presigned, i)
+	}
+
+	presignenhance := PreSignEnhance{Count: len(indices), Policy: policy, Urls: urls, Items: enhance_Items(urls), Errors: inputErrors}
+	app.record(RecordPresign, sessionId, "presignEnhance", presigned, nil, nil)
+
+	return presignenhance
+}
+
+// create_PreSignEnhanceRetry re-issues enhance URLs for attempt retryCount.
+// With onlyFailed only the items of indices without an enhanced output get URLs.
+func (app *App) create_PreSignEnhanceRetry(sessionId string, indices []int, retryCount int, onlyFailed bool, policy PresignPolicy) PreSignEnhance {
+
+	count := len(indices)
+	if onlyFailed {
+		indices = app.failed_Indices(sessionId, indices, "enhance", ".wav")
+	}
+	detail := map[string]string{"retryCount": strconv.Itoa(retryCount)}
 
-		log.Printf("Let's presign a request to Get Presigned the object.")
-		presignedGetRequest, err := presigner.GetObject(os.Getenv("S3_BUCKET_NAME"), "enhance/"+strconv.Itoa(i+1)+".wav", 60*30)
+	urls := []EnhanceUrls{}
+	presigned := []int{}
+	inputErrors := map[int]*InputError{}
+	for _, idx := range indices {
+		url, err := app.presign_Enhance(sessionId, idx, retryCount, policy)
+		if skip_Input(err, inputErrors, idx) {
+			continue
+		}
 		if err != nil {
+			app.record(RecordPresign, sessionId, "presignEnhanceRetry", indices, err, detail)
 			panic(err)
 		}
-		log.Printf("Got a presigned %v presignedGetRequest to URL:\n\t%v\n", presignedGetRequest.Method, presignedGetRequest.URL)
+		urls = append(urls, url)
+		presigned = append(presigned, idx)
+	}
+
+	presignenhance := PreSignEnhance{Count: count, Attempt: retryCount, Policy: policy, Urls: urls, Items: enhance_Items(urls), Errors: inputErrors}
+	app.record(RecordPresign, sessionId, "presignEnhanceRetry", presigned, nil, detail)
+
+	return presignenhance
+}
+
+func (app *App) create_PreSignAnalyze(sessionId string, indices []int, policy PresignPolicy) PreSignAnalyze {
 
-		log.Printf("Let's presign a request to Put Presigned the object.")
-		presignedPutRequest, err := presigner.PutObject(os.Getenv("S3_BUCKET_NAME"), "analyze/"+strconv.Itoa(i+1)+".json", 60*30)
+	var urls []AnalyzeUrls
+	var presigned []int
+	inputErrors := map[int]*InputError{}
+
+	for _, i := range indices {
+		url, err := app.presign_Analyze(sessionId, i, 0, policy)
+		if skip_Input(err, inputErrors, i) {
+			continue
+		}
 		if err != nil {
+			app.record(RecordPresign, sessionId, "presignAnalyze", indices, err, nil)
 			panic(err)
 		}
-		log.Printf("Got a presigned %v presignedPutRequest to URL:\n\t%v\n", presignedPutRequest.Method, presignedPutRequest.URL)
-
-		url := AnalyzeUrls{originalPresignedGetRequest.URL, originalPresignedPutRequest.URL, presignedGetRequest.URL, presignedPutRequest.URL}
 		urls = append(urls, url)
+		presigned = append(presigned, i)
 	}
 
-	presignanalyzes := PreSignAnalyze{Count: num, UrlJsons: urls}
+	presignanalyzes := PreSignAnalyze{Count: len(indices), Policy: policy, UrlJsons: urls, Items: analyze_Items(urls), Errors: inputErrors}
+	app.record(RecordPresign, sessionId, "presignAnalyze", presigned, nil, nil)
 
 	return presignanalyzes
 }
 
-func create_AnalyzeJson(idx int) AnalyzeJson {
+// create_PreSignAnalyzeRetry re-issues analyze URLs for attempt retryCount.
+// With onlyFailed only the items of indices missing either analysis get URLs.
+func (app *App) create_PreSignAnalyzeRetry(sessionId string, indices []int, retryCount int, onlyFailed bool, policy PresignPolicy) PreSignAnalyze {
 
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	count := len(indices)
+	if onlyFailed {
+		indices = app.failed_Indices(sessionId, indices, "analyze", ".json", "_origin.json")
 	}
+	detail := map[string]string{"retryCount": strconv.Itoa(retryCount)}
 
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
-	)
-	if err != nil {
-		log.Fatal(err)
+	urls := []AnalyzeUrls{}
+	presigned := []int{}
+	inputErrors := map[int]*InputError{}
+	for _, idx := range indices {
+		url, err := app.presign_Analyze(sessionId, idx, retryCount, policy)
+		if skip_Input(err, inputErrors, idx) {
+			continue
+		}
+		if err != nil {
+			app.record(RecordPresign, sessionId, "presignAnalyzeRetry", indices, err, detail)
+			panic(err)
+		}
+		urls = append(urls, url)
+		presigned = append(presigned, idx)
 	}
 
-	// Create an Amazon S3 service client
-	client := s3.NewFromConfig(cfg)
-	presignClient := s3.NewPresignClient(client)
-	presigner := Presigner{PresignClient: presignClient}
+	presignanalyzes := PreSignAnalyze{Count: count, Attempt: retryCount, Policy: policy, UrlJsons: urls, Items: analyze_Items(urls), Errors: inputErrors}
+	app.record(RecordPresign, sessionId, "presignAnalyzeRetry", presigned, nil, detail)
+
+	return presignanalyzes
+}
+
+// create_AnalyzeJson returns the analyses of item idx: those of attempt
+// retryCount, or of the current attempt when retryCount is 0.
+func (app *App) create_AnalyzeJson(sessionId string, idx int, retryCount int) AnalyzeJson {
 
-	originalJsonGetRequest, err := presigner.GetObject(os.Getenv("S3_BUCKET_NAME"), "analyze/"+strconv.Itoa(idx+1)+"_origin"+".json", 60*30)
+	attempt := retryCount
+	if attempt == 0 {
+		current, _, err := app.latest_Attempt(sessionId, "analyze", idx, ".json")
+		if err != nil {
+			app.record(RecordPresign, sessionId, "getAnalyzeJson", []int{idx}, err, nil)
+			panic(err)
+		}
+		attempt = current
+	}
+	detail := map[string]string{"attempt": strconv.Itoa(attempt)}
+
+	originalJsonGetUrl, err := app.presign_Get(attempt_Key(sessionId, "analyze", idx, attempt, "_origin.json"), defaultPresignLifetimeSecs)
 	if err != nil {
+		app.record(RecordPresign, sessionId, "getAnalyzeJson", []int{idx}, err, detail)
 		panic(err)
 	}
-	log.Printf("Got a presigned %v presignedGetRequest to URL:\n\t%v\n", originalJsonGetRequest.Method, originalJsonGetRequest.URL)
 
-	log.Printf("Let's presign a request to Get Presigned the object.")
-	jsonGetRequest, err := presigner.GetObject(os.Getenv("S3_BUCKET_NAME"), "analyze/"+strconv.Itoa(idx+1)+".json", 60*30)
+	jsonGetUrl, err := app.presign_Get(attempt_Key(sessionId, "analyze", idx, attempt, ".json"), defaultPresignLifetimeSecs)
+	app.record(RecordPresign, sessionId, "getAnalyzeJson", []int{idx}, err, detail)
 	if err != nil {
 		panic(err)
 	}
-	log.Printf("Got a presigned %v presignedGetRequest to URL:\n\t%v\n", jsonGetRequest.Method, jsonGetRequest.URL)
 
-	analyzejson := AnalyzeJson{OriginalAnalyzeJsonData: originalJsonGetRequest.URL, AnalyzeJsonData: jsonGetRequest.URL}
+	analyzejson := AnalyzeJson{OriginalAnalyzeJsonData: originalJsonGetUrl, AnalyzeJsonData: jsonGetUrl, Attempt: attempt}
 
 	return analyzejson
 }
 
-func create_PreSignEqualize(num int) PreSignEqualize {
+func (app *App) create_PreSignEqualize(sessionId string, indices []int, policy PresignPolicy) PreSignEqualize {
 
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
+	var urls []EqualizeUrls
+	var presigned []int
+	inputErrors := map[int]*InputError{}
 
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
-	)
-	if err != nil {
-		log.Fatal(err)
+	for _, i := range indices {
+		url, err := app.presign_Equalize(sessionId, i, 0, policy)
+		if skip_Input(err, inputErrors, i) {
+			continue
+		}
+		if err != nil {
+			app.record(RecordPresign, sessionId, "presignEqualize", indices, err, nil)
+			panic(err)
+		}
+		urls = append(urls, url)
+		presigned = append(presigned, i)
 	}
 
-	// Create an Amazon S3 service client
-	client := s3.NewFromConfig(cfg)
-	presignClient := s3.NewPresignClient(client)
-	presigner := Presigner{PresignClient: presignClient}
+	presignequalize := PreSignEqualize{Count: len(indices), Policy: policy, Urls: urls, Items: equalize_Items(urls), Errors: inputErrors}
+	app.record(RecordPresign, sessionId, "presignEqualize", presigned, nil, nil)
 
-	var urls []EqualizeUrls
+	return presignequalize
+}
 
-	for i := 0; i < num; i++ {
+// create_PreSignEqualizeRetry re-issues equalize URLs for attempt retryCount.
+// With onlyFailed only the items of indices without an equalized output get URLs.
+func (app *App) create_PreSignEqualizeRetry(sessionId string, indices []int, retryCount int, onlyFailed bool, policy PresignPolicy) PreSignEqualize {
 
-		log.Printf("Let's presign a request to Get Presigned the object.")
-		presignedGetRequest, err := presigner.GetObject(os.Getenv("S3_BUCKET_NAME"), "enhance/"+strconv.Itoa(i+1)+".wav", 60*30)
-		if err != nil {
-			panic(err)
-		}
-		log.Printf("Got a presigned %v presignedGetRequest to URL:\n\t%v\n", presignedGetRequest.Method, presignedGetRequest.URL)
+	count := len(indices)
+	if onlyFailed {
+		indices = app.failed_Indices(sessionId, indices, "equalize", ".wav")
+	}
+	detail := map[string]string{"retryCount": strconv.Itoa(retryCount)}
 
-		log.Printf("Let's presign a request to Put Presigned the object.")
-		presignedPutRequest, err := presigner.PutObject(os.Getenv("S3_BUCKET_NAME"), "equalize/"+strconv.Itoa(i+1)+".wav", 60*30)
+	urls := []EqualizeUrls{}
+	presigned := []int{}
+	inputErrors := map[int]*InputError{}
+	for _, idx := range indices {
+		url, err := app.presign_Equalize(sessionId, idx, retryCount, policy)
+		if skip_Input(err, inputErrors, idx) {
+			continue
+		}
 		if err != nil {
+			app.record(RecordPresign, sessionId, "presignEqualizeRetry", indices, err, detail)
 			panic(err)
 		}
-		log.Printf("Got a presigned %v presignedPutRequest to URL:\n\t%v\n", presignedPutRequest.Method, presignedPutRequest.URL)
-
-		url := EqualizeUrls{presignedGetRequest.URL, presignedPutRequest.URL}
 		urls = append(urls, url)
+		presigned = append(presigned, idx)
 	}
 
-	presignequalize := PreSignEqualize{Count: num, Urls: urls}
+	presignequalize := PreSignEqualize{Count: count, Attempt: retryCount, Policy: policy, Urls: urls, Items: equalize_Items(urls), Errors: inputErrors}
+	app.record(RecordPresign, sessionId, "presignEqualizeRetry", presigned, nil, detail)
 
 	return presignequalize
 }
 
-func cleanup_TranscribeData(idx int, objectKey string) {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+// enhance_Items keys urls by item index.
+func enhance_Items(urls []EnhanceUrls) map[int]EnhanceUrls {
+	items := map[int]EnhanceUrls{}
+	for _, url := range urls {
+		items[url.Index] = url
 	}
+	return items
+}
 
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
-	)
-	if err != nil {
-		log.Fatal(err)
+// analyze_Items keys urls by item index.
+func analyze_Items(urls []AnalyzeUrls) map[int]AnalyzeUrls {
+	items := map[int]AnalyzeUrls{}
+	for _, url := range urls {
+		items[url.Index] = url
 	}
+	return items
+}
 
-	client := s3.NewFromConfig(cfg)
-
-	result, err := client.GetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket: aws.String(os.Getenv("S3_BUCKET_NAME")),
-		Key:    aws.String(objectKey+".json"),
-	})
-	if err != nil {
-		log.Printf("Couldn't get object %v. Here's why: %v\n", objectKey, err)
-		//return err
-	}else{
-		log.Printf("Get Object successful(%v)\n",objectKey);
+// equalize_Items keys urls by item index.
+func equalize_Items(urls []EqualizeUrls) map[int]EqualizeUrls {
+	items := map[int]EqualizeUrls{}
+	for _, url := range urls {
+		items[url.Index] = url
 	}
-	defer result.Body.Close()
+	return items
+}
 
-	body, err := io.ReadAll(result.Body)
-	if err != nil {
-		log.Printf("Couldn't read object body from %v. Here's why: %v\n", objectKey, err)
-	}
-	myString := string(body[:])
-	//log.Printf(myString)
+// cleanup_TranscribeData turns objectKey.json written by Transcribe into objectKey.txt
+// and deletes the json. The json is kept when the txt couldn't be written.
+func (app *App) cleanup_TranscribeData(sessionId string, idx int, objectKey string) error {
 
-	value := gjson.Get(myString, "results.transcripts.0.transcript")
-	//println(value.String())
+	err := app.write_TranscriptText(objectKey)
+	app.record(RecordCleanup, sessionId, objectKey, []int{idx}, err, nil)
 
-	// make $index.txt
-	var bodydata = strings.NewReader(value.String())
+	return err
+}
 
-	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(os.Getenv("S3_BUCKET_NAME")),
-		Key:    aws.String(objectKey+".txt"),
-		Body:   bodydata,
-	})
-	if err != nil {
-		log.Printf("Couldn't upload file %v. Here's why: %v\n",objectKey, err)
-	}else{
-		log.Printf("Put Object successful(%v)\n",objectKey+".txt");
-	}
+// cleanup_VideoTranscribeData is cleanup_TranscribeData for videos, which also
+// writes a Korean translation to objectKey_ko.txt.
+func (app *App) cleanup_VideoTranscribeData(sessionId string, idx int, objectKey string) error {
 
-	// delete $index.json
-	client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-		Bucket: aws.String(os.Getenv("S3_BUCKET_NAME")),
-		Key: aws.String(objectKey+".json"),
-	})
+	value, err := app.read_Transcript(objectKey)
 	if err != nil {
-		log.Printf("Couldn't delete objects from bucket %v. Here's why: %v\n", objectKey, err)
+		app.record(RecordCleanup, sessionId, objectKey, []int{idx}, err, nil)
+		return err
 	}
-}
 
-func cleanup_VideoTranscribeData(idx int, objectKey string) {
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+	ko_str, err := app.trnaslate_en_to_kr(value)
+	if err == nil {
+		err = app.put_Text(objectKey+"_ko.txt", ko_str)
 	}
-
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
-	)
+	app.record(RecordTranslation, sessionId, objectKey+"_ko.txt", []int{idx}, err, map[string]string{"source": "en", "target": "ko"})
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
-	client := s3.NewFromConfig(cfg)
+	err = app.write_TranscriptText(objectKey)
+	app.record(RecordCleanup, sessionId, objectKey, []int{idx}, err, nil)
 
-	result, err := client.GetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket: aws.String(os.Getenv("S3_BUCKET_NAME")),
-		Key:    aws.String(objectKey+".json"),
-	})
-	if err != nil {
-		log.Printf("Couldn't get object %v. Here's why: %v\n", objectKey, err)
-		//return err
-	}else{
-		log.Printf("Get Object successful(%v)\n",objectKey);
-	}
-	defer result.Body.Close()
+	return err
+}
 
-	body, err := io.ReadAll(result.Body)
+func (app *App) write_TranscriptText(objectKey string) error {
+
+	value, err := app.read_Transcript(objectKey)
 	if err != nil {
-		log.Printf("Couldn't read object body from %v. Here's why: %v\n", objectKey, err)
+		return err
 	}
-	myString := string(body[:])
-	//log.Printf(myString)
-
-	value := gjson.Get(myString, "results.transcripts.0.transcript")
-	//println(value.String())
 
 	// make $index.txt
-	var bodydata = strings.NewReader(value.String())
-
-	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(os.Getenv("S3_BUCKET_NAME")),
-		Key:    aws.String(objectKey+".txt"),
-		Body:   bodydata,
-	})
+	err = app.put_Text(objectKey+".txt", value)
 	if err != nil {
-		log.Printf("Couldn't upload file %v. Here's why: %v\n",objectKey, err)
-	}else{
-		log.Printf("Put Object successful(%v)\n",objectKey+".txt");
+		return err
 	}
 
-	ko_str := trnaslate_en_to_kr(value.String())
-	var translatedata = strings.NewReader(ko_str)
-
-	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(os.Getenv("S3_BUCKET_NAME")),
-		Key:    aws.String(objectKey+"_ko.txt"),
-		Body:   translatedata,
-	})
+	// delete $index.json
+	err = app.Storage.DeleteObject(context.TODO(), objectKey+".json")
 	if err != nil {
-		log.Printf("Couldn't upload file %v. Here's why: %v\n",objectKey, err)
-	}else{
-		log.Printf("Put Object successful(%v)\n",objectKey+"_ko.txt");
+		log.Printf("Couldn't delete objects from bucket %v. Here's why: %v\n", objectKey, err)
+		return err
 	}
+	return nil
+}
 
-	// delete $index.json
-	client.DeleteObject(context.TODO(), &s3.DeleteObjectInput{
-		Bucket: aws.String(os.Getenv("S3_BUCKET_NAME")),
-		Key: aws.String(objectKey+".json"),
-	})
+func (app *App) put_Text(objectKey string, text string) error {
+
+	err := app.Storage.PutObject(context.TODO(), objectKey, strings.NewReader(text))
 	if err != nil {
-		log.Printf("Couldn't delete objects from bucket %v. Here's why: %v\n", objectKey, err)
+		log.Printf("Couldn't upload file %v. Here's why: %v\n", objectKey, err)
+		return err
 	}
+	log.Printf("Put Object successful(%v)\n", objectKey)
+	return nil
 }
 
+// read_Transcript reads objectKey.json written by Transcribe and returns its transcript text.
+func (app *App) read_Transcript(objectKey string) (string, error) {
 
-func upload_excel(data []*multipart.FileHeader) {
-	err := godotenv.Load()
+	result, err := app.Storage.GetObject(context.TODO(), objectKey+".json")
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Printf("Couldn't get object %v. Here's why: %v\n", objectKey, err)
+		return "", err
 	}
+	log.Printf("Get Object successful(%v)\n", objectKey)
+	defer result.Close()
 
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
-	)
+	body, err := io.ReadAll(result)
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("Couldn't read object body from %v. Here's why: %v\n", objectKey, err)
+		return "", err
 	}
 
-	client := s3.NewFromConfig(cfg)
+	value := gjson.GetBytes(body, "results.transcripts.0.transcript")
+
+	return value.String(), nil
+}
+
+func (app *App) upload_excel(objectKey string, data []*multipart.FileHeader) error {
 
 	csvFileToImport, err := data[0].Open()
 	if err != nil {
-        //log.Printf("Couldn't upload file %v. Here's why: %v\n",fileName, err)
-    }
+		log.Printf("Couldn't open uploaded file %v. Here's why: %v\n", data[0].Filename, err)
+		return err
+	}
 	defer csvFileToImport.Close()
 
-	_, err = client.PutObject(context.TODO(), &s3.PutObjectInput{
-		Bucket: aws.String(os.Getenv("S3_BUCKET_NAME")),
-		Key:    aws.String(data[0].Filename),
-		Body: csvFileToImport,
-	})
+	err = app.Storage.PutObject(context.TODO(), objectKey, csvFileToImport)
 	if err != nil {
-		//log.Printf("Couldn't upload file %v. Here's why: %v\n",fileName, err)
-	}else{
-		//log.Printf("Put Object successful(%v)\n",fileName);
+		log.Printf("Couldn't upload file %v. Here's why: %v\n", objectKey, err)
+		return err
 	}
-
+	log.Printf("Put Object successful(%v)\n", objectKey)
+	return nil
 }
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+)
+
+// maxScoreIndices bounds /scoreTranscripts like /startSttBatch.
+const maxScoreIndices = maxSttBatch
+
+// maxReferenceChars bounds each reference a /scoreTranscripts request
+// brings, so that its alignments stay within maxAlignCells.
+const maxReferenceChars = 4000
+
+// ErrTooLongToScore is returned for a transcript and reference too long to
+// align in memory and time one request may take.
+var ErrTooLongToScore = errors.New("too long to score")
+
+// EditCounts is the alignment of a hypothesis against a reference of
+// Reference tokens.
+type EditCounts struct {
+	Reference     int `json:"reference"`
+	Hits          int `json:"hits"`
+	Substitutions int `json:"substitutions"`
+	Insertions    int `json:"insertions"`
+	Deletions     int `json:"deletions"`
+}
+
+// rate is the error rate: edits per reference token. An empty reference
+// scores 0 against an empty hypothesis and 1 against anything else.
+func (counts EditCounts) rate() float64 {
+	edits := counts.Substitutions + counts.Insertions + counts.Deletions
+	if counts.Reference == 0 {
+		if edits == 0 {
+			return 0
+		}
+		return 1
+	}
+	return float64(edits) / float64(counts.Reference)
+}
+
+func (counts *EditCounts) add(other EditCounts) {
+	counts.Reference += other.Reference
+	counts.Hits += other.Hits
+	counts.Substitutions += other.Substitutions
+	counts.Insertions += other.Insertions
+	counts.Deletions += other.Deletions
+}
+
+// align finds the fewest edits turning reference into hypothesis. It keeps
+// only two rows of the Levenshtein table, so long transcripts stay cheap;
+// on ties it prefers a substitution to a deletion to an insertion.
+func align[T comparable](reference []T, hypothesis []T) EditCounts {
+
+	type cell struct {
+		cost   int
+		counts EditCounts
+	}
+
+	previous := make([]cell, len(hypothesis)+1)
+	current := make([]cell, len(hypothesis)+1)
+	for j := range previous {
+		previous[j] = cell{cost: j, counts: EditCounts{Insertions: j}}
+	}
+
+	for i := 1; i <= len(reference); i++ {
+		current[0] = cell{cost: i, counts: EditCounts{Reference: i, Deletions: i}}
+		for j := 1; j <= len(hypothesis); j++ {
+			best := previous[j-1]
+			best.counts.Reference++
+			if reference[i-1] == hypothesis[j-1] {
+				best.counts.Hits++
+			} else {
+				best.cost++
+				best.counts.Substitutions++
+			}
+			if deletion := previous[j]; deletion.cost+1 < best.cost {
+				best = deletion
+				best.cost++
+				best.counts.Reference++
+				best.counts.Deletions++
+			}
+			if insertion := current[j-1]; insertion.cost+1 < best.cost {
+				best = insertion
+				best.cost++
+				best.counts.Insertions++
+			}
+			current[j] = best
+		}
+		previous, current = current, previous
+	}
+	return previous[len(hypothesis)].counts
+}
+
+// TranscriptScore compares one transcript with its reference. Characters
+// are counted without spaces, which Korean transcripts place inconsistently,
+// and are jamo when the normalization asks for it.
+type TranscriptScore struct {
+	Key   string     `json:"key"`
+	Wer   float64    `json:"wer"`
+	Cer   float64    `json:"cer"`
+	Words EditCounts `json:"words"`
+	Chars EditCounts `json:"chars"`
+}
+
+// score_Transcript returns ErrTooLongToScore rather than fill a table of
+// more than maxAlignCells; characters outnumber words, so they decide.
+func score_Transcript(reference string, hypothesis string, normalization TextNormalization) (TranscriptScore, error) {
+
+	referenceWords := normalize_Words(reference, normalization)
+	hypothesisWords := normalize_Words(hypothesis, normalization)
+	if normalization.IgnoreSpacing {
+		hypothesisWords = respace_Words(referenceWords, hypothesisWords)
+	}
+
+	referenceChars := []rune(strings.Join(referenceWords, ""))
+	hypothesisChars := []rune(strings.Join(hypothesisWords, ""))
+	if normalization.Jamo {
+		referenceChars, hypothesisChars = hangul_Jamo(referenceChars), hangul_Jamo(hypothesisChars)
+	}
+
+	if (len(referenceChars)+1)*(len(hypothesisChars)+1) > maxAlignCells {
+		return TranscriptScore{}, ErrTooLongToScore
+	}
+
+	words := align(referenceWords, hypothesisWords)
+	chars := align(referenceChars, hypothesisChars)
+	return TranscriptScore{Wer: words.rate(), Cer: chars.rate(), Words: words, Chars: chars}, nil
+}
+
+// ScoreDelta is the equalized score minus the original one; below zero
+// equalization helped recognition.
+type ScoreDelta struct {
+	Wer float64 `json:"wer"`
+	Cer float64 `json:"cer"`
+}
+
+// BatchScore averages the rates of Items transcripts and sums their counts.
+type BatchScore struct {
+	Items int        `json:"items"`
+	Wer   float64    `json:"wer"`
+	Cer   float64    `json:"cer"`
+	Words EditCounts `json:"words"`
+	Chars EditCounts `json:"chars"`
+}
+
+func (batch *BatchScore) add(score TranscriptScore) {
+	batch.Items++
+	batch.Wer += (score.Wer - batch.Wer) / float64(batch.Items)
+	batch.Cer += (score.Cer - batch.Cer) / float64(batch.Items)
+	batch.Words.add(score.Words)
+	batch.Chars.add(score.Chars)
+}
+
+// read_SttText reads a finalized transcript, or returns an *InputError
+// when the item hasn't been transcribed.
+func (app *App) read_SttText(ctx context.Context, objectKey string) (string, error) {
+
+	body, err := app.Storage.GetObject(ctx, objectKey)
+	if errors.Is(err, ErrObjectNotFound) {
+		return "", &InputError{Key: objectKey, Problem: InputMissing, Reason: "not transcribed"}
+	}
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	return string(data), err
+}
+
+// create_TranscriptScores scores the original and equalized transcripts of
+// every item of indices against its text in references. Transcripts that
+// can't be scored are reported per item; a storage error fails the batch.
+func (app *App) create_TranscriptScores(ctx context.Context, sessionId string, indices []int, references map[int]string, normalization TextNormalization) (TranscriptScores, error) {
+
+	result := TranscriptScores{
+		Count:         len(indices),
+		Normalization: normalization,
+		Items:         map[int]ItemScore{},
+		Averages:      map[string]*BatchScore{},
+	}
+	var deltas BatchScore
+
+	// 평균이 항상 같은 순서로 더해지도록 인덱스 순으로 돈다
+	indices = append([]int(nil), indices...)
+	sort.Ints(indices)
+
+	for _, idx := range indices {
+		item := ItemScore{Errors: map[string]*InputError{}}
+		reference, ok := references[idx]
+		if !ok {
+			item.Errors["reference"] = &InputError{Key: reference_Key(sessionId, idx), Problem: InputMissing, Reason: "no reference imported"}
+			result.Items[idx] = item
+			continue
+		}
+
+		for _, isOriginal := range []bool{true, false} {
+			label := stt_Label(isOriginal)
+			objectKey := stt_ObjectKey(sessionId, idx, isOriginal) + ".txt"
+
+			transcript, err := app.read_SttText(ctx, objectKey)
+			var inputErr *InputError
+			if errors.As(err, &inputErr) {
+				item.Errors[label] = inputErr
+				continue
+			}
+			if err != nil {
+				return TranscriptScores{}, err
+			}
+
+			score, err := score_Transcript(reference, transcript, normalization)
+			if errors.Is(err, ErrTooLongToScore) {
+				item.Errors[label] = &InputError{Key: objectKey, Problem: InputInvalid, Reason: err.Error()}
+				continue
+			}
+			score.Key = objectKey
+			if isOriginal {
+				item.Original = &score
+			} else {
+				item.Equalized = &score
+			}
+			if result.Averages[label] == nil {
+				result.Averages[label] = &BatchScore{}
+			}
+			result.Averages[label].add(score)
+		}
+
+		if item.Original != nil && item.Equalized != nil {
+			item.Delta = &ScoreDelta{Wer: item.Equalized.Wer - item.Original.Wer, Cer: item.Equalized.Cer - item.Original.Cer}
+			deltas.add(TranscriptScore{Wer: item.Delta.Wer, Cer: item.Delta.Cer})
+		}
+		result.Items[idx] = item
+	}
+
+	if deltas.Items > 0 {
+		result.Delta = &ScoreDelta{Wer: deltas.Wer, Cer: deltas.Cer}
+	}
+	return result, nil
+}
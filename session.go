@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+// ErrSessionNotFound is returned when a request names a session that was never created.
+var ErrSessionNotFound = errors.New("session not found")
+
+var sessionIdPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// session_Key returns objectKey inside the session's namespace.
+func session_Key(sessionId string, objectKey string) string {
+	if sessionId == "" {
+		return objectKey
+	}
+	return "sessions/" + sessionId + "/" + objectKey
+}
+
+// session_JobName returns jobName inside the session's namespace.
+// Transcribe job names only allow [0-9a-zA-Z._-], which session ids satisfy.
+func session_JobName(sessionId string, jobName string) string {
+	if sessionId == "" {
+		return jobName
+	}
+	return sessionId + "_" + jobName
+}
+
+// random_Id returns 16 random hex characters, used for session and pipeline run ids.
+func random_Id() (string, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// create_Session registers a new session. The session record is stored next
+// to the session's objects so it lives exactly as long as they do.
+func (app *App) create_Session(name string) (Session, error) {
+
+	sessionId, err := random_Id()
+	if err != nil {
+		return Session{}, err
+	}
+
+	session := Session{SessionId: sessionId, Name: name, CreatedAt: time.Now().UTC()}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return Session{}, err
+	}
+	err = app.Storage.PutObject(context.TODO(), session_Key(session.SessionId, "session.json"), bytes.NewReader(data))
+	if err != nil {
+		return Session{}, fmt.Errorf("couldn't store session: %w", err)
+	}
+
+	return session, nil
+}
+
+// get_Session loads a session created by create_Session.
+func (app *App) get_Session(sessionId string) (Session, error) {
+
+	if !sessionIdPattern.MatchString(sessionId) {
+		return Session{}, ErrSessionNotFound
+	}
+
+	body, err := app.Storage.GetObject(context.TODO(), session_Key(sessionId, "session.json"))
+	if errors.Is(err, ErrObjectNotFound) {
+		return Session{}, ErrSessionNotFound
+	}
+	if err != nil {
+		return Session{}, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return Session{}, err
+	}
+
+	var session Session
+	err = json.Unmarshal(data, &session)
+	return session, err
+}
+
+// check_Session accepts the empty (global) session and any created session.
+func (app *App) check_Session(sessionId string) error {
+	if sessionId == "" {
+		return nil
+	}
+	_, err := app.get_Session(sessionId)
+	return err
+}
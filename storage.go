@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ErrObjectNotFound is returned by Storage implementations when a key does not exist.
+var ErrObjectNotFound = errors.New("object not found")
+
+// ObjectInfo describes one stored object as returned by Storage.ListObjects
+// and Storage.HeadObject. ContentType is empty when the backend doesn't keep it.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+	ContentType  string
+}
+
+// Storage is the object store every pipeline stage reads from and writes to.
+// Keys are slash separated paths such as "enhance/1.wav".
+type Storage interface {
+	// GetObject opens the object for reading. The caller closes the returned body.
+	GetObject(ctx context.Context, objectKey string) (io.ReadCloser, error)
+	// PutObject stores body under objectKey, replacing any existing object.
+	PutObject(ctx context.Context, objectKey string, body io.Reader) error
+	// HeadObject describes the object without reading it.
+	HeadObject(ctx context.Context, objectKey string) (ObjectInfo, error)
+	// DeleteObject removes the object. Deleting a missing key is not an error.
+	DeleteObject(ctx context.Context, objectKey string) error
+	// ListObjects returns every object whose key starts with prefix.
+	ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// PresignGetObject returns a URL any HTTP client can GET the object from.
+	PresignGetObject(ctx context.Context, objectKey string, lifetimeSecs int64) (string, error)
+	// PresignUpload returns a request any HTTP client can upload the object
+	// with. The backend enforces policy's Content-Type and size limit.
+	PresignUpload(ctx context.Context, objectKey string, policy PresignPolicy) (PresignedUpload, error)
+}
+
+// PresignPolicy constrains a presigned upload. A MaxBytes of 0 means no limit.
+type PresignPolicy struct {
+	LifetimeSecs int64  `json:"lifetimeSeconds"`
+	ContentType  string `json:"contentType,omitempty"`
+	MaxBytes     int64  `json:"maxBytes,omitempty"`
+}
+
+// PresignedUpload is how a worker uploads one object: a PUT of the body to
+// Url with Headers, or a multipart/form-data POST to Url with Fields
+// followed by the file in a "file" field.
+type PresignedUpload struct {
+	Method  string            `json:"method"`
+	Url     string            `json:"url"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// put_Upload describes a presigned PUT that has to send policy's Content-Type.
+func put_Upload(presignedUrl string, policy PresignPolicy) PresignedUpload {
+	upload := PresignedUpload{Method: http.MethodPut, Url: presignedUrl}
+	if policy.ContentType != "" {
+		upload.Headers = map[string]string{"Content-Type": policy.ContentType}
+	}
+	return upload
+}
+
+// object_Exists reports whether objectKey is in storage, without reading it.
+func object_Exists(ctx context.Context, storage Storage, objectKey string) (bool, error) {
+	_, err := storage.HeadObject(ctx, objectKey)
+	if errors.Is(err, ErrObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// new_Storage builds the backend selected by STORAGE_BACKEND.
+func new_Storage(appConfig AppConfig, s3Storage *S3Storage) (Storage, error) {
+
+	switch appConfig.StorageBackend {
+	case "", "s3":
+		return s3Storage, nil
+	case "local":
+		return new_LocalStorage(appConfig.LocalStorageDir, appConfig.PublicBaseUrl, appConfig.LocalStorageSecret)
+	case "memory":
+		return new_MemoryStorage(), nil
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND %q", appConfig.StorageBackend)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LocalStorage is the Storage backed by a directory on this machine.
+// Presigned URLs point back at this server's /storage route and carry an
+// HMAC signature, so workers use them exactly like S3 presigned URLs.
+type LocalStorage struct {
+	Dir     string
+	BaseUrl string
+	Secret  []byte
+}
+
+func new_LocalStorage(dir string, baseUrl string, secret string) (*LocalStorage, error) {
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create local storage dir %v: %w", dir, err)
+	}
+
+	storage := &LocalStorage{Dir: dir, BaseUrl: strings.TrimSuffix(baseUrl, "/"), Secret: []byte(secret)}
+
+	// secret 이 없으면 실행할 때마다 새로 만든다. 재시작하면 이전 URL 은 무효
+	if secret == "" {
+		storage.Secret = make([]byte, 32)
+		if _, err := rand.Read(storage.Secret); err != nil {
+			return nil, err
+		}
+		log.Printf("LOCAL_STORAGE_SECRET not set, presigned URLs will not survive a restart")
+	}
+
+	return storage, nil
+}
+
+// path_Of maps objectKey to a file under Dir, refusing keys that escape it.
+func (storage *LocalStorage) path_Of(objectKey string) (string, error) {
+	cleaned := path.Clean("/" + objectKey)
+	if cleaned == "/" || strings.HasSuffix(objectKey, "/") {
+		return "", fmt.Errorf("invalid object key %q", objectKey)
+	}
+	return filepath.Join(storage.Dir, filepath.FromSlash(cleaned[1:])), nil
+}
+
+func (storage *LocalStorage) GetObject(ctx context.Context, objectKey string) (io.ReadCloser, error) {
+	filePath, err := storage.path_Of(objectKey)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrObjectNotFound
+	}
+	return file, err
+}
+
+// PutObject writes to a temporary file first so readers never see a partial object.
+func (storage *LocalStorage) PutObject(ctx context.Context, objectKey string, body io.Reader) error {
+	filePath, err := storage.path_Of(objectKey)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(filePath), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = io.Copy(tmp, body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// HeadObject leaves ContentType empty; local storage doesn't keep it.
+func (storage *LocalStorage) HeadObject(ctx context.Context, objectKey string) (ObjectInfo, error) {
+	filePath, err := storage.path_Of(objectKey)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info, err := os.Stat(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return ObjectInfo{}, ErrObjectNotFound
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return ObjectInfo{Key: objectKey, Size: info.Size(), LastModified: info.ModTime()}, nil
+}
+
+func (storage *LocalStorage) DeleteObject(ctx context.Context, objectKey string) error {
+	filePath, err := storage.path_Of(objectKey)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (storage *LocalStorage) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+
+	err := filepath.WalkDir(storage.Dir, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(storage.Dir, filePath)
+		if err != nil {
+			return err
+		}
+		objectKey := filepath.ToSlash(rel)
+		if !strings.HasPrefix(objectKey, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{Key: objectKey, Size: info.Size(), LastModified: info.ModTime()})
+		return nil
+	})
+
+	return objects, err
+}
+
+func (storage *LocalStorage) PresignGetObject(ctx context.Context, objectKey string, lifetimeSecs int64) (string, error) {
+	return storage.presign(http.MethodGet, objectKey, lifetimeSecs, url.Values{})
+}
+
+// PresignUpload returns a PUT; the /storage route checks the signed limits.
+func (storage *LocalStorage) PresignUpload(ctx context.Context, objectKey string, policy PresignPolicy) (PresignedUpload, error) {
+	presignedUrl, err := storage.presign(http.MethodPut, objectKey, policy.LifetimeSecs, upload_Limits(policy))
+	if err != nil {
+		return PresignedUpload{}, err
+	}
+	return put_Upload(presignedUrl, policy), nil
+}
+
+// upload_Limits returns the query parameters carrying policy's limits.
+func upload_Limits(policy PresignPolicy) url.Values {
+	limits := url.Values{}
+	if policy.ContentType != "" {
+		limits.Set("content-type", policy.ContentType)
+	}
+	if policy.MaxBytes > 0 {
+		limits.Set("max-bytes", strconv.FormatInt(policy.MaxBytes, 10))
+	}
+	return limits
+}
+
+// presign signs method, objectKey, the expiry and limits (see upload_Limits)
+// and returns them as the query of a /storage URL.
+func (storage *LocalStorage) presign(method string, objectKey string, lifetimeSecs int64, limits url.Values) (string, error) {
+	if _, err := storage.path_Of(objectKey); err != nil {
+		return "", err
+	}
+
+	expires := strconv.FormatInt(time.Now().Unix()+lifetimeSecs, 10)
+
+	query := url.Values{}
+	for name, values := range limits {
+		query[name] = values
+	}
+	query.Set("method", method)
+	query.Set("expires", expires)
+	query.Set("signature", storage.signature(method, objectKey, expires, limits))
+
+	return storage.BaseUrl + "/storage/" + objectKey + "?" + query.Encode(), nil
+}
+
+func (storage *LocalStorage) signature(method string, objectKey string, expires string, limits url.Values) string {
+	mac := hmac.New(sha256.New, storage.Secret)
+	mac.Write([]byte(method + "\n" + objectKey + "\n" + expires))
+	// 제한이 없는 URL 은 이전과 같은 서명을 유지한다
+	if len(limits) > 0 {
+		mac.Write([]byte("\n" + limits.Encode()))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the query parameters added by presign for this request.
+func (storage *LocalStorage) verify(method string, objectKey string, query url.Values) bool {
+	if query.Get("method") != method {
+		return false
+	}
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	limits := url.Values{}
+	for _, name := range []string{"content-type", "max-bytes"} {
+		if query.Has(name) {
+			limits.Set(name, query.Get(name))
+		}
+	}
+	expected := storage.signature(method, objectKey, query.Get("expires"), limits)
+	return hmac.Equal([]byte(expected), []byte(query.Get("signature")))
+}
+
+// setRouter serves the URLs handed out by PresignGetObject and PresignUpload.
+func (storage *LocalStorage) setRouter(router *gin.Engine) {
+
+	router.GET("/storage/*objectKey", func(c *gin.Context) {
+
+		objectKey := strings.TrimPrefix(c.Param("objectKey"), "/")
+		if !storage.verify(http.MethodGet, objectKey, c.Request.URL.Query()) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid or expired signature"})
+			return
+		}
+
+		filePath, err := storage.path_Of(objectKey)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := os.Stat(filePath); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": ErrObjectNotFound.Error()})
+			return
+		}
+
+		c.File(filePath)
+	})
+
+	router.PUT("/storage/*objectKey", func(c *gin.Context) {
+
+		objectKey := strings.TrimPrefix(c.Param("objectKey"), "/")
+		if !storage.verify(http.MethodPut, objectKey, c.Request.URL.Query()) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "invalid or expired signature"})
+			return
+		}
+
+		query := c.Request.URL.Query()
+		if contentType := query.Get("content-type"); contentType != "" {
+			mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+			if mediaType != contentType {
+				c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be " + contentType})
+				return
+			}
+		}
+		body := c.Request.Body
+		if query.Has("max-bytes") {
+			maxBytes, _ := strconv.ParseInt(query.Get("max-bytes"), 10, 64)
+			if c.Request.ContentLength > maxBytes {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "upload is larger than " + query.Get("max-bytes") + " bytes"})
+				return
+			}
+			body = http.MaxBytesReader(c.Writer, body, maxBytes)
+		}
+
+		err := storage.PutObject(c.Request.Context(), objectKey, body)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "upload is larger than " + query.Get("max-bytes") + " bytes"})
+			return
+		}
+		if err != nil {
+			log.Printf("Couldn't store uploaded object %v. Here's why: %v\n", objectKey, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Status(http.StatusOK)
+	})
+}
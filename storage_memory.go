@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage is a Storage that keeps every object in a map.
+// It is meant for tests: its presigned URLs are opaque memory:// strings.
+type MemoryStorage struct {
+	mutex   sync.Mutex
+	objects map[string]memoryObject
+	uploads map[string]*memoryUpload
+}
+
+type memoryObject struct {
+	data         []byte
+	lastModified time.Time
+	contentType  string
+}
+
+type memoryUpload struct {
+	objectKey string
+	parts     map[int32][]byte
+}
+
+func new_MemoryStorage() *MemoryStorage {
+	return &MemoryStorage{objects: map[string]memoryObject{}, uploads: map[string]*memoryUpload{}}
+}
+
+func (storage *MemoryStorage) GetObject(ctx context.Context, objectKey string) (io.ReadCloser, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	object, ok := storage.objects[objectKey]
+	if !ok {
+		return nil, ErrObjectNotFound
+	}
+	return io.NopCloser(bytes.NewReader(object.data)), nil
+}
+
+func (storage *MemoryStorage) PutObject(ctx context.Context, objectKey string, body io.Reader) error {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	storage.objects[objectKey] = memoryObject{data: data, lastModified: time.Now()}
+	return nil
+}
+
+func (storage *MemoryStorage) HeadObject(ctx context.Context, objectKey string) (ObjectInfo, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	object, ok := storage.objects[objectKey]
+	if !ok {
+		return ObjectInfo{}, ErrObjectNotFound
+	}
+	return ObjectInfo{Key: objectKey, Size: int64(len(object.data)), LastModified: object.lastModified, ContentType: object.contentType}, nil
+}
+
+// set_ContentType sets the Content-Type objectKey is stored with, which
+// PutObject leaves empty.
+func (storage *MemoryStorage) set_ContentType(objectKey string, contentType string) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	object := storage.objects[objectKey]
+	object.contentType = contentType
+	storage.objects[objectKey] = object
+}
+
+func (storage *MemoryStorage) DeleteObject(ctx context.Context, objectKey string) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	delete(storage.objects, objectKey)
+	return nil
+}
+
+func (storage *MemoryStorage) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	var objects []ObjectInfo
+	for objectKey, object := range storage.objects {
+		if strings.HasPrefix(objectKey, prefix) {
+			objects = append(objects, ObjectInfo{Key: objectKey, Size: int64(len(object.data)), LastModified: object.lastModified})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Key < objects[j].Key })
+
+	return objects, nil
+}
+
+func (storage *MemoryStorage) PresignGetObject(ctx context.Context, objectKey string, lifetimeSecs int64) (string, error) {
+	return memory_PresignedUrl("GET", objectKey, lifetimeSecs, url.Values{}), nil
+}
+
+// PresignUpload always returns a PUT; the limits are only written into the URL.
+func (storage *MemoryStorage) PresignUpload(ctx context.Context, objectKey string, policy PresignPolicy) (PresignedUpload, error) {
+	return put_Upload(memory_PresignedUrl("PUT", objectKey, policy.LifetimeSecs, upload_Limits(policy)), policy), nil
+}
+
+func memory_PresignedUrl(method string, objectKey string, lifetimeSecs int64, query url.Values) string {
+	query.Set("method", method)
+	query.Set("expires", strconv.FormatInt(lifetimeSecs, 10))
+	return "memory:///" + objectKey + "?" + query.Encode()
+}
+
+func (storage *MemoryStorage) CreateMultipartUpload(ctx context.Context, objectKey string, contentType string) (string, error) {
+	uploadId, err := random_Id()
+	if err != nil {
+		return "", err
+	}
+
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	storage.uploads[uploadId] = &memoryUpload{objectKey: objectKey, parts: map[int32][]byte{}}
+	return uploadId, nil
+}
+
+func (storage *MemoryStorage) PresignUploadPart(ctx context.Context, objectKey string, uploadId string, partNumber int32, lifetimeSecs int64) (string, error) {
+	query := url.Values{}
+	query.Set("uploadId", uploadId)
+	query.Set("partNumber", strconv.Itoa(int(partNumber)))
+	return memory_PresignedUrl("PUT", objectKey, lifetimeSecs, query), nil
+}
+
+// upload_Part stores a part the way a PUT to a PresignUploadPart URL would
+// and returns its ETag.
+func (storage *MemoryStorage) upload_Part(uploadId string, partNumber int32, data []byte) (string, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	upload, ok := storage.uploads[uploadId]
+	if !ok {
+		return "", ErrUploadNotFound
+	}
+	upload.parts[partNumber] = data
+	return memory_ETag(data), nil
+}
+
+func memory_ETag(data []byte) string {
+	checksum := md5.Sum(data)
+	return strconv.Quote(hex.EncodeToString(checksum[:]))
+}
+
+// upload returns the upload uploadId of objectKey. The caller holds the mutex.
+func (storage *MemoryStorage) upload(objectKey string, uploadId string) (*memoryUpload, error) {
+	upload, ok := storage.uploads[uploadId]
+	if !ok || upload.objectKey != objectKey {
+		return nil, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+func (storage *MemoryStorage) ListUploadedParts(ctx context.Context, objectKey string, uploadId string) ([]UploadPart, error) {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	upload, err := storage.upload(objectKey, uploadId)
+	if err != nil {
+		return nil, err
+	}
+	parts := []UploadPart{}
+	for partNumber, data := range upload.parts {
+		parts = append(parts, UploadPart{PartNumber: partNumber, ETag: memory_ETag(data), Size: int64(len(data))})
+	}
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	return parts, nil
+}
+
+// CompleteMultipartUpload checks parts like S3 does: every part was
+// uploaded with that ETag and all but the last have minUploadPartSize bytes.
+func (storage *MemoryStorage) CompleteMultipartUpload(ctx context.Context, objectKey string, uploadId string, parts []UploadPart) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	upload, err := storage.upload(objectKey, uploadId)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	for i, part := range parts {
+		partData, ok := upload.parts[part.PartNumber]
+		if !ok || memory_ETag(partData) != part.ETag {
+			return fmt.Errorf("%w: part %v was not uploaded with etag %v", ErrInvalidParts, part.PartNumber, part.ETag)
+		}
+		if i < len(parts)-1 && len(partData) < minUploadPartSize {
+			return fmt.Errorf("%w: part %v is smaller than %v bytes", ErrInvalidParts, part.PartNumber, minUploadPartSize)
+		}
+		data = append(data, partData...)
+	}
+
+	storage.objects[objectKey] = memoryObject{data: data, lastModified: time.Now()}
+	delete(storage.uploads, uploadId)
+	return nil
+}
+
+func (storage *MemoryStorage) AbortMultipartUpload(ctx context.Context, objectKey string, uploadId string) error {
+	storage.mutex.Lock()
+	defer storage.mutex.Unlock()
+
+	if _, err := storage.upload(objectKey, uploadId); err != nil {
+		return err
+	}
+	delete(storage.uploads, uploadId)
+	return nil
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrRecordNotFound is returned for an unknown record id.
+var ErrRecordNotFound = errors.New("record not found")
+
+// Record kinds.
+const (
+	RecordPresign       = "presign"
+	RecordTranscription = "transcription"
+	RecordCleanup       = "cleanup"
+	RecordTranslation   = "translation"
+	RecordUpload        = "upload"
+	RecordExport        = "export"
+)
+
+// Record outcomes.
+const (
+	OutcomeOk    = "ok"
+	OutcomeError = "error"
+)
+
+// JobRecord is one thing the server did on behalf of a batch: a presign
+// batch, a transcription job start, a cleanup or a translation.
+type JobRecord struct {
+	RecordId  string            `json:"recordId"`
+	Kind      string            `json:"kind"`
+	SessionId string            `json:"sessionId,omitempty"`
+	Name      string            `json:"name"`
+	Indices   []int             `json:"indices,omitempty"`
+	Outcome   string            `json:"outcome"`
+	Error     string            `json:"error,omitempty"`
+	Detail    map[string]string `json:"detail,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+// RecordQuery filters list_Records. Zero fields match everything.
+type RecordQuery struct {
+	SessionId string
+	Kind      string
+	Since     time.Time
+	Limit     int
+}
+
+// JobStore keeps job records and the latest snapshots of pipeline runs,
+// tracked transcription jobs and webhook deliveries in an append-only JSON
+// lines file, so the history of a batch survives a restart. Once the
+// snapshots that newer ones replaced add up to CompactBytes, the file is
+// rewritten with the latest ones only.
+// With an empty path it keeps everything in memory only.
+type JobStore struct {
+	path         string
+	CompactBytes int64
+
+	mutex      sync.Mutex
+	file       *os.File
+	records    []JobRecord
+	runs       map[string]PipelineRun
+	jobs       map[string]TrackedJob
+	deliveries map[string]WebhookDelivery
+
+	// lineSizes is the size in the file of the latest snapshot of each run,
+	// job and delivery; superseded adds up the sizes of those they replaced.
+	lineSizes  map[string]int64
+	superseded int64
+}
+
+// defaultCompactBytes is how many bytes of replaced snapshots the store
+// file may hold before it is compacted.
+const defaultCompactBytes = 64 << 20
+
+// storeLine is one line of the store file; exactly one field is set.
+type storeLine struct {
+	Record   *JobRecord       `json:"record,omitempty"`
+	Run      *PipelineRun     `json:"run,omitempty"`
+	Job      *TrackedJob      `json:"job,omitempty"`
+	Delivery *WebhookDelivery `json:"delivery,omitempty"`
+}
+
+// key names what line is a snapshot of, or is empty for a record, which
+// nothing replaces.
+func (line storeLine) key() string {
+	switch {
+	case line.Run != nil:
+		return "run/" + line.Run.RunId
+	case line.Job != nil:
+		return "job/" + line.Job.JobName
+	case line.Delivery != nil:
+		return "delivery/" + line.Delivery.DeliveryId
+	}
+	return ""
+}
+
+// new_JobStore loads path and compacts it: only the latest snapshot of each
+// pipeline run, tracked job and webhook delivery is kept.
+func new_JobStore(path string) (*JobStore, error) {
+
+	store := &JobStore{
+		path:         path,
+		CompactBytes: defaultCompactBytes,
+		runs:         map[string]PipelineRun{},
+		jobs:         map[string]TrackedJob{},
+		deliveries:   map[string]WebhookDelivery{},
+		lineSizes:    map[string]int64{},
+	}
+	if path == "" {
+		return store, nil
+	}
+
+	err := store.load()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't load job store %v: %w", path, err)
+	}
+	err = store.compact()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't compact job store %v: %w", path, err)
+	}
+
+	log.Printf("Job store %v loaded: %v records, %v pipeline runs, %v tracked jobs", path, len(store.records), len(store.runs), len(store.jobs))
+
+	return store, nil
+}
+
+func (store *JobStore) load() error {
+
+	file, err := os.Open(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var line storeLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			// 마지막 줄이 쓰다 만 상태로 남을 수 있다
+			log.Printf("Skipping unreadable job store line. Here's why: %v\n", err)
+			continue
+		}
+		if line.Record != nil {
+			store.records = append(store.records, *line.Record)
+		}
+		if line.Run != nil {
+			store.runs[line.Run.RunId] = *line.Run
+		}
+		if line.Job != nil {
+			store.jobs[line.Job.JobName] = *line.Job
+		}
+		if line.Delivery != nil {
+			store.deliveries[line.Delivery.DeliveryId] = *line.Delivery
+		}
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the file from memory and leaves it open for appending.
+// The caller holds store.mutex, or is new_JobStore.
+func (store *JobStore) compact() error {
+
+	err := os.MkdirAll(filepath.Dir(store.path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(store.path), ".jobstore-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	lines := make([]storeLine, 0, len(store.records)+len(store.runs)+len(store.jobs)+len(store.deliveries))
+	for i := range store.records {
+		lines = append(lines, storeLine{Record: &store.records[i]})
+	}
+	for _, run := range store.runs {
+		run := run
+		lines = append(lines, storeLine{Run: &run})
+	}
+	for _, job := range store.jobs {
+		job := job
+		lines = append(lines, storeLine{Job: &job})
+	}
+	for _, delivery := range store.deliveries {
+		delivery := delivery
+		lines = append(lines, storeLine{Delivery: &delivery})
+	}
+
+	lineSizes := map[string]int64{}
+	writer := bufio.NewWriter(tmp)
+	for _, line := range lines {
+		data, err := json.Marshal(line)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+		if _, err := writer.Write(append(data, '\n')); err != nil {
+			tmp.Close()
+			return err
+		}
+		if key := line.key(); key != "" {
+			lineSizes[key] = int64(len(data) + 1)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), store.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(store.path, os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if store.file != nil {
+		store.file.Close()
+	}
+	store.file = file
+	store.lineSizes = lineSizes
+	store.superseded = 0
+	return nil
+}
+
+// append writes line to the file and compacts it once enough of it has
+// been replaced. The caller holds store.mutex.
+func (store *JobStore) append(line storeLine) {
+	if store.file == nil {
+		return
+	}
+
+	data, err := json.Marshal(line)
+	if err == nil {
+		_, err = store.file.Write(append(data, '\n'))
+	}
+	if err != nil {
+		log.Printf("Couldn't write job store %v. Here's why: %v\n", store.path, err)
+		return
+	}
+
+	if key := line.key(); key != "" {
+		store.superseded += store.lineSizes[key]
+		store.lineSizes[key] = int64(len(data) + 1)
+	}
+	if store.superseded < store.CompactBytes {
+		return
+	}
+	// 실패해도 기존 파일에 계속 덧붙이면 되므로 기록만 한다
+	if err := store.compact(); err != nil {
+		log.Printf("Couldn't compact job store %v. Here's why: %v\n", store.path, err)
+		store.superseded = 0
+	}
+}
+
+// add_Record stores record, filling in its id and timestamp.
+// Failures to write are logged; recording never fails the request itself.
+func (store *JobStore) add_Record(record JobRecord) JobRecord {
+
+	recordId, err := random_Id()
+	if err != nil {
+		log.Printf("Couldn't create record id. Here's why: %v\n", err)
+	}
+	record.RecordId = recordId
+	record.CreatedAt = time.Now().UTC()
+	if record.Outcome == "" {
+		record.Outcome = OutcomeOk
+		if record.Error != "" {
+			record.Outcome = OutcomeError
+		}
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.records = append(store.records, record)
+	store.append(storeLine{Record: &record})
+
+	return record
+}
+
+func (store *JobStore) get_Record(recordId string) (JobRecord, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, record := range store.records {
+		if record.RecordId == recordId {
+			return record, nil
+		}
+	}
+	return JobRecord{}, ErrRecordNotFound
+}
+
+// list_Records returns the records matching query, newest first.
+func (store *JobStore) list_Records(query RecordQuery) []JobRecord {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	records := []JobRecord{}
+	for i := len(store.records) - 1; i >= 0; i-- {
+		record := store.records[i]
+		if query.SessionId != "" && record.SessionId != query.SessionId {
+			continue
+		}
+		if query.Kind != "" && record.Kind != query.Kind {
+			continue
+		}
+		if !query.Since.IsZero() && record.CreatedAt.Before(query.Since) {
+			continue
+		}
+		records = append(records, record)
+		if query.Limit > 0 && len(records) == query.Limit {
+			break
+		}
+	}
+	return records
+}
+
+// save_Run stores the latest snapshot of run.
+func (store *JobStore) save_Run(run PipelineRun) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.runs[run.RunId] = run
+	store.append(storeLine{Run: &run})
+}
+
+// list_StoredRuns returns every stored pipeline run, oldest first.
+func (store *JobStore) list_StoredRuns() []PipelineRun {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	runs := []PipelineRun{}
+	for _, run := range store.runs {
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].CreatedAt.Before(runs[j].CreatedAt) })
+
+	return runs
+}
+
+// save_Job stores the latest snapshot of a tracked transcription job.
+func (store *JobStore) save_Job(job TrackedJob) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.jobs[job.JobName] = job
+	store.append(storeLine{Job: &job})
+}
+
+// list_StoredJobs returns every stored tracked job.
+func (store *JobStore) list_StoredJobs() []TrackedJob {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	jobs := []TrackedJob{}
+	for _, job := range store.jobs {
+		jobs = append(jobs, job)
+	}
+	return jobs
+}
+
+// save_Delivery stores the latest state of a webhook delivery.
+func (store *JobStore) save_Delivery(delivery WebhookDelivery) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.deliveries[delivery.DeliveryId] = delivery
+	store.append(storeLine{Delivery: &delivery})
+}
+
+func (store *JobStore) get_Delivery(deliveryId string) (WebhookDelivery, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delivery, ok := store.deliveries[deliveryId]
+	if !ok {
+		return WebhookDelivery{}, ErrDeliveryNotFound
+	}
+	return delivery, nil
+}
+
+// pending_Deliveries returns the deliveries that were still being tried.
+func (store *JobStore) pending_Deliveries() []WebhookDelivery {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range store.deliveries {
+		if delivery.Outcome == DeliveryPending {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt) })
+	return deliveries
+}
+
+// list_Deliveries returns the deliveries of sessionId, newest first.
+func (store *JobStore) list_Deliveries(sessionId string) []WebhookDelivery {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	deliveries := []WebhookDelivery{}
+	for _, delivery := range store.deliveries {
+		if delivery.SessionId == sessionId {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	sort.Slice(deliveries, func(i, j int) bool { return deliveries[i].CreatedAt.After(deliveries[j].CreatedAt) })
+	return deliveries
+}
+
+// record adds a JobRecord for something done on behalf of sessionId.
+// err, when set, marks the record as failed.
+func (app *App) record(kind string, sessionId string, name string, indices []int, err error, detail map[string]string) {
+
+	record := JobRecord{Kind: kind, SessionId: sessionId, Name: name, Indices: indices, Detail: detail}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	record = app.Store.add_Record(record)
+	app.Events.publish(record_Event(record))
+}
@@ -12,66 +12,236 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
-	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/joho/godotenv"
 	//"github.com/tidwall/gjson"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	tr "github.com/aws/aws-sdk-go-v2/service/transcribe"
 	"github.com/aws/aws-sdk-go-v2/service/transcribe/types"
 )
 
-func create_transcribe(idx int, isOriginal bool) STTStatus {
+// AwsTranscriber is the Transcriber backed by Amazon Transcribe.
+// Media and output keys refer to objects in BucketName.
+type AwsTranscriber struct {
+	Client     *tr.Client
+	BucketName string
+}
 
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
+func (transcriber *AwsTranscriber) StartJob(ctx context.Context, request TranscriptionRequest) error {
+
+	job := tr.StartTranscriptionJobInput{
+		TranscriptionJobName: aws.String(request.JobName),
+		Media: &types.Media{
+			MediaFileUri: aws.String("s3://" + transcriber.BucketName + "/" + request.MediaKey),
+		},
+		MediaFormat:  types.MediaFormat(request.MediaFormat),
+		LanguageCode: types.LanguageCode(request.LanguageCode),
+
+		// https://docs.aws.amazon.com/ko_kr/AmazonS3/latest/userguide/access-bucket-intro.html
+		// https://s3.us-east-2.amazonaws.com/{OutputBucketName}/{OutputKey}
+		// https://docs.aws.amazon.com/transcribe/latest/APIReference/API_StartTranscriptionJob.html#transcribe-StartTranscriptionJob-request-OutputBucketName
+		OutputBucketName: aws.String(transcriber.BucketName),
+		OutputKey:        aws.String(request.OutputKey),
 	}
 
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
-	)
+	// OutputKey 의 subtitles 파일명 영향 받음
+	if request.Subtitles {
+		job.Subtitles = &types.Subtitles{
+			Formats:          []types.SubtitleFormat{types.SubtitleFormatSrt},
+			OutputStartIndex: aws.Int32(1),
+		}
+	}
+
+	_, err := transcriber.Client.StartTranscriptionJob(ctx, &job)
+
+	var limitExceeded *types.LimitExceededException
+	if errors.As(err, &limitExceeded) {
+		return fmt.Errorf("%w: %v", ErrLimitExceeded, err)
+	}
+	return err
+}
+
+func (transcriber *AwsTranscriber) GetJob(ctx context.Context, jobName string) (TranscriptionJob, error) {
+
+	output, err := transcriber.Client.GetTranscriptionJob(ctx, &tr.GetTranscriptionJobInput{
+		TranscriptionJobName: aws.String(jobName),
+	})
 	if err != nil {
-		log.Fatal(err)
+		return TranscriptionJob{}, aws_JobError(err)
 	}
 
-	client := tr.NewFromConfig(cfg)
+	job := output.TranscriptionJob
+	return TranscriptionJob{
+		Name:          aws.ToString(job.TranscriptionJobName),
+		Status:        string(job.TranscriptionJobStatus),
+		FailureReason: aws.ToString(job.FailureReason),
+		CreatedAt:     aws.ToTime(job.CreationTime),
+		CompletedAt:   aws.ToTime(job.CompletionTime),
+	}, nil
+}
 
-	var sttResult STTStatus
+func (transcriber *AwsTranscriber) DeleteJob(ctx context.Context, jobName string) error {
+
+	_, err := transcriber.Client.DeleteTranscriptionJob(ctx, &tr.DeleteTranscriptionJobInput{
+		TranscriptionJobName: aws.String(jobName),
+	})
+	return aws_JobError(err)
+}
+
+// aws_JobError maps Transcribe's "job couldn't be found" BadRequestException to ErrJobNotFound.
+func aws_JobError(err error) error {
+	var badRequest *types.BadRequestException
+	if errors.As(err, &badRequest) && strings.Contains(badRequest.ErrorMessage(), "couldn't be found") {
+		return ErrJobNotFound
+	}
+	return err
+}
 
+func (transcriber *AwsTranscriber) ListJobs(ctx context.Context, prefix string) ([]TranscriptionJob, error) {
+
+	var jobs []TranscriptionJob
+
+	input := &tr.ListTranscriptionJobsInput{}
+	if prefix != "" {
+		input.JobNameContains = aws.String(prefix)
+	}
+
+	paginator := tr.NewListTranscriptionJobsPaginator(transcriber.Client, input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, summary := range output.TranscriptionJobSummaries {
+			// JobNameContains 는 부분 일치라서 prefix 로 한번 더 거른다
+			if !strings.HasPrefix(aws.ToString(summary.TranscriptionJobName), prefix) {
+				continue
+			}
+			jobs = append(jobs, TranscriptionJob{
+				Name:          aws.ToString(summary.TranscriptionJobName),
+				Status:        string(summary.TranscriptionJobStatus),
+				FailureReason: aws.ToString(summary.FailureReason),
+				CreatedAt:     aws.ToTime(summary.CreationTime),
+				CompletedAt:   aws.ToTime(summary.CompletionTime),
+			})
+		}
+	}
+	return jobs, nil
+}
+
+// stt_JobName returns the Transcribe job name of item idx.
+func stt_JobName(sessionId string, idx int, isOriginal bool) string {
+	if isOriginal {
+		return session_JobName(sessionId, "dolbyEqualizeStt_"+strconv.Itoa(idx)+"_original")
+	}
+	return session_JobName(sessionId, "dolbyEqualizeStt_"+strconv.Itoa(idx))
+}
+
+// stt_ObjectKey returns the key, without extension, of item idx's transcript.
+func stt_ObjectKey(sessionId string, idx int, isOriginal bool) string {
 	if isOriginal {
-		jobOriginal := tr.StartTranscriptionJobInput{
-			TranscriptionJobName: aws.String("dolbyEqualizeStt_" + strconv.Itoa(idx) + "_original"),
-			Media: &types.Media{
-				MediaFileUri: aws.String("s3://" + os.Getenv("S3_BUCKET_NAME") + "/original/" + strconv.Itoa(idx+1) + ".wav"),
-			},
-			MediaFormat:  "wav",
-			LanguageCode: "ko-KR",
-
-			// OutputKey 의 subtitles 파일명 영향 받음
-			// Subtitles: &types.Subtitles{
-			// 	Formats: []types.SubtitleFormat{types.SubtitleFormatVtt},
-			// 	OutputStartIndex: aws.Int32(0),
-			// },
-
-			// https://docs.aws.amazon.com/ko_kr/AmazonS3/latest/userguide/access-bucket-intro.html
-			// https://s3.us-east-2.amazonaws.com/{OutputBucketName}/{OutputKey}
-			// https://docs.aws.amazon.com/transcribe/latest/APIReference/API_StartTranscriptionJob.html#transcribe-StartTranscriptionJob-request-OutputBucketName
-			OutputBucketName: aws.String(os.Getenv("S3_BUCKET_NAME")),
-			OutputKey:        aws.String("stt_original/" + strconv.Itoa(idx+1) + ".json"),
+		return stage_Key(sessionId, "stt_original", idx, "")
+	}
+	return stage_Key(sessionId, "stt", idx, "")
+}
+
+// stt_Request describes the job transcribing item idx: the original audio
+// when isOriginal is set, the equalized audio otherwise.
+func stt_Request(sessionId string, idx int, isOriginal bool) TranscriptionRequest {
+
+	mediaKey := stage_Key(sessionId, "equalize", idx, ".wav")
+	if isOriginal {
+		mediaKey = stage_Key(sessionId, "original", idx, ".wav")
+	}
+
+	return TranscriptionRequest{
+		JobName:      stt_JobName(sessionId, idx, isOriginal),
+		MediaKey:     mediaKey,
+		MediaFormat:  "wav",
+		LanguageCode: "ko-KR",
+		OutputKey:    stt_ObjectKey(sessionId, idx, isOriginal) + ".json",
+	}
+}
+
+// stt_TrackedJob describes the tracked job transcribing item idx.
+func stt_TrackedJob(sessionId string, idx int, isOriginal bool, callbackUrl string) TrackedJob {
+	return TrackedJob{
+		JobName:     stt_JobName(sessionId, idx, isOriginal),
+		SessionId:   sessionId,
+		Index:       idx,
+		IsOriginal:  isOriginal,
+		ObjectKey:   stt_ObjectKey(sessionId, idx, isOriginal),
+		CallbackUrl: callbackUrl,
+	}
+}
+
+// video_TrackedJob describes the tracked job transcribing video idx.
+func video_TrackedJob(sessionId string, idx int, callbackUrl string) TrackedJob {
+	return TrackedJob{
+		JobName:     video_JobName(sessionId, idx),
+		SessionId:   sessionId,
+		Index:       idx,
+		IsVideo:     true,
+		ObjectKey:   video_ObjectKey(sessionId, idx),
+		CallbackUrl: callbackUrl,
+	}
+}
+
+// tracked_Request returns the request that starts job.
+func tracked_Request(job TrackedJob) TranscriptionRequest {
+	if job.IsVideo {
+		return video_Request(job.SessionId, job.Index)
+	}
+	return stt_Request(job.SessionId, job.Index, job.IsOriginal)
+}
+
+// start_Job starts job at the provider and records the attempt. The
+// equalized audio is read from the current equalize attempt.
+func (app *App) start_Job(job TrackedJob) error {
+
+	request := tracked_Request(job)
+	if !job.IsVideo && !job.IsOriginal {
+		mediaKey, err := app.current_Key(job.SessionId, "equalize", job.Index, ".wav")
+		if err != nil {
+			return err
 		}
+		request.MediaKey = mediaKey
+	}
 
-		// start the transcription job
-		_, err := client.StartTranscriptionJob(context.TODO(), &jobOriginal)
+	err := app.Transcriber.StartJob(context.TODO(), request)
+	app.record(RecordTranscription, job.SessionId, request.JobName, []int{job.Index}, err,
+		map[string]string{"mediaKey": request.MediaKey, "outputKey": request.OutputKey})
+
+	return err
+}
+
+// start_TrackedJob starts job and hands it to app.Poller.
+func (app *App) start_TrackedJob(job TrackedJob) error {
+
+	err := app.start_Job(job)
+	if err == nil {
+		app.Poller.track(job)
+	}
+	return err
+}
+
+// create_transcribe starts the job and hands it to app.Poller. callbackUrl,
+// when set, is notified when the job finishes.
+func (app *App) create_transcribe(sessionId string, idx int, isOriginal bool, callbackUrl string) STTStatus {
+
+	var sttResult STTStatus
+
+	// start the transcription job
+	err := app.start_TrackedJob(stt_TrackedJob(sessionId, idx, isOriginal, callbackUrl))
+
+	if isOriginal {
 		if err != nil {
 			log.Printf("Failed StartTranscriptionJob_original(%v). err: %v\n", idx, err)
 			sttResult.Result = err.Error()
@@ -80,29 +250,6 @@ func create_transcribe(idx int, isOriginal bool) STTStatus {
 			sttResult.Result = "Transcription_original started"
 		}
 	} else {
-		job := tr.StartTranscriptionJobInput{
-			TranscriptionJobName: aws.String("dolbyEqualizeStt_" + strconv.Itoa(idx)),
-			Media: &types.Media{
-				MediaFileUri: aws.String("s3://" + os.Getenv("S3_BUCKET_NAME") + "/equalize/" + strconv.Itoa(idx+1) + ".wav"),
-			},
-			MediaFormat:  "wav",
-			LanguageCode: "ko-KR",
-
-			// OutputKey 의 subtitles 파일명 영향 받음
-			// Subtitles: &types.Subtitles{
-			// 	Formats: []types.SubtitleFormat{types.SubtitleFormatVtt},
-			// 	OutputStartIndex: aws.Int32(0),
-			// },
-
-			// https://docs.aws.amazon.com/ko_kr/AmazonS3/latest/userguide/access-bucket-intro.html
-			// https://s3.us-east-2.amazonaws.com/{OutputBucketName}/{OutputKey}
-			// https://docs.aws.amazon.com/transcribe/latest/APIReference/API_StartTranscriptionJob.html#transcribe-StartTranscriptionJob-request-OutputBucketName
-			OutputBucketName: aws.String(os.Getenv("S3_BUCKET_NAME")),
-			OutputKey:        aws.String("stt/" + strconv.Itoa(idx+1) + ".json"),
-		}
-
-		// start the transcription job
-		_, err := client.StartTranscriptionJob(context.TODO(), &job)
 		if err != nil {
 			log.Printf("Failed StartTranscriptionJob(%v). err: %v\n", idx, err)
 			sttResult.Result = err.Error()
@@ -115,67 +262,38 @@ func create_transcribe(idx int, isOriginal bool) STTStatus {
 	return sttResult
 }
 
-func get_transcribe(idx int, isOriginal bool) STTStatus {
+func (app *App) get_transcribe(sessionId string, idx int, isOriginal bool) STTStatus {
 
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
+	var sttResult STTStatus
 
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
-	)
-	if err != nil {
-		log.Fatal(err)
+	// 서버가 이미 끝까지 처리했거나 아직 대기열에 있는 작업은 Transcribe 에 묻지 않는다
+	if job, ok := app.Poller.settled_Job(stt_JobName(sessionId, idx, isOriginal)); ok {
+		sttResult.Result = job.Status
+		return sttResult
 	}
 
-	client := tr.NewFromConfig(cfg)
-	var sttResult STTStatus
-
 	if isOriginal {
-		outputJobOriginal, err := client.GetTranscriptionJob(context.TODO(), &tr.GetTranscriptionJobInput{
-			TranscriptionJobName: aws.String("dolbyEqualizeStt_" + strconv.Itoa(idx) + "_original"),
-		})
+		outputJobOriginal, err := app.Transcriber.GetJob(context.TODO(), stt_JobName(sessionId, idx, true))
 		if err != nil {
 			log.Printf("Failed GetTranscriptionJob_original(%v). err: %v\n", idx, err)
 			sttResult.Result = err.Error()
 		} else {
-			sttResult.Result = string(outputJobOriginal.TranscriptionJob.TranscriptionJobStatus)
+			sttResult.Result = outputJobOriginal.Status
 		}
 	} else {
-		outputJob, err := client.GetTranscriptionJob(context.TODO(), &tr.GetTranscriptionJobInput{
-			TranscriptionJobName: aws.String("dolbyEqualizeStt_" + strconv.Itoa(idx)),
-		})
+		outputJob, err := app.Transcriber.GetJob(context.TODO(), stt_JobName(sessionId, idx, false))
 		if err != nil {
 			log.Printf("Failed GetTranscriptionJob(%v). err: %v\n", idx+1, err)
 			sttResult.Result = err.Error()
 		} else {
-			sttResult.Result = string(outputJob.TranscriptionJob.TranscriptionJobStatus)
+			sttResult.Result = outputJob.Status
 		}
 	}
 
 	return sttResult
 }
 
-func delete_transcribe(num int, isOriginal bool) string {
-
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
-	)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	client := tr.NewFromConfig(cfg)
+func (app *App) delete_transcribe(sessionId string, num int, isOriginal bool) string {
 
 	if isOriginal {
 
@@ -188,10 +306,11 @@ func delete_transcribe(num int, isOriginal bool) string {
 				defer waitDeleteTranscriptionJob.Done() //끝나면 .Done() 호출
 
 				for {
-					_, err := client.DeleteTranscriptionJob(context.TODO(), &tr.DeleteTranscriptionJobInput{
-						TranscriptionJobName: aws.String("dolbyEqualizeStt_" + strconv.Itoa(i) + "_original"),
-					})
-					if err != nil {
+					err := app.Transcriber.DeleteJob(context.TODO(), stt_JobName(sessionId, i, true))
+					if errors.Is(err, ErrJobNotFound) {
+						log.Printf("Transcription job(idx : %v) not found, nothing to delete", i)
+						done = true
+					} else if err != nil {
 						log.Printf("Failed DeleteTranscriptionJob_original(idx : %v). err: %v\n", i, err)
 					} else {
 						log.Printf("delete transcription original(%v)", i)
@@ -218,7 +337,10 @@ func delete_transcribe(num int, isOriginal bool) string {
 
 			go func(i int) {
 				defer waitCleanUpS3.Done() //끝나면 .Done() 호출
-				cleanup_TranscribeData(i, "stt_original/"+strconv.Itoa(i+1))
+				if app.Poller.finalized_Job(stt_JobName(sessionId, i, true)) {
+					return
+				}
+				app.cleanup_TranscribeData(sessionId, i, stt_ObjectKey(sessionId, i, true))
 			}(i)
 
 		}
@@ -237,10 +359,11 @@ func delete_transcribe(num int, isOriginal bool) string {
 
 				defer waitDeleteTranscriptionJob.Done() //끝나면 .Done() 호출
 				for {
-					_, err := client.DeleteTranscriptionJob(context.TODO(), &tr.DeleteTranscriptionJobInput{
-						TranscriptionJobName: aws.String("dolbyEqualizeStt_" + strconv.Itoa(i)),
-					})
-					if err != nil {
+					err := app.Transcriber.DeleteJob(context.TODO(), stt_JobName(sessionId, i, false))
+					if errors.Is(err, ErrJobNotFound) {
+						log.Printf("Transcription job(idx : %v) not found, nothing to delete", i)
+						done = true
+					} else if err != nil {
 						log.Printf("Failed DeleteTranscriptionJob(idx : %v). err: %v\n", i, err)
 					} else {
 						log.Printf("delete transcription(%v)", i)
@@ -267,8 +390,11 @@ func delete_transcribe(num int, isOriginal bool) string {
 
 			go func(i int) {
 				defer waitCleanUpS3.Done() //끝나면 .Done() 호출
+				if app.Poller.finalized_Job(stt_JobName(sessionId, i, false)) {
+					return
+				}
 
-				cleanup_TranscribeData(i, "stt/"+strconv.Itoa(i+1))
+				app.cleanup_TranscribeData(sessionId, i, stt_ObjectKey(sessionId, i, false))
 
 			}(i)
 		}
@@ -281,23 +407,7 @@ func delete_transcribe(num int, isOriginal bool) string {
 
 }
 
-func test_delete_all(num int, isOriginal bool) string {
-
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
-	)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	client := tr.NewFromConfig(cfg)
+func (app *App) test_delete_all(sessionId string, num int, isOriginal bool) string {
 
 	if isOriginal {
 
@@ -310,13 +420,14 @@ func test_delete_all(num int, isOriginal bool) string {
 				defer waitDeleteTranscriptionJob.Done() //끝나면 .Done() 호출
 
 				for {
-					deloutputOriginal, err := client.DeleteTranscriptionJob(context.TODO(), &tr.DeleteTranscriptionJobInput{
-						TranscriptionJobName: aws.String("dolbyEqualizeStt_" + strconv.Itoa(i) + "_original"),
-					})
-					if err != nil {
+					err := app.Transcriber.DeleteJob(context.TODO(), stt_JobName(sessionId, i, true))
+					if errors.Is(err, ErrJobNotFound) {
+						log.Printf("Transcription job(idx : %v) not found, nothing to delete", i)
+						done = true
+					} else if err != nil {
 						log.Printf("Failed DeleteTranscriptionJob_original(idx : %v). err: %v\n", i, err)
 					} else {
-						log.Println("delete transcription original,", deloutputOriginal)
+						log.Printf("delete transcription original(%v)", i)
 						done = true
 					}
 
@@ -344,13 +455,14 @@ func test_delete_all(num int, isOriginal bool) string {
 
 				defer waitDeleteTranscriptionJob.Done() //끝나면 .Done() 호출
 				for {
-					deloutput, err := client.DeleteTranscriptionJob(context.TODO(), &tr.DeleteTranscriptionJobInput{
-						TranscriptionJobName: aws.String("dolbyEqualizeStt_" + strconv.Itoa(i)),
-					})
-					if err != nil {
+					err := app.Transcriber.DeleteJob(context.TODO(), stt_JobName(sessionId, i, false))
+					if errors.Is(err, ErrJobNotFound) {
+						log.Printf("Transcription job(idx : %v) not found, nothing to delete", i)
+						done = true
+					} else if err != nil {
 						log.Printf("Failed DeleteTranscriptionJob(idx : %v). err: %v\n", i, err)
 					} else {
-						log.Println("delete transcription,", deloutput)
+						log.Printf("delete transcription(%v)", i)
 						done = true
 					}
 
@@ -370,49 +482,39 @@ func test_delete_all(num int, isOriginal bool) string {
 	return "delete ok"
 }
 
-func create_videotranscribe(idx int) STTStatus {
-
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
-	)
-	if err != nil {
-		log.Fatal(err)
-	}
+// video_JobName returns the Transcribe job name for video idx.
+func video_JobName(sessionId string, idx int) string {
+	return session_JobName(sessionId, "videoStt_"+strconv.Itoa(idx))
+}
 
-	client := tr.NewFromConfig(cfg)
+// video_ObjectKey returns the key, without extension, of the English transcript of video idx.
+func video_ObjectKey(sessionId string, idx int) string {
+	return session_Key(sessionId, "video/"+strconv.Itoa(idx+1)+".enT")
+}
 
-	var sttResult STTStatus
+// video_MediaKey returns the key of video idx, uploaded by the client.
+func video_MediaKey(sessionId string, idx int) string {
+	return session_Key(sessionId, "video/"+strconv.Itoa(idx+1)+".mp4")
+}
 
-	job := tr.StartTranscriptionJobInput{
-		TranscriptionJobName: aws.String("videoStt_" + strconv.Itoa(idx)),
-		Media: &types.Media{
-			MediaFileUri: aws.String("s3://" + os.Getenv("S3_BUCKET_NAME") + "/video/" + strconv.Itoa(idx+1) + ".mp4"),
-		},
+// video_Request describes the job transcribing video idx, with English subtitles.
+func video_Request(sessionId string, idx int) TranscriptionRequest {
+	return TranscriptionRequest{
+		JobName:      video_JobName(sessionId, idx),
+		MediaKey:     video_MediaKey(sessionId, idx),
 		MediaFormat:  "mp4",
 		LanguageCode: "en-US",
+		OutputKey:    video_ObjectKey(sessionId, idx) + ".json",
+		Subtitles:    true,
+	}
+}
 
-		// OutputKey 의 subtitles 파일명 영향 받음
-		Subtitles: &types.Subtitles{
-			Formats:          []types.SubtitleFormat{types.SubtitleFormatSrt},
-			OutputStartIndex: aws.Int32(1),
-		},
+func (app *App) create_videotranscribe(sessionId string, idx int, callbackUrl string) STTStatus {
 
-		// https://docs.aws.amazon.com/ko_kr/AmazonS3/latest/userguide/access-bucket-intro.html
-		// https://s3.us-east-2.amazonaws.com/{OutputBucketName}/{OutputKey}
-		// https://docs.aws.amazon.com/transcribe/latest/APIReference/API_StartTranscriptionJob.html#transcribe-StartTranscriptionJob-request-OutputBucketName
-		OutputBucketName: aws.String(os.Getenv("S3_BUCKET_NAME")),
-		OutputKey:        aws.String("video/" + strconv.Itoa(idx+1) + ".enT.json"),
-	}
+	var sttResult STTStatus
 
 	// start the transcription job
-	_, err = client.StartTranscriptionJob(context.TODO(), &job)
+	err := app.start_TrackedJob(video_TrackedJob(sessionId, idx, callbackUrl))
 	if err != nil {
 		log.Printf("Failed StartTranscriptionJob(%v). err: %v\n", idx, err)
 		sttResult.Result = err.Error()
@@ -424,55 +526,27 @@ func create_videotranscribe(idx int) STTStatus {
 	return sttResult
 }
 
-func get_videotranscribe(idx int) STTStatus {
+func (app *App) get_videotranscribe(sessionId string, idx int) STTStatus {
 
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
+	var sttResult STTStatus
 
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
-	)
-	if err != nil {
-		log.Fatal(err)
+	if job, ok := app.Poller.settled_Job(video_JobName(sessionId, idx)); ok {
+		sttResult.Result = job.Status
+		return sttResult
 	}
 
-	client := tr.NewFromConfig(cfg)
-	var sttResult STTStatus
-
-	outputJob, err := client.GetTranscriptionJob(context.TODO(), &tr.GetTranscriptionJobInput{
-		TranscriptionJobName: aws.String("videoStt_" + strconv.Itoa(idx)),
-	})
+	outputJob, err := app.Transcriber.GetJob(context.TODO(), video_JobName(sessionId, idx))
 	if err != nil {
 		log.Printf("Failed GetTranscriptionJob(%v). err: %v\n", idx+1, err)
 		sttResult.Result = err.Error()
 	} else {
-		sttResult.Result = string(outputJob.TranscriptionJob.TranscriptionJobStatus)
+		sttResult.Result = outputJob.Status
 	}
 
 	return sttResult
 }
 
-func delete_videotranscribe(num int) string {
-
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
-
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
-	)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	client := tr.NewFromConfig(cfg)
+func (app *App) delete_videotranscribe(sessionId string, num int) string {
 
 	var isDone = false
 	var waitDeleteTranscriptionJob sync.WaitGroup
@@ -484,10 +558,11 @@ func delete_videotranscribe(num int) string {
 
 			defer waitDeleteTranscriptionJob.Done() //끝나면 .Done() 호출
 			for {
-				_, err := client.DeleteTranscriptionJob(context.TODO(), &tr.DeleteTranscriptionJobInput{
-					TranscriptionJobName: aws.String("videoStt_" + strconv.Itoa(i)),
-				})
-				if err != nil {
+				err := app.Transcriber.DeleteJob(context.TODO(), video_JobName(sessionId, i))
+				if errors.Is(err, ErrJobNotFound) {
+					log.Printf("Transcription job(idx : %v) not found, nothing to delete", i)
+					done = true
+				} else if err != nil {
 					log.Printf("Failed DeleteTranscriptionJob(idx : %v). err: %v\n", i, err)
 				} else {
 					log.Printf("delete video transcription(%v)", i)
@@ -514,12 +589,17 @@ func delete_videotranscribe(num int) string {
 
 		go func(i int) {
 			defer waitCleanUpS3.Done() //끝나면 .Done() 호출
+			if app.Poller.finalized_Job(video_JobName(sessionId, i)) {
+				return
+			}
 
-			cleanup_VideoTranscribeData(i, "video/"+strconv.Itoa(i+1)+".enT")
+			app.cleanup_VideoTranscribeData(sessionId, i, video_ObjectKey(sessionId, i))
 
 		}(i)
 	}
 
+	waitCleanUpS3.Wait()
+
 	return "delete ok"
 
 }
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+// maxSttBatch bounds how many jobs one /startSttBatch call may start.
+const maxSttBatch = 1000
+
+// Per index results of /startSttBatch.
+const (
+	BatchStarted = "started"
+	BatchQueued  = "queued"
+	BatchFailed  = "failed"
+)
+
+// start_SttBatch starts one job per index through a pool of
+// SttBatchConcurrency workers. Jobs the provider refuses because too many
+// are running are queued on app.Poller, which starts them later.
+func (app *App) start_SttBatch(sessionId string, indices []int, isOriginal bool, isVideo bool, callbackUrl string) STTBatchStatus {
+
+	status := STTBatchStatus{Count: len(indices), Results: make([]STTBatchResult, len(indices))}
+
+	workers := app.Config.SttBatchConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+	slots := make(chan struct{}, workers)
+
+	var waitStart sync.WaitGroup
+	waitStart.Add(len(indices))
+
+	for i, idx := range indices {
+		slots <- struct{}{}
+		go func(i int, idx int) {
+			defer waitStart.Done() //끝나면 .Done() 호출
+			defer func() { <-slots }()
+
+			job := stt_TrackedJob(sessionId, idx, isOriginal, callbackUrl)
+			if isVideo {
+				job = video_TrackedJob(sessionId, idx, callbackUrl)
+			}
+			result := STTBatchResult{Index: idx, JobName: job.JobName, Result: BatchStarted}
+
+			err := app.start_TrackedJob(job)
+			if errors.Is(err, ErrLimitExceeded) {
+				app.Poller.queue(job)
+				result.Result = BatchQueued
+			} else if err != nil {
+				log.Printf("Failed StartTranscriptionJob(%v). err: %v\n", idx, err)
+				result.Result = BatchFailed
+				result.Error = err.Error()
+			}
+			status.Results[i] = result
+		}(i, idx)
+	}
+
+	waitStart.Wait()
+
+	for _, result := range status.Results {
+		switch result.Result {
+		case BatchStarted:
+			status.Started++
+		case BatchQueued:
+			status.Queued++
+		default:
+			status.Failed++
+		}
+	}
+	log.Printf("Transcription batch: %v started, %v queued, %v failed", status.Started, status.Queued, status.Failed)
+
+	return status
+}
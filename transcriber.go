@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrJobNotFound is returned by Transcriber implementations when a job name is unknown.
+var ErrJobNotFound = errors.New("transcription job not found")
+
+// ErrLimitExceeded is returned by StartJob when the provider is running as
+// many jobs as it allows. The job can be started again later.
+var ErrLimitExceeded = errors.New("too many transcription jobs running")
+
+// Job statuses, matching Amazon Transcribe's TranscriptionJobStatus values.
+const (
+	JobStatusQueued     = "QUEUED"
+	JobStatusInProgress = "IN_PROGRESS"
+	JobStatusCompleted  = "COMPLETED"
+	JobStatusFailed     = "FAILED"
+)
+
+// TranscriptionRequest describes a job to start. MediaKey and OutputKey are
+// Storage keys; the transcript JSON is written to OutputKey.
+type TranscriptionRequest struct {
+	JobName      string
+	MediaKey     string
+	MediaFormat  string
+	LanguageCode string
+	OutputKey    string
+	// Subtitles also writes an SRT file next to OutputKey.
+	Subtitles bool
+}
+
+// TranscriptionJob is the provider independent view of one job.
+type TranscriptionJob struct {
+	Name          string
+	Status        string
+	FailureReason string
+	CreatedAt     time.Time
+	CompletedAt   time.Time
+}
+
+// Transcriber starts and tracks speech to text jobs.
+type Transcriber interface {
+	StartJob(ctx context.Context, request TranscriptionRequest) error
+	GetJob(ctx context.Context, jobName string) (TranscriptionJob, error)
+	DeleteJob(ctx context.Context, jobName string) error
+	// ListJobs returns every job whose name starts with prefix.
+	ListJobs(ctx context.Context, prefix string) ([]TranscriptionJob, error)
+}
+
+// new_Transcriber builds the provider selected by TRANSCRIBE_PROVIDER.
+func new_Transcriber(appConfig AppConfig, awsTranscriber *AwsTranscriber, storage Storage) (Transcriber, error) {
+
+	switch appConfig.TranscribeProvider {
+	case "", "aws":
+		return awsTranscriber, nil
+	case "fake":
+		return new_FakeTranscriber(storage), nil
+	default:
+		return nil, fmt.Errorf("unknown TRANSCRIBE_PROVIDER %q", appConfig.TranscribeProvider)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeTranscriber is a deterministic stand-in for Amazon Transcribe.
+//
+// A started job is IN_PROGRESS until GetJob has been called PollsToComplete
+// times, then it becomes COMPLETED and the transcript JSON (in the Transcribe
+// output format) is written to the job's OutputKey. A job whose media object
+// does not exist in Storage becomes FAILED instead.
+type FakeTranscriber struct {
+	Storage         Storage
+	PollsToComplete int
+	// MaxActiveJobs, when set, makes StartJob fail with ErrLimitExceeded
+	// while that many jobs are IN_PROGRESS, like Transcribe's job quota.
+	MaxActiveJobs int
+	// Transcripts maps a media key to the text the job produces.
+	// Media without an entry gets "fake transcript of <media key>".
+	Transcripts map[string]string
+
+	mutex sync.Mutex
+	jobs  map[string]*fakeJob
+}
+
+type fakeJob struct {
+	request TranscriptionRequest
+	job     TranscriptionJob
+	polls   int
+}
+
+func new_FakeTranscriber(storage Storage) *FakeTranscriber {
+	return &FakeTranscriber{
+		Storage:         storage,
+		PollsToComplete: 2,
+		Transcripts:     map[string]string{},
+		jobs:            map[string]*fakeJob{},
+	}
+}
+
+func (fake *FakeTranscriber) StartJob(ctx context.Context, request TranscriptionRequest) error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if _, ok := fake.jobs[request.JobName]; ok {
+		return fmt.Errorf("the requested job name %v already exists", request.JobName)
+	}
+
+	if fake.MaxActiveJobs > 0 {
+		active := 0
+		for _, entry := range fake.jobs {
+			if entry.job.Status == JobStatusInProgress {
+				active++
+			}
+		}
+		if active >= fake.MaxActiveJobs {
+			return fmt.Errorf("%w: %v jobs in progress", ErrLimitExceeded, active)
+		}
+	}
+
+	fake.jobs[request.JobName] = &fakeJob{
+		request: request,
+		job: TranscriptionJob{
+			Name:      request.JobName,
+			Status:    JobStatusInProgress,
+			CreatedAt: time.Now(),
+		},
+	}
+	return nil
+}
+
+func (fake *FakeTranscriber) GetJob(ctx context.Context, jobName string) (TranscriptionJob, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	entry, ok := fake.jobs[jobName]
+	if !ok {
+		return TranscriptionJob{}, ErrJobNotFound
+	}
+
+	if entry.job.Status == JobStatusInProgress {
+		entry.polls++
+		if entry.polls >= fake.PollsToComplete {
+			fake.finish(ctx, entry)
+		}
+	}
+
+	return entry.job, nil
+}
+
+func (fake *FakeTranscriber) DeleteJob(ctx context.Context, jobName string) error {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	if _, ok := fake.jobs[jobName]; !ok {
+		return ErrJobNotFound
+	}
+	delete(fake.jobs, jobName)
+	return nil
+}
+
+func (fake *FakeTranscriber) ListJobs(ctx context.Context, prefix string) ([]TranscriptionJob, error) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+
+	var jobs []TranscriptionJob
+	for jobName, entry := range fake.jobs {
+		if strings.HasPrefix(jobName, prefix) {
+			jobs = append(jobs, entry.job)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+
+	return jobs, nil
+}
+
+// finish moves entry to COMPLETED or FAILED and writes its output objects.
+// The caller holds fake.mutex.
+func (fake *FakeTranscriber) finish(ctx context.Context, entry *fakeJob) {
+
+	entry.job.CompletedAt = time.Now()
+
+	media, err := fake.Storage.GetObject(ctx, entry.request.MediaKey)
+	if err != nil {
+		entry.job.Status = JobStatusFailed
+		entry.job.FailureReason = fmt.Sprintf("The media file %v couldn't be read: %v", entry.request.MediaKey, err)
+		return
+	}
+	media.Close()
+
+	text, ok := fake.Transcripts[entry.request.MediaKey]
+	if !ok {
+		text = "fake transcript of " + entry.request.MediaKey
+	}
+
+	output, err := fake_TranscriptJson(entry.request.JobName, text)
+	if err == nil {
+		err = fake.Storage.PutObject(ctx, entry.request.OutputKey, bytes.NewReader(output))
+	}
+	if err == nil && entry.request.Subtitles {
+		srtKey := strings.TrimSuffix(entry.request.OutputKey, ".json") + ".srt"
+		err = fake.Storage.PutObject(ctx, srtKey, strings.NewReader(fake_Srt(text)))
+	}
+	if err != nil {
+		entry.job.Status = JobStatusFailed
+		entry.job.FailureReason = fmt.Sprintf("Couldn't write output %v: %v", entry.request.OutputKey, err)
+		return
+	}
+
+	entry.job.Status = JobStatusCompleted
+}
+
+// fake_TranscriptJson renders text the way Transcribe writes its output file:
+// the full transcript plus one pronunciation item per word, one second apart.
+func fake_TranscriptJson(jobName string, text string) ([]byte, error) {
+
+	type alternative struct {
+		Confidence string `json:"confidence"`
+		Content    string `json:"content"`
+	}
+	type item struct {
+		StartTime    string        `json:"start_time"`
+		EndTime      string        `json:"end_time"`
+		Alternatives []alternative `json:"alternatives"`
+		Type         string        `json:"type"`
+	}
+
+	items := []item{}
+	for i, word := range strings.Fields(text) {
+		items = append(items, item{
+			StartTime:    strconv.Itoa(i) + ".0",
+			EndTime:      strconv.Itoa(i) + ".9",
+			Alternatives: []alternative{{Confidence: "1.0", Content: word}},
+			Type:         "pronunciation",
+		})
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"jobName":   jobName,
+		"accountId": "000000000000",
+		"status":    JobStatusCompleted,
+		"results": map[string]interface{}{
+			"transcripts": []map[string]string{{"transcript": text}},
+			"items":       items,
+		},
+	})
+}
+
+func fake_Srt(text string) string {
+	end := time.Duration(len(strings.Fields(text)))*time.Second - 100*time.Millisecond
+	if end < 0 {
+		end = 0
+	}
+	timestamp := fmt.Sprintf("%02d:%02d:%02d,%03d",
+		int(end.Hours()), int(end.Minutes())%60, int(end.Seconds())%60, end.Milliseconds()%1000)
+	return "1\n00:00:00,000 --> " + timestamp + "\n" + text + "\n"
+}
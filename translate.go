@@ -2,49 +2,69 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
-	"os"
 
-	"github.com/joho/godotenv"
 	//"github.com/tidwall/gjson"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	tr "github.com/aws/aws-sdk-go-v2/service/translate"
 )
 
-func trnaslate_en_to_kr(data string) string {
+// Translator translates text between two language codes ("en", "ko", ...).
+type Translator interface {
+	Translate(ctx context.Context, text string, sourceLanguage string, targetLanguage string) (string, error)
+}
 
-	err := godotenv.Load()
-	if err != nil {
-		log.Fatal("Error loading .env file")
-	}
+// AwsTranslator is the Translator backed by Amazon Translate.
+type AwsTranslator struct {
+	Client *tr.Client
+}
+
+func (translator *AwsTranslator) Translate(ctx context.Context, text string, sourceLanguage string, targetLanguage string) (string, error) {
 
-	// Load the Shared AWS Configuration (~/.aws/config)
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithRegion(os.Getenv("S3_REGION")),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(os.Getenv("S3_ACCESSKEY"), os.Getenv("S3_PRIVATEDID"), "")),
+	translated, err := translator.Client.TranslateText(ctx,
+		&tr.TranslateTextInput{
+			SourceLanguageCode: aws.String(sourceLanguage),
+			TargetLanguageCode: aws.String(targetLanguage),
+			Text:               aws.String(text),
+		},
 	)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 
-	client := tr.NewFromConfig(cfg)
+	return aws.ToString(translated.TranslatedText), nil
+}
 
+// FakeTranslator tags the text with the target language instead of translating it.
+type FakeTranslator struct{}
 
-	translated, err := client.TranslateText(context.TODO(),
-		&tr.TranslateTextInput{
-			SourceLanguageCode: aws.String("en"),
-			TargetLanguageCode: aws.String("ko"),
-			Text: aws.String(data),
-		},
-	)
+func (translator FakeTranslator) Translate(ctx context.Context, text string, sourceLanguage string, targetLanguage string) (string, error) {
+	return fmt.Sprintf("[%v] %v", targetLanguage, text), nil
+}
+
+// new_Translator builds the provider selected by TRANSLATE_PROVIDER.
+func new_Translator(appConfig AppConfig, awsTranslator *AwsTranslator) (Translator, error) {
+
+	switch appConfig.TranslateProvider {
+	case "", "aws":
+		return awsTranslator, nil
+	case "fake":
+		return FakeTranslator{}, nil
+	default:
+		return nil, fmt.Errorf("unknown TRANSLATE_PROVIDER %q", appConfig.TranslateProvider)
+	}
+}
+
+func (app *App) trnaslate_en_to_kr(data string) (string, error) {
+
+	translated, err := app.Translator.Translate(context.TODO(), data, "en", "ko")
 	if err != nil {
 		log.Printf("Failed translate. err: %v\n", err)
 	} else {
 		log.Printf("translate done")
 	}
 
-	return *translated.TranslatedText
+	return translated, err
 }
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrTusUploadNotFound is returned for an unknown tus upload id.
+var ErrTusUploadNotFound = errors.New("upload not found")
+
+// TusVersion is the only tus protocol version /uploads speaks.
+const TusVersion = "1.0.0"
+
+// Kinds of file a tus upload can carry, named in its "kind" metadata.
+const (
+	UploadKindAudio = "audio"
+	UploadKindVideo = "video"
+	UploadKindExcel = "excel"
+)
+
+// TusUpload is the state of one resumable upload. It is kept as <id>.json
+// next to the staged data <id>.bin, so uploads survive a restart.
+type TusUpload struct {
+	UploadId  string        `json:"uploadId"`
+	Length    int64         `json:"length"`
+	Kind      string        `json:"kind"`
+	SessionId string        `json:"sessionId,omitempty"`
+	Index     int           `json:"index"`
+	Filename  string        `json:"filename,omitempty"`
+	ObjectKey string        `json:"objectKey"`
+	Metadata  string        `json:"metadata,omitempty"`
+	Mapping   *ExcelMapping `json:"mapping,omitempty"`
+	CreatedAt time.Time     `json:"createdAt"`
+}
+
+// TusUploads serves tus 1.0.0 resumable uploads (core protocol with the
+// creation and termination extensions) on /uploads. Chunks are appended to
+// a file in Dir; the finished file is committed to app.Storage under the
+// pipeline key its metadata names:
+//
+//	kind=audio, index=N  ->  original/N+1.wav
+//	kind=video, index=N  ->  video/N+1.mp4
+//	kind=excel, filename ->  the file name, as /uploadExcel stores it
+//
+// plus sessionId for uploads into a session. Like /uploadExcel, an excel
+// upload takes sheet, indexColumn, referenceColumn and headerRows, and its
+// reference transcripts are imported once it is committed.
+type TusUploads struct {
+	Dir     string
+	MaxSize int64
+
+	app *App
+
+	mutex   sync.Mutex
+	patches map[string]bool
+}
+
+func new_TusUploads(app *App, dir string, maxSize int64) (*TusUploads, error) {
+
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't create upload dir %v: %w", dir, err)
+	}
+	return &TusUploads{Dir: dir, MaxSize: maxSize, app: app, patches: map[string]bool{}}, nil
+}
+
+func (uploads *TusUploads) info_Path(uploadId string) string {
+	return filepath.Join(uploads.Dir, uploadId+".json")
+}
+
+func (uploads *TusUploads) data_Path(uploadId string) string {
+	return filepath.Join(uploads.Dir, uploadId+".bin")
+}
+
+// load reads the state of uploadId and how many bytes of it have arrived.
+func (uploads *TusUploads) load(uploadId string) (TusUpload, int64, error) {
+
+	// id 는 random_Id 로만 만든다. 경로로 쓰기 전에 확인
+	if uploadId == "" || strings.ContainsAny(uploadId, `/\.`) {
+		return TusUpload{}, 0, ErrTusUploadNotFound
+	}
+
+	data, err := os.ReadFile(uploads.info_Path(uploadId))
+	if errors.Is(err, fs.ErrNotExist) {
+		return TusUpload{}, 0, ErrTusUploadNotFound
+	}
+	if err != nil {
+		return TusUpload{}, 0, err
+	}
+	var upload TusUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return TusUpload{}, 0, err
+	}
+
+	info, err := os.Stat(uploads.data_Path(uploadId))
+	if err != nil {
+		return TusUpload{}, 0, err
+	}
+	return upload, info.Size(), nil
+}
+
+func (uploads *TusUploads) save(upload TusUpload) error {
+	data, err := json.Marshal(upload)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(uploads.info_Path(upload.UploadId), data, 0o644)
+}
+
+// begin_Patch marks uploadId as being written; false when another PATCH is.
+func (uploads *TusUploads) begin_Patch(uploadId string) bool {
+	uploads.mutex.Lock()
+	defer uploads.mutex.Unlock()
+
+	if uploads.patches[uploadId] {
+		return false
+	}
+	uploads.patches[uploadId] = true
+	return true
+}
+
+func (uploads *TusUploads) end_Patch(uploadId string) {
+	uploads.mutex.Lock()
+	defer uploads.mutex.Unlock()
+	delete(uploads.patches, uploadId)
+}
+
+// create stages a new upload of length bytes described by metadata.
+func (uploads *TusUploads) create(length int64, metadata map[string]string, rawMetadata string) (TusUpload, error) {
+
+	upload, err := tus_Target(metadata)
+	if err != nil {
+		return TusUpload{}, err
+	}
+	upload.UploadId, err = random_Id()
+	if err != nil {
+		return TusUpload{}, err
+	}
+	upload.Length = length
+	upload.Metadata = rawMetadata
+	if upload.Kind == UploadKindExcel {
+		mapping, err := uploads.app.Config.ExcelMapping.with_Overrides(metadata["sheet"], metadata["indexColumn"], metadata["referenceColumn"], metadata["headerRows"])
+		if err != nil {
+			return TusUpload{}, err
+		}
+		upload.Mapping = &mapping
+	}
+	upload.CreatedAt = time.Now().UTC()
+
+	err = os.WriteFile(uploads.data_Path(upload.UploadId), nil, 0o644)
+	if err != nil {
+		return TusUpload{}, err
+	}
+	return upload, uploads.save(upload)
+}
+
+// append_Chunk writes body at offset and returns the new offset. Whatever
+// arrived before the connection broke is kept, so the client can resume.
+func (uploads *TusUploads) append_Chunk(upload TusUpload, offset int64, body io.Reader) (int64, error) {
+
+	file, err := os.OpenFile(uploads.data_Path(upload.UploadId), os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return offset, err
+	}
+	written, err := io.Copy(file, io.LimitReader(body, upload.Length-offset))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return offset + written, err
+}
+
+// commit stores the finished upload under its object key and drops the staged
+// data with its state, so the upload is unknown afterwards.
+func (uploads *TusUploads) commit(upload TusUpload) error {
+
+	file, err := os.Open(uploads.data_Path(upload.UploadId))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var indices []int
+	if upload.Kind != UploadKindExcel {
+		indices = []int{upload.Index}
+	}
+	detail := map[string]string{"uploadId": upload.UploadId, "objectKey": upload.ObjectKey}
+
+	err = uploads.app.Storage.PutObject(context.TODO(), upload.ObjectKey, file)
+	if err != nil {
+		detail["status"] = "failed"
+		uploads.app.record(RecordUpload, upload.SessionId, "tusUpload", indices, err, detail)
+		return err
+	}
+	detail["status"] = "completed"
+	uploads.app.record(RecordUpload, upload.SessionId, "tusUpload", indices, nil, detail)
+	log.Printf("Put Object successful(%v)\n", upload.ObjectKey)
+
+	// 읽을 수 없는 엑셀은 다시 보내도 같으니, 저장한 파일은 두고 업로드는 끝낸다
+	var importErr error
+	if upload.Kind == UploadKindExcel {
+		mapping := uploads.app.Config.ExcelMapping
+		if upload.Mapping != nil {
+			mapping = *upload.Mapping
+		}
+		_, importErr = uploads.app.import_Excel(upload.SessionId, upload.ObjectKey, file, upload.Length, mapping)
+		if importErr != nil && !errors.Is(importErr, ErrInvalidWorkbook) {
+			return importErr
+		}
+	}
+
+	err = os.Remove(uploads.data_Path(upload.UploadId))
+	if err != nil {
+		return err
+	}
+	err = os.Remove(uploads.info_Path(upload.UploadId))
+	if err != nil {
+		return err
+	}
+	return importErr
+}
+
+// terminate drops an upload and its staged data.
+func (uploads *TusUploads) terminate(uploadId string) error {
+
+	if _, _, err := uploads.load(uploadId); err != nil {
+		return err
+	}
+	err := os.Remove(uploads.data_Path(uploadId))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.Remove(uploads.info_Path(uploadId))
+}
+
+// tus_Metadata parses an Upload-Metadata header: comma separated pairs of a
+// key and its base64 encoded value.
+func tus_Metadata(header string) (map[string]string, error) {
+
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		fields := strings.Fields(pair)
+		if len(fields) == 0 || len(fields) > 2 {
+			return nil, fmt.Errorf("invalid Upload-Metadata pair %q", pair)
+		}
+		value := ""
+		if len(fields) == 2 {
+			decoded, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid Upload-Metadata value of %v: %w", fields[0], err)
+			}
+			value = string(decoded)
+		}
+		metadata[fields[0]] = value
+	}
+	return metadata, nil
+}
+
+// tus_Target returns the upload described by metadata, with its object key.
+func tus_Target(metadata map[string]string) (TusUpload, error) {
+
+	upload := TusUpload{Kind: metadata["kind"], SessionId: metadata["sessionId"]}
+
+	switch upload.Kind {
+	case UploadKindAudio, UploadKindVideo:
+		idx, err := strconv.Atoi(metadata["index"])
+		if err != nil || idx < 0 {
+			return upload, fmt.Errorf("%v uploads need a non-negative index, got %q", upload.Kind, metadata["index"])
+		}
+		upload.Index = idx
+		if upload.Kind == UploadKindAudio {
+			upload.ObjectKey = stage_Key(upload.SessionId, "original", idx, ".wav")
+		} else {
+			upload.ObjectKey = video_MediaKey(upload.SessionId, idx)
+		}
+	case UploadKindExcel:
+		filename, err := excel_Filename(metadata["filename"])
+		if err != nil {
+			return upload, err
+		}
+		upload.Filename = filename
+		upload.ObjectKey = session_Key(upload.SessionId, filename)
+	default:
+		return upload, fmt.Errorf("kind must be %v, %v or %v, got %q", UploadKindAudio, UploadKindVideo, UploadKindExcel, upload.Kind)
+	}
+	return upload, nil
+}
+
+// setRouter serves the tus protocol on /uploads. Clients that can't send
+// PATCH or DELETE may POST with X-HTTP-Method-Override.
+func (uploads *TusUploads) setRouter(router *gin.Engine) {
+
+	group := router.Group("/uploads", func(c *gin.Context) {
+		c.Header("Tus-Resumable", TusVersion)
+		if c.Request.Method != http.MethodOptions && c.GetHeader("Tus-Resumable") != TusVersion {
+			c.Header("Tus-Version", TusVersion)
+			c.AbortWithStatus(http.StatusPreconditionFailed)
+		}
+	})
+
+	group.OPTIONS("", func(c *gin.Context) {
+		c.Header("Tus-Version", TusVersion)
+		c.Header("Tus-Extension", "creation,termination")
+		c.Header("Tus-Max-Size", strconv.FormatInt(uploads.MaxSize, 10))
+		c.Status(http.StatusNoContent)
+	})
+
+	group.POST("", func(c *gin.Context) {
+
+		length, err := strconv.ParseInt(c.GetHeader("Upload-Length"), 10, 64)
+		if err != nil || length < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Length is required"})
+			return
+		}
+		if length > uploads.MaxSize {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "upload is larger than " + strconv.FormatInt(uploads.MaxSize, 10) + " bytes"})
+			return
+		}
+		rawMetadata := c.GetHeader("Upload-Metadata")
+		metadata, err := tus_Metadata(rawMetadata)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if metadata["kind"] == UploadKindExcel && length > maxExcelUploadBytes {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "uploads must be at most " + strconv.Itoa(maxExcelUploadBytes) + " bytes"})
+			return
+		}
+		if !require_Session(c, uploads.app, metadata["sessionId"]) {
+			return
+		}
+
+		upload, err := uploads.create(length, metadata, rawMetadata)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// 길이가 0 이면 받을 데이터가 없으니 바로 저장
+		if length == 0 {
+			if err := uploads.commit(upload); err != nil {
+				log.Printf("Couldn't commit upload %v. Here's why: %v\n", upload.UploadId, err)
+				c.JSON(tus_Status(err), gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		c.Header("Location", "/uploads/"+upload.UploadId)
+		c.Status(http.StatusCreated)
+	})
+
+	group.HEAD("/:uploadId", func(c *gin.Context) {
+
+		upload, offset, err := uploads.load(c.Param("uploadId"))
+		if err != nil {
+			c.Status(tus_Status(err))
+			return
+		}
+
+		c.Header("Cache-Control", "no-store")
+		c.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+		c.Header("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		if upload.Metadata != "" {
+			c.Header("Upload-Metadata", upload.Metadata)
+		}
+		c.Status(http.StatusOK)
+	})
+
+	patch := func(c *gin.Context) {
+
+		uploadId := c.Param("uploadId")
+		if c.ContentType() != "application/offset+octet-stream" {
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Content-Type must be application/offset+octet-stream"})
+			return
+		}
+		offset, err := strconv.ParseInt(c.GetHeader("Upload-Offset"), 10, 64)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Upload-Offset is required"})
+			return
+		}
+
+		if !uploads.begin_Patch(uploadId) {
+			c.JSON(http.StatusLocked, gin.H{"error": "the upload is being written by another request"})
+			return
+		}
+		defer uploads.end_Patch(uploadId)
+
+		upload, current, err := uploads.load(uploadId)
+		if err != nil {
+			c.JSON(tus_Status(err), gin.H{"error": err.Error()})
+			return
+		}
+		if offset != current {
+			c.Header("Upload-Offset", strconv.FormatInt(current, 10))
+			c.JSON(http.StatusConflict, gin.H{"error": "Upload-Offset is " + strconv.FormatInt(current, 10)})
+			return
+		}
+
+		current, err = uploads.append_Chunk(upload, offset, c.Request.Body)
+		if err != nil {
+			log.Printf("Couldn't write upload %v. Here's why: %v\n", uploadId, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// 저장에 실패하면 길이만큼 받은 상태로 남고, 빈 PATCH 로 다시 시도한다
+		if current == upload.Length {
+			if err := uploads.commit(upload); err != nil {
+				log.Printf("Couldn't commit upload %v. Here's why: %v\n", uploadId, err)
+				c.JSON(tus_Status(err), gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		c.Header("Upload-Offset", strconv.FormatInt(current, 10))
+		c.Status(http.StatusNoContent)
+	}
+
+	terminate := func(c *gin.Context) {
+
+		uploadId := c.Param("uploadId")
+		if !uploads.begin_Patch(uploadId) {
+			c.JSON(http.StatusLocked, gin.H{"error": "the upload is being written by another request"})
+			return
+		}
+		defer uploads.end_Patch(uploadId)
+
+		err := uploads.terminate(uploadId)
+		if err != nil {
+			c.JSON(tus_Status(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+
+	group.PATCH("/:uploadId", patch)
+	group.DELETE("/:uploadId", terminate)
+	group.POST("/:uploadId", func(c *gin.Context) {
+		switch c.GetHeader("X-HTTP-Method-Override") {
+		case http.MethodPatch:
+			patch(c)
+		case http.MethodDelete:
+			terminate(c)
+		default:
+			c.Status(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+func tus_Status(err error) int {
+	if errors.Is(err, ErrTusUploadNotFound) {
+		return http.StatusNotFound
+	}
+	if errors.Is(err, ErrInvalidWorkbook) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusInternalServerError
+}
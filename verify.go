@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+)
+
+// InputError problems.
+const (
+	InputMissing = "missing"
+	InputInvalid = "invalid"
+)
+
+// InputError says why the input of one item can't be handed to a worker.
+// Presign endpoints return it per index instead of a URL that would fail.
+type InputError struct {
+	Key     string `json:"key"`
+	Problem string `json:"problem"`
+	Reason  string `json:"reason"`
+}
+
+func (err *InputError) Error() string {
+	return fmt.Sprintf("%v is %v: %v", err.Key, err.Problem, err.Reason)
+}
+
+// wavContentTypes are the Content-Types a WAV input may be stored with.
+// Uploads that didn't set one are stored as octet-stream.
+var wavContentTypes = map[string]bool{
+	"":                         true,
+	"audio/wav":                true,
+	"audio/x-wav":              true,
+	"audio/wave":               true,
+	"audio/vnd.wave":           true,
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+}
+
+// verify_Wav checks that objectKey is in storage and is a WAV file whose
+// samples are all there. It returns an *InputError when it isn't, and any
+// other error when storage couldn't be read.
+func (app *App) verify_Wav(ctx context.Context, objectKey string) (WavFormat, error) {
+
+	info, err := app.Storage.HeadObject(ctx, objectKey)
+	if errors.Is(err, ErrObjectNotFound) {
+		return WavFormat{}, &InputError{Key: objectKey, Problem: InputMissing, Reason: "not uploaded"}
+	}
+	if err != nil {
+		return WavFormat{}, err
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(info.ContentType)
+	if !wavContentTypes[mediaType] {
+		return WavFormat{}, &InputError{Key: objectKey, Problem: InputInvalid, Reason: "stored as " + info.ContentType}
+	}
+
+	body, err := app.Storage.GetObject(ctx, objectKey)
+	if err != nil {
+		return WavFormat{}, err
+	}
+	defer body.Close()
+
+	format, err := parse_WavHeader(body)
+	if err != nil {
+		return WavFormat{}, &InputError{Key: objectKey, Problem: InputInvalid, Reason: err.Error()}
+	}
+	if format.DataOffset+int64(format.DataSize) > info.Size {
+		return WavFormat{}, &InputError{Key: objectKey, Problem: InputInvalid,
+			Reason: fmt.Sprintf("truncated: %v of %v bytes", info.Size, format.DataOffset+int64(format.DataSize))}
+	}
+	return format, nil
+}
+
+// skip_Input reports whether err is an *InputError and, if so, adds it to
+// inputErrors under idx.
+func skip_Input(err error, inputErrors map[int]*InputError, idx int) bool {
+	var inputErr *InputError
+	if !errors.As(err, &inputErr) {
+		return false
+	}
+	inputErrors[idx] = inputErr
+	return true
+}
+
+// input_Status is the HTTP status for err from an endpoint that reads one input.
+func input_Status(err error) int {
+	var inputErr *InputError
+	switch {
+	case !errors.As(err, &inputErr):
+		return http.StatusInternalServerError
+	case inputErr.Problem == InputMissing:
+		return http.StatusNotFound
+	default:
+		return http.StatusUnprocessableEntity
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// WAVE_FORMAT_* values of the fmt chunk this server accepts.
+const (
+	wavFormatPcm        = 1
+	wavFormatFloat      = 3
+	wavFormatExtensible = 0xFFFE
+)
+
+// wavFmtFieldsSize is how much of the fmt chunk parse_WavHeader reads: the
+// fields of WAVE_FORMAT_EXTENSIBLE, the longest format it accepts.
+// maxFmtChunkSize bounds what it skips after them.
+const (
+	wavFmtFieldsSize = 40
+	maxFmtChunkSize  = 256
+)
+
+// maxWavChannels is the most channels check_WavFormat accepts, well above
+// any speaker layout WAVE_FORMAT_EXTENSIBLE describes.
+const maxWavChannels = 64
+
+// WavFormat is the fmt chunk of a WAV file and where its samples are.
+type WavFormat struct {
+	AudioFormat   uint16 `json:"audioFormat"`
+	Channels      uint16 `json:"channels"`
+	SampleRate    uint32 `json:"sampleRate"`
+	ByteRate      uint32 `json:"byteRate"`
+	BlockAlign    uint16 `json:"blockAlign"`
+	BitsPerSample uint16 `json:"bitsPerSample"`
+	DataOffset    int64  `json:"dataOffset"`
+	DataSize      uint32 `json:"dataSize"`
+	// SubFormat is the format code of WAVE_FORMAT_EXTENSIBLE files.
+	SubFormat uint16 `json:"subFormat,omitempty"`
+}
+
+// sample_Format is the format code the samples are actually stored in.
+func (format WavFormat) sample_Format() uint16 {
+	if format.AudioFormat == wavFormatExtensible {
+		return format.SubFormat
+	}
+	return format.AudioFormat
+}
+
+// parse_WavHeader reads a RIFF/WAVE header up to the start of the data
+// chunk, skipping any chunk it doesn't need.
+func parse_WavHeader(r io.Reader) (WavFormat, error) {
+
+	var format WavFormat
+
+	var riff [12]byte
+	if _, err := io.ReadFull(r, riff[:]); err != nil {
+		return format, errors.New("too short for a WAV header")
+	}
+	if string(riff[0:4]) != "RIFF" || string(riff[8:12]) != "WAVE" {
+		return format, errors.New("not a RIFF/WAVE file")
+	}
+	offset := int64(len(riff))
+
+	hasFormat := false
+	for {
+		var header [8]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return format, errors.New("no data chunk")
+		}
+		offset += int64(len(header))
+		id := string(header[0:4])
+		size := binary.LittleEndian.Uint32(header[4:8])
+		// 청크 크기가 홀수면 1 바이트 패딩이 붙는다
+		padded := int64(size) + int64(size%2)
+
+		switch id {
+		case "fmt ":
+			if size < 16 || size > maxFmtChunkSize {
+				return format, fmt.Errorf("fmt chunk has %v bytes", size)
+			}
+			// 필요한 필드만 읽고 나머지는 버린다
+			chunk := make([]byte, wavFmtFieldsSize)
+			if size < wavFmtFieldsSize {
+				chunk = chunk[:size]
+			}
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return format, errors.New("truncated fmt chunk")
+			}
+			if _, err := io.CopyN(io.Discard, r, padded-int64(len(chunk))); err != nil {
+				return format, errors.New("truncated fmt chunk")
+			}
+			format.AudioFormat = binary.LittleEndian.Uint16(chunk[0:2])
+			format.Channels = binary.LittleEndian.Uint16(chunk[2:4])
+			format.SampleRate = binary.LittleEndian.Uint32(chunk[4:8])
+			format.ByteRate = binary.LittleEndian.Uint32(chunk[8:12])
+			format.BlockAlign = binary.LittleEndian.Uint16(chunk[12:14])
+			format.BitsPerSample = binary.LittleEndian.Uint16(chunk[14:16])
+			// 확장 포맷은 GUID 의 앞 2 바이트가 실제 포맷 코드다
+			if format.AudioFormat == wavFormatExtensible && size >= 26 {
+				format.SubFormat = binary.LittleEndian.Uint16(chunk[24:26])
+			}
+			hasFormat = true
+		case "data":
+			if !hasFormat {
+				return format, errors.New("data chunk before fmt chunk")
+			}
+			format.DataOffset = offset
+			format.DataSize = size
+			return format, check_WavFormat(format)
+		default:
+			if _, err := io.CopyN(io.Discard, r, padded); err != nil {
+				return format, fmt.Errorf("truncated %q chunk", id)
+			}
+		}
+		offset += padded
+	}
+}
+
+func check_WavFormat(format WavFormat) error {
+
+	switch format.sample_Format() {
+	case wavFormatPcm, wavFormatFloat:
+	default:
+		return fmt.Errorf("unsupported audio format %v", format.AudioFormat)
+	}
+	if format.Channels == 0 || format.Channels > maxWavChannels || format.SampleRate == 0 {
+		return fmt.Errorf("%v channels at %v Hz", format.Channels, format.SampleRate)
+	}
+	switch format.BitsPerSample {
+	case 8, 16, 24, 32, 64:
+	default:
+		return fmt.Errorf("unsupported %v bits per sample", format.BitsPerSample)
+	}
+	if format.sample_Format() == wavFormatFloat && format.BitsPerSample != 32 && format.BitsPerSample != 64 {
+		return fmt.Errorf("unsupported %v bit float samples", format.BitsPerSample)
+	}
+	// uint16 로 곱하면 채널이 많을 때 0 으로 넘친다
+	if format.BlockAlign == 0 || uint32(format.BlockAlign) != uint32(format.Channels)*uint32(format.BitsPerSample)/8 {
+		return fmt.Errorf("block align %v doesn't match %v channels of %v bits", format.BlockAlign, format.Channels, format.BitsPerSample)
+	}
+	if format.DataSize < uint32(format.BlockAlign) {
+		return errors.New("no audio samples")
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"math/cmplx"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultWaveformPoints    = 1000
+	maxWaveformPoints        = 10000
+	defaultSpectrogramWidth  = 800
+	maxSpectrogramWidth      = 4000
+	defaultSpectrogramHeight = 256
+	// spectrogramFloorDb is the level drawn black; 0 dB, a full scale sine,
+	// is drawn brightest.
+	spectrogramFloorDb = -100.0
+)
+
+// Waveform is the mono mix of one WAV object reduced to the lowest and
+// highest sample of every FramesPerPoint frames.
+type Waveform struct {
+	Key            string    `json:"key"`
+	Attempt        int       `json:"attempt"`
+	SampleRate     uint32    `json:"sampleRate"`
+	DurationSecs   float64   `json:"durationSeconds"`
+	FramesPerPoint int       `json:"framesPerPoint"`
+	Min            []float32 `json:"min"`
+	Max            []float32 `json:"max"`
+}
+
+// Spectrogram is a PNG with one column per time slice and one row per
+// frequency band, lowest at the bottom.
+type Spectrogram struct {
+	Key      string `json:"key"`
+	Attempt  int    `json:"attempt"`
+	ImageKey string `json:"imageKey"`
+	Url      string `json:"url"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+}
+
+// visual_Key is where a rendering of audioKey is cached: next to it, under a
+// name latest_Attempt doesn't mistake for an attempt.
+func visual_Key(audioKey string, name string) string {
+	return strings.TrimSuffix(audioKey, ".wav") + "." + name
+}
+
+// check_SpectrogramSize reports whether a width by height spectrogram can be
+// drawn. The height is half the FFT size, so it must be a power of two.
+func check_SpectrogramSize(width int, height int) error {
+	if width < 1 || width > maxSpectrogramWidth {
+		return errors.New("width must be between 1 and " + strconv.Itoa(maxSpectrogramWidth))
+	}
+	if height < 32 || height > 1024 || height&(height-1) != 0 {
+		return errors.New("height must be a power of two between 32 and 1024")
+	}
+	return nil
+}
+
+// cache_Fresh reports whether cacheKey was written after audioKey last changed.
+func (app *App) cache_Fresh(ctx context.Context, cacheKey string, audioKey string) (bool, error) {
+
+	cache, err := app.Storage.HeadObject(ctx, cacheKey)
+	if errors.Is(err, ErrObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	audio, err := app.Storage.HeadObject(ctx, audioKey)
+	if err != nil {
+		return false, err
+	}
+	return !cache.LastModified.Before(audio.LastModified), nil
+}
+
+func compute_Waveform(r io.Reader, format WavFormat, points int) (Waveform, error) {
+
+	frames := int(format.DataSize / uint32(format.BlockAlign))
+	framesPerPoint := (frames + points - 1) / points
+	if framesPerPoint < 1 {
+		framesPerPoint = 1
+	}
+	waveform := Waveform{SampleRate: format.SampleRate, DurationSecs: format.duration_Secs(), FramesPerPoint: framesPerPoint}
+
+	n := 0
+	err := read_Frames(r, format, func(frame []float64) {
+		sample := float32(mean(frame))
+		if n%framesPerPoint == 0 {
+			waveform.Min = append(waveform.Min, sample)
+			waveform.Max = append(waveform.Max, sample)
+		}
+		last := len(waveform.Min) - 1
+		waveform.Min[last] = float32(math.Min(float64(waveform.Min[last]), float64(sample)))
+		waveform.Max[last] = float32(math.Max(float64(waveform.Max[last]), float64(sample)))
+		n++
+	})
+	return waveform, err
+}
+
+// fft transforms x in place; len(x) must be a power of two. twiddles holds
+// exp(-2πik/len(x)) for k below len(x)/2.
+func fft(x []complex128, twiddles []complex128) {
+
+	n := len(x)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		stride := n / size
+		for start := 0; start < n; start += size {
+			for k := 0; k < size/2; k++ {
+				a, b := x[start+k], twiddles[k*stride]*x[start+k+size/2]
+				x[start+k], x[start+k+size/2] = a+b, a-b
+			}
+		}
+	}
+}
+
+// spectrogramColors is an inferno-like ramp from silence to full scale.
+var spectrogramColors = []color.RGBA{
+	{0, 0, 4, 255},
+	{87, 16, 110, 255},
+	{188, 55, 84, 255},
+	{249, 142, 9, 255},
+	{252, 255, 164, 255},
+}
+
+func spectrogram_Color(level float64) color.RGBA {
+
+	position := math.Max(0, math.Min(1, level)) * float64(len(spectrogramColors)-1)
+	i := int(position)
+	if i == len(spectrogramColors)-1 {
+		return spectrogramColors[i]
+	}
+	from, to := spectrogramColors[i], spectrogramColors[i+1]
+	blend := func(a uint8, b uint8) uint8 { return uint8(float64(a) + (float64(b)-float64(a))*(position-float64(i))) }
+	return color.RGBA{blend(from.R, to.R), blend(from.G, to.G), blend(from.B, to.B), 255}
+}
+
+// compute_Spectrogram draws Hann-windowed FFTs of 2*height samples of the mono
+// mix, spread evenly over the file, while streaming it once.
+func compute_Spectrogram(r io.Reader, format WavFormat, width int, height int) (*image.RGBA, error) {
+
+	fftSize := 2 * height
+	frames := int(format.DataSize / uint32(format.BlockAlign))
+	hop := (frames + width - 1) / width
+	if hop < 1 {
+		hop = 1
+	}
+
+	window := make([]float64, fftSize)
+	twiddles := make([]complex128, fftSize/2)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(fftSize))
+	}
+	for k := range twiddles {
+		twiddles[k] = cmplx.Exp(complex(0, -2*math.Pi*float64(k)/float64(fftSize)))
+	}
+	// 전체 크기 사인파가 0 dB 가 되도록: Hann 창에서 |X| = N/4
+	fullScale := float64(fftSize) / 4
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	ring := make([]float64, fftSize)
+	spectrum := make([]complex128, fftSize)
+	n, column := 0, 0
+
+	add := func(sample float64) {
+		ring[n%fftSize] = sample
+		n++
+		// column 은 [column*hop, column*hop+fftSize) 구간을 쓴다
+		if column >= width || n != column*hop+fftSize {
+			return
+		}
+		for i := range spectrum {
+			spectrum[i] = complex(ring[(n+i)%fftSize]*window[i], 0)
+		}
+		fft(spectrum, twiddles)
+		for bin := 0; bin < height; bin++ {
+			db := 20 * math.Log10(cmplx.Abs(spectrum[bin])/fullScale+1e-12)
+			img.SetRGBA(column, height-1-bin, spectrogram_Color(1-db/spectrogramFloorDb))
+		}
+		column++
+	}
+
+	err := read_Frames(r, format, func(frame []float64) { add(mean(frame)) })
+	if err != nil {
+		return nil, err
+	}
+	// 끝부분 창은 0 으로 채운다
+	for column < width {
+		add(0)
+	}
+	return img, nil
+}
+
+// create_Waveform returns the waveform of the current attempt of item idx in
+// stage, from the cache when the audio hasn't changed since.
+func (app *App) create_Waveform(sessionId string, stage string, idx int, points int) (Waveform, error) {
+
+	ctx := context.TODO()
+	metadata, err := app.inspect_Audio(ctx, sessionId, stage, idx)
+	if err != nil {
+		return Waveform{}, err
+	}
+	cacheKey := visual_Key(metadata.Key, "waveform"+strconv.Itoa(points)+".json")
+
+	var waveform Waveform
+	fresh, err := app.cache_Fresh(ctx, cacheKey, metadata.Key)
+	if err != nil {
+		return Waveform{}, err
+	}
+	if fresh {
+		body, err := app.Storage.GetObject(ctx, cacheKey)
+		if err != nil {
+			return Waveform{}, err
+		}
+		defer body.Close()
+		err = json.NewDecoder(body).Decode(&waveform)
+		return waveform, err
+	}
+
+	metadata, format, body, err := app.open_Audio(ctx, sessionId, stage, idx)
+	if err != nil {
+		return Waveform{}, err
+	}
+	defer body.Close()
+
+	waveform, err = compute_Waveform(body, format, points)
+	if err != nil {
+		return Waveform{}, err
+	}
+	waveform.Key = metadata.Key
+	waveform.Attempt = metadata.Attempt
+
+	data, err := json.Marshal(waveform)
+	if err != nil {
+		return Waveform{}, err
+	}
+	return waveform, app.Storage.PutObject(ctx, cacheKey, bytes.NewReader(data))
+}
+
+// create_Spectrogram draws the spectrogram of the current attempt of item
+// idx in stage, unless a fresh one is cached, and presigns a GET for it.
+func (app *App) create_Spectrogram(sessionId string, stage string, idx int, width int, height int) (Spectrogram, error) {
+
+	ctx := context.TODO()
+	metadata, err := app.inspect_Audio(ctx, sessionId, stage, idx)
+	if err != nil {
+		return Spectrogram{}, err
+	}
+	spectrogram := Spectrogram{
+		Key:      metadata.Key,
+		Attempt:  metadata.Attempt,
+		ImageKey: visual_Key(metadata.Key, "spectrogram"+strconv.Itoa(width)+"x"+strconv.Itoa(height)+".png"),
+		Width:    width,
+		Height:   height,
+	}
+
+	fresh, err := app.cache_Fresh(ctx, spectrogram.ImageKey, metadata.Key)
+	if err != nil {
+		return Spectrogram{}, err
+	}
+	if !fresh {
+		_, format, body, err := app.open_Audio(ctx, sessionId, stage, idx)
+		if err != nil {
+			return Spectrogram{}, err
+		}
+		defer body.Close()
+
+		img, err := compute_Spectrogram(body, format, width, height)
+		if err != nil {
+			return Spectrogram{}, err
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return Spectrogram{}, err
+		}
+		if err := app.Storage.PutObject(ctx, spectrogram.ImageKey, &buf); err != nil {
+			return Spectrogram{}, err
+		}
+	}
+
+	spectrogram.Url, err = app.presign_Get(spectrogram.ImageKey, defaultPresignLifetimeSecs)
+	return spectrogram, err
+}
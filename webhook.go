@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrDeliveryNotFound is returned for an unknown webhook delivery id.
+var ErrDeliveryNotFound = errors.New("webhook delivery not found")
+
+// ErrCallbackHostBlocked is returned when a callback host resolves to a
+// loopback, private or link-local address that WEBHOOK_ALLOWED_HOSTS doesn't list.
+var ErrCallbackHostBlocked = errors.New("callback host is not allowed")
+
+// Webhook events, sent when a tracked job finishes and again once its text
+// (and, for videos, translation) has been written.
+const (
+	WebhookCompleted = "transcription.completed"
+	WebhookFailed    = "transcription.failed"
+	WebhookArtifacts = "artifacts.written"
+)
+
+// Webhook delivery outcomes.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookSignatureHeader carries "sha256=" and the hex HMAC-SHA256 of the
+// request body keyed with WEBHOOK_SECRET.
+const WebhookSignatureHeader = "X-Webhook-Signature"
+
+// WebhookPayload is the JSON body POSTed to a callback URL.
+type WebhookPayload struct {
+	DeliveryId string    `json:"deliveryId"`
+	Event      string    `json:"event"`
+	JobName    string    `json:"jobName"`
+	SessionId  string    `json:"sessionId,omitempty"`
+	Index      int       `json:"index"`
+	IsOriginal bool      `json:"isOriginal,omitempty"`
+	IsVideo    bool      `json:"isVideo,omitempty"`
+	Status     string    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	Artifacts  []string  `json:"artifacts,omitempty"`
+	SentAt     time.Time `json:"sentAt"`
+}
+
+// WebhookDelivery is the delivery log entry of one payload. The payload is
+// kept so a delivery still pending at a restart can be sent again.
+type WebhookDelivery struct {
+	DeliveryId string          `json:"deliveryId"`
+	Url        string          `json:"url"`
+	Event      string          `json:"event"`
+	JobName    string          `json:"jobName"`
+	SessionId  string          `json:"sessionId,omitempty"`
+	Outcome    string          `json:"outcome"`
+	Attempts   int             `json:"attempts"`
+	StatusCode int             `json:"statusCode,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Payload    *WebhookPayload `json:"payload,omitempty"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	UpdatedAt  time.Time       `json:"updatedAt"`
+}
+
+// Webhooks sends signed callbacks in the background. A delivery that gets
+// no 2xx answer is retried MaxAttempts times in all, waiting Backoff and
+// then twice as long after every failure.
+// Callbacks only reach public addresses, unless their host is one of
+// AllowedHosts.
+type Webhooks struct {
+	Client       *http.Client
+	Secret       string
+	MaxAttempts  int
+	Backoff      time.Duration
+	AllowedHosts map[string]bool
+
+	app     *App
+	dialer  net.Dialer
+	sending sync.WaitGroup
+}
+
+func new_Webhooks(app *App, secret string, maxAttempts int, allowedHosts []string) *Webhooks {
+
+	webhooks := &Webhooks{
+		Secret:       secret,
+		MaxAttempts:  maxAttempts,
+		Backoff:      2 * time.Second,
+		AllowedHosts: map[string]bool{},
+		app:          app,
+		dialer:       net.Dialer{Timeout: 10 * time.Second},
+	}
+	for _, host := range allowedHosts {
+		webhooks.AllowedHosts[strings.ToLower(host)] = true
+	}
+	// 프록시를 거치면 dial 검사를 우회하므로 쓰지 않는다
+	webhooks.Client = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &http.Transport{DialContext: webhooks.dial},
+	}
+	return webhooks
+}
+
+// blocked_Ip reports whether ip is an address callbacks must not reach:
+// loopback, private, link-local (such as 169.254.169.254), multicast or unspecified.
+func blocked_Ip(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// dial connects to addr unless its host is blocked. It connects to the very
+// address it checked, so a second DNS answer can't redirect the callback.
+func (webhooks *Webhooks) dial(ctx context.Context, network string, addr string) (net.Conn, error) {
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if webhooks.AllowedHosts[strings.ToLower(host)] {
+		return webhooks.dialer.DialContext(ctx, network, addr)
+	}
+
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if blocked_Ip(ip.IP) {
+			return nil, fmt.Errorf("%w: %v resolves to %v", ErrCallbackHostBlocked, host, ip.IP)
+		}
+	}
+	return webhooks.dialer.DialContext(ctx, network, net.JoinHostPort(ips[0].IP.String(), port))
+}
+
+// check_CallbackUrl rejects callback URLs the server can't deliver to.
+func (webhooks *Webhooks) check_CallbackUrl(callbackUrl string) error {
+
+	if webhooks.Secret == "" {
+		return errors.New("webhooks are not configured, set WEBHOOK_SECRET")
+	}
+	parsed, err := url.Parse(callbackUrl)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return fmt.Errorf("callbackUrl must be an absolute http(s) URL, got %q", callbackUrl)
+	}
+
+	// 이름으로 된 호스트는 보낼 때 dial 에서 다시 검사한다
+	host := strings.ToLower(parsed.Hostname())
+	if webhooks.AllowedHosts[host] {
+		return nil
+	}
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && blocked_Ip(ip)) {
+		return fmt.Errorf("%w: %v is a loopback, private or link-local address", ErrCallbackHostBlocked, host)
+	}
+	return nil
+}
+
+// sign returns the WebhookSignatureHeader value for body.
+func (webhooks *Webhooks) sign(body []byte) string {
+	mac := hmac.New(sha256.New, []byte(webhooks.Secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// send logs a delivery of payload to callbackUrl and delivers it in the background.
+func (webhooks *Webhooks) send(callbackUrl string, payload WebhookPayload) {
+
+	deliveryId, err := random_Id()
+	if err != nil {
+		log.Printf("Couldn't create webhook delivery id. Here's why: %v\n", err)
+		return
+	}
+
+	now := time.Now().UTC()
+	payload.DeliveryId = deliveryId
+	delivery := WebhookDelivery{
+		DeliveryId: deliveryId,
+		Url:        callbackUrl,
+		Event:      payload.Event,
+		JobName:    payload.JobName,
+		SessionId:  payload.SessionId,
+		Outcome:    DeliveryPending,
+		Payload:    &payload,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	webhooks.app.Store.save_Delivery(delivery)
+	webhooks.start(delivery, payload)
+}
+
+// resume_Pending sends again the deliveries a restart left pending. Those
+// logged without their payload can't be resent and are marked failed.
+func (webhooks *Webhooks) resume_Pending() {
+
+	for _, delivery := range webhooks.app.Store.pending_Deliveries() {
+		if delivery.Payload == nil {
+			delivery.Outcome = DeliveryFailed
+			delivery.Error = "interrupted by a restart and no payload was kept to resend"
+			delivery.UpdatedAt = time.Now().UTC()
+			webhooks.app.Store.save_Delivery(delivery)
+			continue
+		}
+		webhooks.start(delivery, *delivery.Payload)
+	}
+}
+
+// start delivers payload in the background.
+func (webhooks *Webhooks) start(delivery WebhookDelivery, payload WebhookPayload) {
+	webhooks.sending.Add(1)
+	go func() {
+		defer webhooks.sending.Done()
+		webhooks.deliver(delivery, payload)
+	}()
+}
+
+func (webhooks *Webhooks) deliver(delivery WebhookDelivery, payload WebhookPayload) {
+
+	backoff := webhooks.Backoff
+	for {
+		payload.SentAt = time.Now().UTC()
+		delivery.Attempts++
+		delivery.StatusCode, delivery.Error = webhooks.post(delivery.Url, payload)
+		delivery.UpdatedAt = time.Now().UTC()
+
+		if delivery.Error == "" {
+			delivery.Outcome = DeliveryDelivered
+			webhooks.app.Store.save_Delivery(delivery)
+			return
+		}
+		if delivery.Attempts >= webhooks.MaxAttempts {
+			log.Printf("Couldn't deliver webhook %v to %v. Here's why: %v\n", delivery.DeliveryId, delivery.Url, delivery.Error)
+			delivery.Outcome = DeliveryFailed
+			webhooks.app.Store.save_Delivery(delivery)
+			return
+		}
+		webhooks.app.Store.save_Delivery(delivery)
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends payload once and returns the status code and, unless it was 2xx, an error.
+func (webhooks *Webhooks) post(callbackUrl string, payload WebhookPayload) (int, string) {
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err.Error()
+	}
+
+	request, err := http.NewRequestWithContext(context.TODO(), http.MethodPost, callbackUrl, bytes.NewReader(body))
+	if err != nil {
+		return 0, err.Error()
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(WebhookSignatureHeader, webhooks.sign(body))
+	request.Header.Set("X-Webhook-Event", payload.Event)
+	request.Header.Set("X-Webhook-Delivery", payload.DeliveryId)
+
+	response, err := webhooks.Client.Do(request)
+	if err != nil {
+		return 0, err.Error()
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, "callback answered " + response.Status
+	}
+	return response.StatusCode, ""
+}
+
+// wait blocks until every delivery in flight has finished.
+func (webhooks *Webhooks) wait() {
+	webhooks.sending.Wait()
+}
+
+// notify_TrackedJob sends event for job to its callback URL, if it has one.
+func (app *App) notify_TrackedJob(job TrackedJob, event string) {
+
+	if job.CallbackUrl == "" {
+		return
+	}
+
+	payload := WebhookPayload{
+		Event:      event,
+		JobName:    job.JobName,
+		SessionId:  job.SessionId,
+		Index:      job.Index,
+		IsOriginal: job.IsOriginal,
+		IsVideo:    job.IsVideo,
+		Status:     job.Status,
+		Error:      job.Error,
+	}
+	if event == WebhookArtifacts {
+		payload.Artifacts = []string{job.ObjectKey + ".txt"}
+		if job.IsVideo {
+			payload.Artifacts = append(payload.Artifacts, job.ObjectKey+"_ko.txt")
+		}
+	}
+
+	app.Webhooks.send(job.CallbackUrl, payload)
+}